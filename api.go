@@ -0,0 +1,668 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ollamaAPIURL returns the base URL of the runner's Ollama API
+func ollamaAPIURL() string {
+	if url := externalOllama(); url != "" {
+		return url
+	}
+	if hostRuntime() {
+		return "http://" + hostAPIAddress()
+	}
+	return "http://" + net.JoinHostPort(runnerHostname(), strconv.Itoa(ollamaPort()))
+}
+
+// apiClient talks to the Ollama HTTP API. Generation can take arbitrarily
+// long, so there is no overall timeout
+var apiClient = &http.Client{}
+
+// apiGet sends a GET request to the Ollama API
+func apiGet(ctx context.Context, path string) (*http.Response, error) {
+	return apiDo(ctx, http.MethodGet, path, nil)
+}
+
+// apiPost sends a JSON body to the Ollama API
+func apiPost(ctx context.Context, path string, body []byte) (*http.Response, error) {
+	return apiDo(ctx, http.MethodPost, path, body)
+}
+
+// apiDo sends a request to the Ollama API. Failing to connect, as while
+// Ollama is still starting, is retried with backoff. Other backends only
+// serve the OpenAI-compatible API, so Ollama's own is refused for them
+func apiDo(ctx context.Context, method, path string, body []byte) (*http.Response, error) {
+	if backend := activeBackend(); backend.Name() != "ollama" && strings.HasPrefix(path, "/api/") {
+		return nil, fmt.Errorf("%w: this needs Ollama's API, but the runner serves models with %s", errUnsupportedByBackend, backend.Name())
+	}
+	var resp *http.Response
+	err := withRetry(ctx, isTransient, func() error {
+		req, err := http.NewRequestWithContext(ctx, method, ollamaAPIURL()+path, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		if body != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err = apiClient.Do(req)
+		return err
+	})
+	return resp, err
+}
+
+// generateRequest is the body of a POST to /api/generate
+type generateRequest struct {
+	Model     string         `json:"model"`
+	Prompt    string         `json:"prompt"`
+	System    string         `json:"system,omitempty"`
+	Stream    bool           `json:"stream"`
+	KeepAlive any            `json:"keep_alive,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+	Format    any            `json:"format,omitempty"` // "json" or a JSON schema
+	Images    []string       `json:"images,omitempty"` // base64-encoded
+}
+
+// generateStats holds the timing and token counters Ollama reports once a
+// generation is done. Durations are in nanoseconds
+type generateStats struct {
+	TotalDuration      int64 `json:"total_duration"`
+	LoadDuration       int64 `json:"load_duration"`
+	PromptEvalCount    int   `json:"prompt_eval_count"`
+	PromptEvalDuration int64 `json:"prompt_eval_duration"`
+	EvalCount          int   `json:"eval_count"`
+	EvalDuration       int64 `json:"eval_duration"`
+}
+
+// generateResponse is a single streamed chunk from /api/generate
+type generateResponse struct {
+	Model    string `json:"model"`
+	Response string `json:"response"`
+	Done     bool   `json:"done"`
+	Error    string `json:"error"`
+	generateStats
+}
+
+// keepAliveValue converts a keep-alive flag value into what the API expects:
+// bare numbers are seconds (with negatives meaning forever), anything else is
+// a Go duration string
+func keepAliveValue(s string) any {
+	if s == "" {
+		return nil
+	}
+	if n, err := strconv.ParseFloat(s, 64); err == nil {
+		return n
+	}
+	return s
+}
+
+// generate streams a completion for the request to w and returns the final
+// stats reported by Ollama. If stallTimeout is set, generation is cancelled
+// when no new token arrives within that window after the first one.
+// Cancelling ctx stops generation and returns ctx's error
+func generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	req.Stream = true
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resp, err := apiPost(ctx, "/api/generate", body)
+	if err != nil {
+		if parent.Err() != nil {
+			return nil, parent.Err()
+		}
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	// The stall timer is armed by the first token and reset by every one after
+	var stalled atomic.Bool
+	var stallTimer *time.Timer
+	defer func() {
+		if stallTimer != nil {
+			stallTimer.Stop()
+		}
+	}()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	for scanner.Scan() {
+		var chunk generateResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if chunk.Error != "" {
+			return nil, fmt.Errorf("generation failed: %s", chunk.Error)
+		}
+
+		if _, err := io.WriteString(w, chunk.Response); err != nil {
+			return nil, err
+		}
+
+		if chunk.Done {
+			return &chunk.generateStats, nil
+		}
+
+		if stallTimeout > 0 && chunk.Response != "" {
+			if stallTimer == nil {
+				stallTimer = time.AfterFunc(stallTimeout, func() {
+					stalled.Store(true)
+					cancel()
+				})
+			} else {
+				stallTimer.Reset(stallTimeout)
+			}
+		}
+	}
+
+	if stalled.Load() {
+		return nil, fmt.Errorf("generation stalled: no new token for %s", stallTimeout)
+	}
+	if parent.Err() != nil {
+		return nil, parent.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return nil, fmt.Errorf("response ended before generation finished")
+}
+
+// chatMessage is a message in a conversation
+type chatMessage struct {
+	Role    string   `json:"role"`
+	Content string   `json:"content"`
+	Images  []string `json:"images,omitempty"` // base64-encoded
+}
+
+// chatRequest is the body of a POST to /api/chat
+type chatRequest struct {
+	Model     string         `json:"model"`
+	Messages  []chatMessage  `json:"messages"`
+	Stream    bool           `json:"stream"`
+	KeepAlive any            `json:"keep_alive,omitempty"`
+	Options   map[string]any `json:"options,omitempty"`
+}
+
+// chatResponse is a single streamed chunk from /api/chat
+type chatResponse struct {
+	Message chatMessage `json:"message"`
+	Done    bool        `json:"done"`
+	Error   string      `json:"error"`
+	generateStats
+}
+
+// chat streams the model's reply to a conversation to w and returns it
+// with the final stats. Cancelling ctx stops generation; whatever was
+// generated so far is returned along with ctx's error
+func chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	req.Stream = true
+	reply := chatMessage{Role: "assistant"}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return reply, nil, err
+	}
+
+	resp, err := apiPost(ctx, "/api/chat", body)
+	if err != nil {
+		if ctx.Err() != nil {
+			return reply, nil, ctx.Err()
+		}
+		return reply, nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return reply, nil, apiError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var chunk chatResponse
+		if err := json.Unmarshal(scanner.Bytes(), &chunk); err != nil {
+			return reply, nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if chunk.Error != "" {
+			return reply, nil, fmt.Errorf("generation failed: %s", chunk.Error)
+		}
+
+		reply.Content += chunk.Message.Content
+		if _, err := io.WriteString(w, chunk.Message.Content); err != nil {
+			return reply, nil, err
+		}
+		if chunk.Done {
+			return reply, &chunk.generateStats, nil
+		}
+	}
+
+	if ctx.Err() != nil {
+		return reply, nil, ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return reply, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return reply, nil, fmt.Errorf("response ended before generation finished")
+}
+
+// waitForOllama polls the runner's API until it answers, the timeout
+// elapses or ctx is cancelled
+func waitForOllama(ctx context.Context, timeout time.Duration) error {
+	return waitForAPI(ctx, activeBackend().ReadyPath(), timeout)
+}
+
+// waitForAPI polls a path of the runner's API until it answers, the timeout
+// elapses or ctx is cancelled
+func waitForAPI(ctx context.Context, path string, timeout time.Duration) error {
+	probe := &http.Client{Timeout: 2 * time.Second}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, ollamaAPIURL()+path, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := probe.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+
+		if time.Now().After(deadline) {
+			err := fmt.Errorf("the Mocker Model Runner container started but its API at %s did not become ready within %s; check `docker logs %s`", ollamaAPIURL(), timeout, containerName())
+			return classify(err, errBackendUnreachable)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+}
+
+// localModel is a model entry from /api/tags
+type localModel struct {
+	Name       string    `json:"name"`
+	Digest     string    `json:"digest"`
+	Size       int64     `json:"size"`
+	ModifiedAt time.Time `json:"modified_at"`
+}
+
+// listLocalModels returns the models installed in the runner
+func listLocalModels(ctx context.Context) ([]localModel, error) {
+	resp, err := apiGet(ctx, "/api/tags")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var tags struct {
+		Models []localModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	return tags.Models, nil
+}
+
+// runningModel is a loaded model entry from /api/ps
+type runningModel struct {
+	Name      string    `json:"name"`
+	Size      int64     `json:"size"`
+	SizeVRAM  int64     `json:"size_vram"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// listRunningModels returns the models currently loaded into memory
+func listRunningModels(ctx context.Context) ([]runningModel, error) {
+	resp, err := apiGet(ctx, "/api/ps")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var ps struct {
+		Models []runningModel `json:"models"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&ps); err != nil {
+		return nil, fmt.Errorf("failed to decode running models: %w", err)
+	}
+	return ps.Models, nil
+}
+
+// showResponse is the subset of a /api/show response mocker uses
+type showResponse struct {
+	License    string         `json:"license"`
+	System     string         `json:"system"`
+	Template   string         `json:"template"`
+	Parameters string         `json:"parameters"`
+	Details    showDetails    `json:"details"`
+	ModelInfo  map[string]any `json:"model_info"`
+}
+
+// showDetails is the summary block of a /api/show response
+type showDetails struct {
+	ParentModel       string   `json:"parent_model"`
+	Format            string   `json:"format"`
+	Family            string   `json:"family"`
+	Families          []string `json:"families"`
+	ParameterSize     string   `json:"parameter_size"`
+	QuantizationLevel string   `json:"quantization_level"`
+}
+
+// showModel fetches the metadata of an installed model
+func showModel(ctx context.Context, name string) (*showResponse, error) {
+	body, err := json.Marshal(map[string]string{"model": name})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := apiPost(ctx, "/api/show", body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", errModelNotFound, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var show showResponse
+	if err := json.NewDecoder(resp.Body).Decode(&show); err != nil {
+		return nil, fmt.Errorf("failed to decode model details: %w", err)
+	}
+	return &show, nil
+}
+
+// pullResponse is a single streamed progress update from /api/pull or /api/push
+type pullResponse struct {
+	Status    string `json:"status"`
+	Digest    string `json:"digest"`
+	Total     int64  `json:"total"`
+	Completed int64  `json:"completed"`
+	Error     string `json:"error"`
+}
+
+// pullModelAPI pulls a model into the runner, calling fn with every progress
+// update as it arrives
+func pullModelAPI(ctx context.Context, name string, fn func(pullResponse)) error {
+	return streamTransfer(ctx, "/api/pull", map[string]any{"model": name, "stream": true}, fn)
+}
+
+// pushModelAPI pushes a model from the runner to its registry, calling fn
+// with every progress update as it arrives
+func pushModelAPI(ctx context.Context, name string, insecure bool, fn func(pullResponse)) error {
+	return streamTransfer(ctx, "/api/push", map[string]any{"model": name, "insecure": insecure, "stream": true}, fn)
+}
+
+// errTransferInterrupted reports a pull or push whose progress stream ended
+// before Ollama reported success
+var errTransferInterrupted = errors.New("transfer interrupted")
+
+// transientErrorRegex matches errors Ollama reports when its own connection
+// to the registry fails, which are worth retrying
+var transientErrorRegex = regexp.MustCompile(`(?i)EOF|connection (reset|refused)|timeout|timed out|temporar|max retries|TLS handshake|no such host|network is unreachable`)
+
+// isTransient reports whether a failed pull or push might succeed if retried
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, errTransferInterrupted) {
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+	return transientErrorRegex.MatchString(err.Error())
+}
+
+// streamTransfer starts a pull or push and relays its streamed progress
+func streamTransfer(ctx context.Context, path string, req map[string]any, fn func(pullResponse)) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+
+	resp, err := apiPost(ctx, path, body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	var last string
+	for scanner.Scan() {
+		var update pullResponse
+		if err := json.Unmarshal(scanner.Bytes(), &update); err != nil {
+			return fmt.Errorf("failed to decode progress: %w", err)
+		}
+		if update.Error != "" {
+			return fmt.Errorf("ollama API error: %s", update.Error)
+		}
+		last = update.Status
+		fn(update)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read progress: %w", err)
+	}
+	if last != "success" {
+		return errTransferInterrupted
+	}
+	return nil
+}
+
+// ollamaVersion returns the version of Ollama running in the runner
+func ollamaVersion(ctx context.Context) (string, error) {
+	resp, err := apiGet(ctx, "/api/version")
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", apiError(resp)
+	}
+
+	var version struct {
+		Version string `json:"version"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&version); err != nil {
+		return "", fmt.Errorf("failed to decode version: %w", err)
+	}
+	return version.Version, nil
+}
+
+// loadModel asks Ollama to load a model into memory without generating
+// anything, surfacing missing or unloadable models before a run starts
+func loadModel(ctx context.Context, model string, keepAlive any) error {
+	body, err := json.Marshal(generateRequest{Model: model, KeepAlive: keepAlive})
+	if err != nil {
+		return err
+	}
+
+	resp, err := apiPost(ctx, "/api/generate", body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// embed returns an embedding vector for each input, in order
+func embed(ctx context.Context, model string, inputs []string, keepAlive any) ([][]float64, error) {
+	req := map[string]any{"model": model, "input": inputs}
+	if keepAlive != nil {
+		req["keep_alive"] = keepAlive
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := apiPost(ctx, "/api/embed", body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var result struct {
+		Embeddings [][]float64 `json:"embeddings"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if len(result.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("ollama returned %d embeddings for %d inputs", len(result.Embeddings), len(inputs))
+	}
+	return result.Embeddings, nil
+}
+
+// copyModel copies an installed model to a new name. The copy shares the
+// original's layers, so it takes no extra space
+func copyModel(ctx context.Context, source, destination string) error {
+	body, err := json.Marshal(map[string]string{"source": source, "destination": destination})
+	if err != nil {
+		return err
+	}
+
+	resp, err := apiPost(ctx, "/api/copy", body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errModelNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// deleteModel removes a model from the runner
+func deleteModel(ctx context.Context, name string) error {
+	body, err := json.Marshal(map[string]string{"model": name})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodDelete, ollamaAPIURL()+"/api/delete", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %s", errModelNotFound, name)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+	return nil
+}
+
+// errModelNotFound is returned when an operation targets a model the runner
+// doesn't have
+var errModelNotFound = errors.New("model not found")
+
+// recoverableLoadErrors are the Ollama error fragments that mean a model
+// can't be used here, as opposed to a problem with the request itself
+var recoverableLoadErrors = []string{
+	"not found",
+	"out of memory",
+	"requires more system memory",
+	"unable to allocate",
+	"unable to load model",
+	"llama runner process has terminated",
+}
+
+// isRecoverableLoadError reports whether a model load failure is worth
+// retrying with a different model
+func isRecoverableLoadError(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, fragment := range recoverableLoadErrors {
+		if strings.Contains(msg, fragment) {
+			return true
+		}
+	}
+	return false
+}
+
+// apiError turns a non-200 API response into an error, using the message
+// Ollama includes in the body when there is one
+func apiError(resp *http.Response) error {
+	var body struct {
+		Error string `json:"error"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("ollama API returned %s", resp.Status)
+	if json.Unmarshal(data, &body) == nil && body.Error != "" {
+		err = fmt.Errorf("ollama API error: %s", body.Error)
+	}
+	// Ollama answers 404 when the model a request names isn't installed
+	if resp.StatusCode == http.StatusNotFound {
+		return classify(err, errModelNotFound)
+	}
+	return err
+}
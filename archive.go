@@ -0,0 +1,153 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Model archives are laid out like Ollama's models directory, with each
+// model's blobs ahead of its manifest so an import can verify them first:
+//
+//	blobs/sha256-<hex>
+//	manifests/<registry>/<namespace>/<model>/<tag>
+
+// exportModels writes installed models to a tar archive. Blobs shared
+// between the models are only written once
+func exportModels(ctx context.Context, w io.Writer, models []string, progress *pullProgress) error {
+	tw := tar.NewWriter(w)
+	written := map[string]bool{}
+
+	for _, name := range models {
+		ref := parseModelReference(name)
+		raw, err := localManifest(ref)
+		if err != nil {
+			return err
+		}
+		var m manifest
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return fmt.Errorf("failed to parse manifest of %s: %w", name, err)
+		}
+
+		for _, layer := range append(m.Layers, m.Config) {
+			if written[layer.Digest] {
+				continue
+			}
+			if err := exportBlob(ctx, tw, layer, progress); err != nil {
+				return err
+			}
+			written[layer.Digest] = true
+		}
+
+		hdr := &tar.Header{
+			Name: fmt.Sprintf("manifests/%s/%s/%s", ref.Registry, ref.Repository, ref.Tag),
+			Mode: 0o644,
+			Size: int64(len(raw)),
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := tw.Write(raw); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// exportBlob copies a blob out of the runner into the archive
+func exportBlob(ctx context.Context, tw *tar.Writer, layer manifestLayer, progress *pullProgress) error {
+	file := strings.Replace(layer.Digest, ":", "-", 1)
+	blob, size, err := docker.OpenFile(ctx, containerName(), ModelsDir+"/blobs/"+file)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", layer.Digest, err)
+	}
+	defer blob.Close()
+
+	if err := tw.WriteHeader(&tar.Header{Name: "blobs/" + file, Mode: 0o644, Size: size}); err != nil {
+		return err
+	}
+	body := &countingReader{r: blob, fn: func(n int64) {
+		progress.update(layer.Digest, float64(n), float64(size))
+	}}
+	if _, err := io.Copy(tw, body); err != nil {
+		return fmt.Errorf("failed to export blob %s: %w", layer.Digest, err)
+	}
+	return nil
+}
+
+// importModels installs the models in an archive written by exportModels,
+// returning their names. Every blob is verified against its digest, and a
+// model is only installed once all its blobs are in place
+func importModels(ctx context.Context, r io.Reader, progress *pullProgress) ([]string, error) {
+	present, err := blobSizes()
+	if err != nil {
+		return nil, err
+	}
+
+	var imported []string
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		parts := strings.Split(hdr.Name, "/")
+		for _, part := range parts {
+			if part == "" || part == "." || part == ".." {
+				return imported, fmt.Errorf("not a model archive: invalid entry %s", hdr.Name)
+			}
+		}
+
+		switch {
+		case len(parts) == 2 && parts[0] == "blobs" && strings.HasPrefix(parts[1], "sha256-"):
+			layer := manifestLayer{Digest: strings.Replace(parts[1], "-", ":", 1), Size: hdr.Size}
+			if present[layer.Digest] == layer.Size {
+				progress.update(layer.Digest, float64(layer.Size), float64(layer.Size))
+				continue
+			}
+			if err := writeBlob(ctx, layer, tr, progress); err != nil {
+				return imported, err
+			}
+			present[layer.Digest] = layer.Size
+
+		case len(parts) == 5 && parts[0] == "manifests":
+			ref := modelReference{Registry: parts[1], Repository: parts[2] + "/" + parts[3], Tag: parts[4]}
+			raw, err := io.ReadAll(tr)
+			if err != nil {
+				return imported, fmt.Errorf("failed to read archive: %w", err)
+			}
+			var m manifest
+			if err := json.Unmarshal(raw, &m); err != nil {
+				return imported, fmt.Errorf("invalid manifest for %s: %w", ref, err)
+			}
+			for _, layer := range append(m.Layers, m.Config) {
+				if present[layer.Digest] != layer.Size {
+					return imported, fmt.Errorf("archive is missing blob %s of %s", layer.Digest, ref)
+				}
+			}
+			if err := writeManifest(ref, raw); err != nil {
+				return imported, err
+			}
+			imported = append(imported, ref.Short())
+
+		default:
+			return imported, fmt.Errorf("not a model archive: unexpected entry %s", hdr.Name)
+		}
+	}
+
+	if len(imported) == 0 {
+		return nil, fmt.Errorf("no models found in archive")
+	}
+	return imported, nil
+}
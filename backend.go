@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BackendLabel records which backend a runner container serves models with
+const BackendLabel = "mocker.backend"
+
+// ModelLabel records the model a single-model backend serves
+const ModelLabel = "mocker.model"
+
+// DefaultBackend is the backend a runner is created with unless told otherwise
+const DefaultBackend = "ollama"
+
+// HubReadyTimeout is the least a new runner that downloads its model from
+// the Hugging Face hub is given to become ready, as that can take a while
+const HubReadyTimeout = 30 * time.Minute
+
+// errUnsupportedByBackend is returned for what only Ollama can do, when the
+// runner serves models with another backend
+var errUnsupportedByBackend = errors.New("not supported by this backend")
+
+// modelBackend is the inference server behind the runner. Ollama is the
+// default; the commands that pull, list and run models go through this, so
+// they work the same whichever backend the runner was created with
+type modelBackend interface {
+	// Name is how the backend is chosen with --backend
+	Name() string
+	// ReadyPath is the API path that answers once the backend is ready
+	ReadyPath() string
+	// List returns the models the backend can serve
+	List(ctx context.Context) ([]localModel, error)
+	// Pull fetches a model so it can be served, calling fn with progress
+	Pull(ctx context.Context, name string, fn func(pullResponse)) error
+	// Generate streams a completion for a single prompt to w
+	Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error)
+	// Chat streams the reply to a conversation to w
+	Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error)
+	// Embed returns an embedding vector for each input, in order. keepAlive
+	// is how long the model stays loaded afterwards, where the backend
+	// supports it
+	Embed(ctx context.Context, model string, inputs []string, keepAlive any) ([][]float64, error)
+}
+
+// backends are the backends a runner can be created with
+var backends = []modelBackend{ollamaBackend{}, vllmBackend{}, llamaCppBackend{}, tgiBackend{}, localAIBackend{}}
+
+// backendNamed returns the backend called name
+func backendNamed(name string) (modelBackend, error) {
+	var names []string
+	for _, b := range backends {
+		if b.Name() == name {
+			return b, nil
+		}
+		names = append(names, b.Name())
+	}
+	return nil, fmt.Errorf("unknown backend %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+// activeBackend returns the backend the runner serves models with, from the
+// label it was created with. It's looked up once; an external or host
+// Ollama, or a runner from before backends existed, is Ollama
+var activeBackend = sync.OnceValue(func() modelBackend {
+	if externalOllama() != "" || hostRuntime() {
+		return ollamaBackend{}
+	}
+	if b, err := backendNamed(containerLabel(BackendLabel)); err == nil {
+		return b
+	}
+	return ollamaBackend{}
+})
+
+// huggingFaceVolume is the volume backends that serve Hugging Face models
+// keep the hub's cache in, so models downloaded for one are there for another
+func huggingFaceVolume() string {
+	return volumeName() + "-huggingface"
+}
+
+// huggingFaceEnv passes a Hugging Face token in the environment on to a
+// runner, so it can download gated models
+func huggingFaceEnv() []string {
+	var env []string
+	for _, name := range []string{"HF_TOKEN", "HUGGING_FACE_HUB_TOKEN"} {
+		if value := os.Getenv(name); value != "" {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// ollamaBackend serves models with Ollama, through its native API
+type ollamaBackend struct{}
+
+func (ollamaBackend) Name() string      { return "ollama" }
+func (ollamaBackend) ReadyPath() string { return "/api/tags" }
+
+func (ollamaBackend) List(ctx context.Context) ([]localModel, error) {
+	return listLocalModels(ctx)
+}
+
+func (ollamaBackend) Pull(ctx context.Context, name string, fn func(pullResponse)) error {
+	return pullModelAPI(ctx, name, fn)
+}
+
+func (ollamaBackend) Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	return generate(ctx, req, w, stallTimeout)
+}
+
+func (ollamaBackend) Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	return chat(ctx, req, w)
+}
+
+func (ollamaBackend) Embed(ctx context.Context, model string, inputs []string, keepAlive any) ([][]float64, error) {
+	return embed(ctx, model, inputs, keepAlive)
+}
@@ -0,0 +1,327 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HealthCheckInterval is how often serve checks the runners it balances
+// requests across
+const HealthCheckInterval = 5 * time.Second
+
+// balanceStrategies are the ways serve can pick a runner for a request
+var balanceStrategies = []string{"round-robin", "least-loaded"}
+
+// upstream is a runner serve balances requests across
+type upstream struct {
+	name  string
+	url   *url.URL
+	proxy *httputil.ReverseProxy
+
+	healthy  atomic.Bool
+	inFlight atomic.Int64
+
+	mu     sync.RWMutex
+	models map[modelReference]bool // the models it serves, as of the last check
+}
+
+// serves reports whether the runner had model at the last health check
+func (u *upstream) serves(model string) bool {
+	u.mu.RLock()
+	defer u.mu.RUnlock()
+	return u.models[parseModelReference(model)]
+}
+
+// balancer spreads requests across several runners, sending each to one
+// that serves the model it names. Runners that stop answering are ejected
+// until a health check finds them answering again
+type balancer struct {
+	out         io.Writer
+	allow       func(string) bool
+	queue       *requestQueue
+	upstreams   []*upstream
+	leastLoaded bool
+	next        atomic.Uint64
+}
+
+// runnerUpstreams resolves runner names to the APIs they publish. "all" is
+// every runner there is
+func runnerUpstreams(names []string) ([]*upstream, error) {
+	if slices.Contains(names, "all") {
+		states, err := docker.ListLabeled(RunnerLabel)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list runners: %w", err)
+		}
+		names = nil
+		for _, state := range states {
+			names = append(names, state.Labels[RunnerLabel])
+		}
+		slices.Sort(names)
+	}
+
+	var upstreams []*upstream
+	for _, name := range slices.Compact(names) {
+		container := runnerContainerName(name)
+		state, ok, err := docker.Inspect(container)
+		if err != nil {
+			return nil, fmt.Errorf("failed to inspect runner %s: %w", name, err)
+		}
+		if !ok {
+			return nil, fmt.Errorf("no runner named %q; create it with `docker model runner create %s`", name, name)
+		}
+		if !state.Running {
+			if err := docker.Start(container); err != nil {
+				return nil, fmt.Errorf("failed to start runner %s: %w", name, err)
+			}
+			if state, _, err = docker.Inspect(container); err != nil {
+				return nil, fmt.Errorf("failed to inspect runner %s: %w", name, err)
+			}
+		}
+		if state.HostPort == 0 {
+			return nil, fmt.Errorf("runner %s doesn't publish its API on a host port", name)
+		}
+		target := &url.URL{Scheme: "http", Host: net.JoinHostPort(runnerHostname(), strconv.Itoa(state.HostPort))}
+		upstreams = append(upstreams, &upstream{name: name, url: target})
+	}
+	if len(upstreams) == 0 {
+		return nil, fmt.Errorf("there are no runners to balance across; create some with `docker model runner create <name>`")
+	}
+	return upstreams, nil
+}
+
+// newBalancedProxy returns a handler that spreads requests across upstreams,
+// logging each one to out along with the runner that served it. Model
+// listings are merged from every healthy runner. If allowed is non-empty,
+// only those models can be used or listed. Requests for a model wait for a
+// slot in queue, shared by every runner
+func newBalancedProxy(ctx context.Context, out io.Writer, allowed []string, queue *requestQueue, upstreams []*upstream, strategy string) (http.Handler, error) {
+	if !slices.Contains(balanceStrategies, strategy) {
+		return nil, fmt.Errorf("invalid --balance value %q: must be one of %s", strategy, strings.Join(balanceStrategies, ", "))
+	}
+
+	b := &balancer{out: out, allow: modelAllowlist(allowed), queue: queue, upstreams: upstreams, leastLoaded: strategy == "least-loaded"}
+	for _, u := range upstreams {
+		u.proxy = newReverseProxy(u.url, b.allow)
+		u.proxy.ErrorHandler = func(w http.ResponseWriter, r *http.Request, err error) {
+			if r.Context().Err() == nil {
+				b.eject(u, err)
+			}
+			writeProxyError(w, r, http.StatusBadGateway, "server_error", "runner_unavailable", fmt.Sprintf("runner %s failed: %v", u.name, err))
+		}
+	}
+
+	b.checkHealth(ctx)
+	go func() {
+		ticker := time.NewTicker(HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				b.checkHealth(ctx)
+			}
+		}
+	}()
+
+	return proxyHandler(out, b.allow, b.serve), nil
+}
+
+// serve passes a request on to a runner, or merges the model listings of
+// all of them
+func (b *balancer) serve(w http.ResponseWriter, r *http.Request, model string) string {
+	if _, _, ok := modelListing(r.URL.Path); ok && r.Method == http.MethodGet {
+		b.serveListing(w, r)
+		return "all"
+	}
+
+	// A runner is picked once there's a slot, so least-loaded sees the
+	// load as it is then
+	release, ok := b.queue.admit(w, r, model)
+	if !ok {
+		return ""
+	}
+	defer release()
+	u := b.pick(model)
+	if u == nil {
+		writeProxyError(w, r, http.StatusServiceUnavailable, "server_error", "runner_unavailable", "no runner is available")
+		return ""
+	}
+	u.inFlight.Add(1)
+	defer u.inFlight.Add(-1)
+	u.proxy.ServeHTTP(w, r)
+	return u.name
+}
+
+// pick chooses a healthy runner for a request, preferring those that serve
+// its model. Runners are taken in turn or, with least-loaded, the one with
+// the fewest requests in flight
+func (b *balancer) pick(model string) *upstream {
+	var healthy, serving []*upstream
+	for _, u := range b.upstreams {
+		if !u.healthy.Load() {
+			continue
+		}
+		healthy = append(healthy, u)
+		if model != "" && u.serves(model) {
+			serving = append(serving, u)
+		}
+	}
+	candidates := serving
+	if len(candidates) == 0 {
+		// Nothing has the model yet, so whichever runner gets the request
+		// answers for it, pulling or reporting it missing
+		candidates = healthy
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Ties go round the runners in turn, so load spreads evenly
+	start := int(b.next.Add(1) - 1)
+	chosen := candidates[start%len(candidates)]
+	if b.leastLoaded {
+		for i := range candidates {
+			u := candidates[(start+i)%len(candidates)]
+			if u.inFlight.Load() < chosen.inFlight.Load() {
+				chosen = u
+			}
+		}
+	}
+	return chosen
+}
+
+// checkHealth asks every runner for its models, ejecting those that don't
+// answer and bringing back those that do again
+func (b *balancer) checkHealth(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, u := range b.upstreams {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			models, err := upstreamModels(ctx, u)
+			if err != nil {
+				if ctx.Err() == nil {
+					b.eject(u, err)
+				}
+				return
+			}
+			u.mu.Lock()
+			u.models = models
+			u.mu.Unlock()
+			if !u.healthy.Swap(true) {
+				_, _ = fmt.Fprintf(b.out, "Runner %s is healthy, routing requests to it\n", u.name)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// eject stops sending requests to a runner until it next passes a health
+// check
+func (b *balancer) eject(u *upstream, err error) {
+	if u.healthy.Swap(false) {
+		_, _ = fmt.Fprintf(b.out, "Runner %s is unhealthy, no longer routing requests to it: %v\n", u.name, err)
+	}
+}
+
+// upstreamModels returns the models a runner serves, from the OpenAI model
+// listing every backend offers
+func upstreamModels(ctx context.Context, u *upstream) (map[modelReference]bool, error) {
+	var list struct {
+		Data []struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := getUpstreamJSON(ctx, u, "/v1/models", &list); err != nil {
+		return nil, err
+	}
+	models := make(map[modelReference]bool, len(list.Data))
+	for _, m := range list.Data {
+		models[parseModelReference(m.ID)] = true
+	}
+	return models, nil
+}
+
+// getUpstreamJSON decodes the response to a GET of path, and any query, on
+// a runner
+func getUpstreamJSON(ctx context.Context, u *upstream, path string, v any) error {
+	ctx, cancel := context.WithTimeout(ctx, HealthCheckInterval)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.url.String()+path, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// serveListing answers a model listing with the models of every healthy
+// runner, each listed once. Runners that can't answer it, such as those
+// without Ollama's API, are left out
+func (b *balancer) serveListing(w http.ResponseWriter, r *http.Request) {
+	key, field, _ := modelListing(r.URL.Path)
+
+	var merged map[string]json.RawMessage
+	var entries []map[string]any
+	seen := map[string]bool{}
+	for _, u := range b.upstreams {
+		if !u.healthy.Load() {
+			continue
+		}
+		var list map[string]json.RawMessage
+		var listed []map[string]any
+		if getUpstreamJSON(r.Context(), u, r.URL.RequestURI(), &list) != nil || json.Unmarshal(list[key], &listed) != nil {
+			continue
+		}
+		if merged == nil {
+			merged = list
+		}
+		for _, entry := range listed {
+			name, _ := entry[field].(string)
+			if seen[name] || (b.allow != nil && !b.allow(name)) {
+				continue
+			}
+			seen[name] = true
+			entries = append(entries, entry)
+		}
+	}
+	if merged == nil {
+		writeProxyError(w, r, http.StatusServiceUnavailable, "server_error", "runner_unavailable", "no runner is available")
+		return
+	}
+
+	if entries == nil {
+		entries = []map[string]any{}
+	}
+	data, err := json.Marshal(entries)
+	if err == nil {
+		merged[key] = data
+		data, err = json.Marshal(merged)
+	}
+	if err != nil {
+		writeProxyError(w, r, http.StatusInternalServerError, "server_error", "internal_error", err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
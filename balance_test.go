@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// testUpstream is a runner as the balancer last saw it
+func testUpstream(name string, healthy bool, inFlight int64, models ...string) *upstream {
+	u := &upstream{name: name, models: map[modelReference]bool{}}
+	u.healthy.Store(healthy)
+	u.inFlight.Store(inFlight)
+	for _, model := range models {
+		u.models[parseModelReference(model)] = true
+	}
+	return u
+}
+
+func TestBalancerPick(t *testing.T) {
+	tests := []struct {
+		name        string
+		upstreams   []*upstream
+		leastLoaded bool
+		model       string
+		want        []string // the runners picked by successive requests
+	}{
+		{
+			name:      "round-robin takes runners in turn",
+			upstreams: []*upstream{testUpstream("a", true, 0), testUpstream("b", true, 0), testUpstream("c", true, 0)},
+			want:      []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "round-robin ignores load",
+			upstreams: []*upstream{testUpstream("a", true, 5), testUpstream("b", true, 0), testUpstream("c", true, 0)},
+			want:      []string{"a", "b", "c"},
+		},
+		{
+			name:      "round-robin skips unhealthy runners",
+			upstreams: []*upstream{testUpstream("a", true, 0), testUpstream("b", false, 0), testUpstream("c", true, 0)},
+			want:      []string{"a", "c", "a"},
+		},
+		{
+			name:        "least-loaded takes the fewest in flight",
+			upstreams:   []*upstream{testUpstream("a", true, 2), testUpstream("b", true, 0), testUpstream("c", true, 1)},
+			leastLoaded: true,
+			want:        []string{"b", "b", "b"},
+		},
+		{
+			name:        "least-loaded ties go round in turn",
+			upstreams:   []*upstream{testUpstream("a", true, 1), testUpstream("b", true, 1), testUpstream("c", true, 1)},
+			leastLoaded: true,
+			want:        []string{"a", "b", "c", "a"},
+		},
+		{
+			name:      "runners serving the model are preferred",
+			upstreams: []*upstream{testUpstream("a", true, 0), testUpstream("b", true, 0, "llama3.2"), testUpstream("c", true, 0, "llama3.2:latest")},
+			model:     "llama3.2",
+			want:      []string{"b", "c", "b"},
+		},
+		{
+			name:        "least-loaded prefers serving the model over load",
+			upstreams:   []*upstream{testUpstream("a", true, 0), testUpstream("b", true, 3, "llama3.2")},
+			leastLoaded: true,
+			model:       "llama3.2",
+			want:        []string{"b", "b"},
+		},
+		{
+			name:      "an unhealthy runner serving the model is passed over",
+			upstreams: []*upstream{testUpstream("a", true, 0), testUpstream("b", false, 0, "llama3.2"), testUpstream("c", true, 0)},
+			model:     "llama3.2",
+			want:      []string{"a", "c", "a"},
+		},
+		{
+			name:      "any healthy runner when none serves the model",
+			upstreams: []*upstream{testUpstream("a", true, 0, "gemma3"), testUpstream("b", true, 0)},
+			model:     "llama3.2",
+			want:      []string{"a", "b", "a"},
+		},
+		{
+			name:      "nothing when no runner is healthy",
+			upstreams: []*upstream{testUpstream("a", false, 0, "llama3.2"), testUpstream("b", false, 0)},
+			model:     "llama3.2",
+			want:      []string{""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := &balancer{upstreams: tt.upstreams, leastLoaded: tt.leastLoaded}
+			var got []string
+			for range tt.want {
+				name := ""
+				if u := b.pick(tt.model); u != nil {
+					name = u.name
+				}
+				got = append(got, name)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("picked %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// modelsServer is a runner's API that lists models while up is set and
+// fails otherwise, recording the query of the last listing asked for
+func modelsServer(t *testing.T, up *atomic.Bool, query *atomic.Value, models ...string) *url.URL {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !up.Load() || r.URL.Path != "/v1/models" {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if query != nil {
+			query.Store(r.URL.RawQuery)
+		}
+		list := map[string]any{"object": "list", "data": []map[string]string{}}
+		for _, model := range models {
+			list["data"] = append(list["data"].([]map[string]string), map[string]string{"id": model})
+		}
+		_ = json.NewEncoder(w).Encode(list)
+	}))
+	t.Cleanup(server.Close)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestBalancerCheckHealth(t *testing.T) {
+	var aUp, bUp atomic.Bool
+	aUp.Store(true)
+	a := &upstream{name: "a", url: modelsServer(t, &aUp, nil, "llama3.2:latest")}
+	b := &upstream{name: "b", url: modelsServer(t, &bUp, nil, "gemma3:1b")}
+	var out bytes.Buffer
+	bal := &balancer{out: &out, upstreams: []*upstream{a, b}}
+	ctx := context.Background()
+
+	steps := []struct {
+		name         string
+		aUp, bUp     bool
+		wantHealthy  []bool
+		wantLogged   string
+		wantServedBy string // the runner picked for gemma3:1b
+	}{
+		{name: "b down", aUp: true, bUp: false, wantHealthy: []bool{true, false}, wantLogged: "Runner a is healthy", wantServedBy: "a"},
+		{name: "b comes up", aUp: true, bUp: true, wantHealthy: []bool{true, true}, wantLogged: "Runner b is healthy", wantServedBy: "b"},
+		{name: "a goes down", aUp: false, bUp: true, wantHealthy: []bool{false, true}, wantLogged: "Runner a is unhealthy", wantServedBy: "b"},
+		{name: "a comes back", aUp: true, bUp: true, wantHealthy: []bool{true, true}, wantLogged: "Runner a is healthy", wantServedBy: "b"},
+	}
+	for _, step := range steps {
+		out.Reset()
+		aUp.Store(step.aUp)
+		bUp.Store(step.bUp)
+		bal.checkHealth(ctx)
+
+		for i, u := range bal.upstreams {
+			if got := u.healthy.Load(); got != step.wantHealthy[i] {
+				t.Errorf("%s: runner %s healthy = %t, want %t", step.name, u.name, got, step.wantHealthy[i])
+			}
+		}
+		if !strings.Contains(out.String(), step.wantLogged) {
+			t.Errorf("%s: logged %q, want it to mention %q", step.name, out.String(), step.wantLogged)
+		}
+		if u := bal.pick("gemma3:1b"); u == nil || u.name != step.wantServedBy {
+			t.Errorf("%s: picked %v for gemma3:1b, want %s", step.name, u, step.wantServedBy)
+		}
+	}
+
+	// A runner that stays healthy isn't announced again
+	out.Reset()
+	bal.checkHealth(ctx)
+	if out.Len() > 0 {
+		t.Errorf("logged %q with no change in health", out.String())
+	}
+}
+
+func TestBalancerListingKeepsQuery(t *testing.T) {
+	var up atomic.Bool
+	up.Store(true)
+	var query atomic.Value
+	u := testUpstream("a", true, 0)
+	u.url = modelsServer(t, &up, &query, "llama3.2:latest")
+	bal := &balancer{upstreams: []*upstream{u}}
+
+	w := httptest.NewRecorder()
+	bal.serveListing(w, httptest.NewRequest(http.MethodGet, "/v1/models?limit=10", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", w.Code, w.Body)
+	}
+	if got, _ := query.Load().(string); got != "limit=10" {
+		t.Errorf("runner was asked with query %q, want %q", got, "limit=10")
+	}
+}
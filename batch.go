@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultBatchConcurrency is how many prompts batch sends at once, matching
+// the number of requests Ollama serves in parallel by default
+const DefaultBatchConcurrency = 4
+
+// batchPrompt is a line of batch input. A line may also be a bare JSON
+// string, which is taken as the prompt
+type batchPrompt struct {
+	ID     any    `json:"id,omitempty"`
+	Prompt string `json:"prompt"`
+	System string `json:"system,omitempty"`
+}
+
+// batchResult is a line of batch output
+type batchResult struct {
+	ID               any    `json:"id,omitempty"`
+	Prompt           string `json:"prompt"`
+	Response         string `json:"response"`
+	PromptTokens     int    `json:"prompt_tokens"`
+	CompletionTokens int    `json:"completion_tokens"`
+	LatencyMs        int64  `json:"latency_ms"`
+	Error            string `json:"error,omitempty"`
+}
+
+// readBatchPrompts parses JSONL batch input, skipping blank lines. Every
+// line is checked before anything is generated
+func readBatchPrompts(r io.Reader) ([]batchPrompt, error) {
+	var prompts []batchPrompt
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" {
+			continue
+		}
+
+		var p batchPrompt
+		if strings.HasPrefix(text, `"`) {
+			if err := json.Unmarshal([]byte(text), &p.Prompt); err != nil {
+				return nil, fmt.Errorf("line %d: %w", line, err)
+			}
+		} else if err := json.Unmarshal([]byte(text), &p); err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+		if strings.TrimSpace(p.Prompt) == "" {
+			return nil, fmt.Errorf("line %d: no prompt", line)
+		}
+		prompts = append(prompts, p)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read input: %w", err)
+	}
+	return prompts, nil
+}
+
+// runBatch generates a response to every prompt, concurrency at a time, and
+// writes the results to w as JSONL in input order. A failed prompt is
+// recorded in its result rather than stopping the batch. done is called as
+// each result is written. Cancelling ctx stops the batch, keeping the
+// results already written
+func runBatch(ctx context.Context, base generateRequest, prompts []batchPrompt, concurrency int, w io.Writer, done func(result batchResult)) (failed int, err error) {
+	results := make([]*batchResult, len(prompts))
+	ready := make([]chan struct{}, len(prompts))
+	for i := range ready {
+		ready[i] = make(chan struct{})
+	}
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(concurrency, len(prompts)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results[i] = generateBatchResult(ctx, base, prompts[i])
+				close(ready[i])
+			}
+		}()
+	}
+	go func() {
+		defer close(jobs)
+		for i := range prompts {
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// Results are written in input order as soon as each is available
+	enc := json.NewEncoder(w)
+	enc.SetEscapeHTML(false)
+	for i := range prompts {
+		select {
+		case <-ready[i]:
+		case <-ctx.Done():
+		}
+		if ctx.Err() != nil {
+			// Prompts cut short by the cancellation have no result
+			wg.Wait()
+			return failed, ctx.Err()
+		}
+		if results[i].Error != "" {
+			failed++
+		}
+		if err := enc.Encode(results[i]); err != nil {
+			wg.Wait()
+			return failed, fmt.Errorf("failed to write results: %w", err)
+		}
+		done(*results[i])
+	}
+	wg.Wait()
+	return failed, nil
+}
+
+// generateBatchResult runs a single batch prompt
+func generateBatchResult(ctx context.Context, base generateRequest, p batchPrompt) *batchResult {
+	req := base
+	req.Prompt = p.Prompt
+	if p.System != "" {
+		req.System = p.System
+	}
+
+	result := &batchResult{ID: p.ID, Prompt: p.Prompt}
+	start := time.Now()
+	var buf bytes.Buffer
+	stats, err := activeBackend().Generate(ctx, req, &buf, 0)
+	result.LatencyMs = time.Since(start).Milliseconds()
+	if err != nil {
+		result.Error = err.Error()
+		return result
+	}
+	result.Response = buf.String()
+	result.PromptTokens = stats.PromptEvalCount
+	result.CompletionTokens = stats.EvalCount
+	return result
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/cli/command"
+)
+
+// chatHelp lists the commands the chat understands
+const chatHelp = `Available commands:
+  /system [prompt]  Show or set the system prompt
+  /model [name]     Show the model, or switch to another keeping the conversation
+  /clear            Start a new conversation
+  /save <file>      Save the conversation to a file
+  /load <file>      Continue a conversation saved with /save
+  /bye              Exit
+  /?, /help         Show this help
+
+Start and end a message with """ to write several lines.`
+
+// chatSession is an interactive conversation with a model. Conversations
+// are saved as they go, so they can be resumed later
+type chatSession struct {
+	ID       string        `json:"id,omitempty"`
+	Created  time.Time     `json:"created"`
+	Updated  time.Time     `json:"updated"`
+	Model    string        `json:"model"`
+	System   string        `json:"system,omitempty"`
+	Messages []chatMessage `json:"messages"`
+
+	keepAlive any
+	options   map[string]any
+	render    bool   // render replies as Markdown
+	stats     string // report stats after each reply, as text or json
+}
+
+// runChat holds a conversation on the terminal until /bye, end of input or
+// ctx is cancelled
+func runChat(ctx context.Context, dockerCli command.Cli, s *chatSession) error {
+	in := bufio.NewReader(dockerCli.In())
+	out := dockerCli.Out()
+
+	defer func() {
+		if s.ID != "" {
+			_, _ = fmt.Fprintf(out, "Conversation saved; resume it with `docker model chat --resume %s`\n", s.ID)
+		}
+	}()
+
+	for ctx.Err() == nil {
+		text, err := readChatInput(in, out)
+		if errors.Is(err, io.EOF) {
+			_, _ = fmt.Fprintln(out)
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if text == "" {
+			continue
+		}
+
+		if strings.HasPrefix(text, "/") {
+			done, err := s.command(ctx, out, text)
+			if err != nil {
+				_, _ = fmt.Fprintf(out, "Error: %v\n", err)
+			}
+			if done {
+				return nil
+			}
+			continue
+		}
+
+		if err := s.send(ctx, out, text); err != nil {
+			_, _ = fmt.Fprintf(out, "Error: %v\n", err)
+		}
+	}
+	return ctx.Err()
+}
+
+// readChatInput prompts for and reads a message. A message opened with """
+// continues over several lines until one ending with """
+func readChatInput(in *bufio.Reader, out io.Writer) (string, error) {
+	_, _ = fmt.Fprint(out, ">>> ")
+	line, err := in.ReadString('\n')
+	if err != nil && (line == "" || !errors.Is(err, io.EOF)) {
+		return "", err
+	}
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, `"""`) {
+		return line, nil
+	}
+
+	var lines []string
+	line = strings.TrimPrefix(line, `"""`)
+	for {
+		if strings.HasSuffix(line, `"""`) {
+			lines = append(lines, strings.TrimSuffix(line, `"""`))
+			return strings.TrimSpace(strings.Join(lines, "\n")), nil
+		}
+		lines = append(lines, line)
+
+		_, _ = fmt.Fprint(out, "... ")
+		next, err := in.ReadString('\n')
+		if err != nil && (next == "" || !errors.Is(err, io.EOF)) {
+			return "", err
+		}
+		line = strings.TrimRight(next, "\r\n")
+	}
+}
+
+// send adds a message to the conversation and streams the model's reply.
+// Ctrl+C stops the reply early, keeping what was generated
+func (s *chatSession) send(ctx context.Context, out io.Writer, text string) error {
+	s.Messages = append(s.Messages, chatMessage{Role: "user", Content: text})
+
+	w := out
+	if s.render {
+		md := newMarkdownWriter(out)
+		defer func() { _ = md.Flush() }()
+		w = md
+	}
+
+	replyCtx, stop := signal.NotifyContext(ctx, os.Interrupt)
+	reply, stats, err := activeBackend().Chat(replyCtx, s.request(), w)
+	stop()
+	_, _ = fmt.Fprint(w, "\n\n")
+	if err == nil && s.stats != "" {
+		_ = printStats(out, stats, s.stats)
+		_, _ = fmt.Fprintln(out)
+	}
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		if reply.Content != "" {
+			s.Messages = append(s.Messages, reply)
+		}
+	case err != nil:
+		// Drop the message so the conversation can carry on without it
+		s.Messages = s.Messages[:len(s.Messages)-1]
+		return err
+	default:
+		s.Messages = append(s.Messages, reply)
+	}
+
+	if err := s.persist(); err != nil {
+		return fmt.Errorf("failed to save conversation: %w", err)
+	}
+	return nil
+}
+
+// request builds the chat request for the conversation so far, led by the
+// system prompt
+func (s *chatSession) request() chatRequest {
+	messages := s.Messages
+	if s.System != "" {
+		messages = append([]chatMessage{{Role: "system", Content: s.System}}, messages...)
+	}
+	return chatRequest{Model: s.Model, Messages: messages, KeepAlive: s.keepAlive, Options: s.options}
+}
+
+// command runs a slash command, reporting whether the chat should end
+func (s *chatSession) command(ctx context.Context, out io.Writer, line string) (bool, error) {
+	name, arg, _ := strings.Cut(line, " ")
+	arg = strings.TrimSpace(arg)
+
+	switch name {
+	case "/bye", "/exit":
+		return true, nil
+
+	case "/?", "/help":
+		_, _ = fmt.Fprintln(out, chatHelp)
+
+	case "/clear":
+		// The saved conversation is kept; what follows is a new one
+		s.ID, s.Messages = "", nil
+		_, _ = fmt.Fprintln(out, "Cleared the conversation.")
+
+	case "/system":
+		if arg == "" {
+			if s.System == "" {
+				_, _ = fmt.Fprintln(out, "No system prompt is set; the model's own is used.")
+			} else {
+				_, _ = fmt.Fprintln(out, s.System)
+			}
+			return false, nil
+		}
+		s.System = arg
+		_, _ = fmt.Fprintln(out, "Set the system prompt.")
+
+	case "/model":
+		if arg == "" {
+			_, _ = fmt.Fprintln(out, s.Model)
+			return false, nil
+		}
+		if err := validateModelName(arg); err != nil {
+			return false, err
+		}
+		if err := loadModel(ctx, arg, s.keepAlive); err != nil {
+			return false, err
+		}
+		recordUsage(arg)
+		s.Model = arg
+		_, _ = fmt.Fprintf(out, "Switched to %s.\n", arg)
+
+	case "/save":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /save <file>")
+		}
+		data, err := json.MarshalIndent(s, "", "  ")
+		if err != nil {
+			return false, err
+		}
+		if err := os.WriteFile(arg, data, 0o644); err != nil {
+			return false, fmt.Errorf("failed to save conversation: %w", err)
+		}
+		_, _ = fmt.Fprintf(out, "Saved the conversation to %s.\n", arg)
+
+	case "/load":
+		if arg == "" {
+			return false, fmt.Errorf("usage: /load <file>")
+		}
+		data, err := os.ReadFile(arg)
+		if err != nil {
+			return false, fmt.Errorf("failed to load conversation: %w", err)
+		}
+		var saved chatSession
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return false, fmt.Errorf("%s is not a saved conversation: %w", arg, err)
+		}
+		if saved.Model != "" && saved.Model != s.Model {
+			if err := loadModel(ctx, saved.Model, s.keepAlive); err != nil {
+				return false, err
+			}
+			s.Model = saved.Model
+		}
+		s.System, s.Messages = saved.System, saved.Messages
+		if err := s.persist(); err != nil {
+			return false, fmt.Errorf("failed to save conversation: %w", err)
+		}
+		_, _ = fmt.Fprintf(out, "Loaded %d messages with %s.\n", len(s.Messages), s.Model)
+
+	default:
+		return false, fmt.Errorf("unknown command %s; type /? for help", name)
+	}
+	return false, nil
+}
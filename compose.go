@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/opts"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// ComposeLabel marks the compose services Mocker generated with their
+	// role, so reconciling a file can find them again
+	ComposeLabel = "mocker.compose"
+	// ComposeRunnerService is the compose service the runner runs as, and
+	// the host name other services reach its API at
+	ComposeRunnerService = "model-runner"
+	// ComposePullService pulls the models into the runner once it's healthy
+	ComposePullService = "model-pull"
+	// ComposeWebUIService is the optional chat web UI
+	ComposeWebUIService = "open-webui"
+	// OpenWebUIImage is the image of the chat web UI
+	OpenWebUIImage = "ghcr.io/open-webui/open-webui:main"
+)
+
+// composePullScript is what the pull service runs with the models as its
+// arguments. Compose would substitute $, so it's doubled
+const composePullScript = `for model in "$$@"; do ollama pull "$$model" || exit 1; done`
+
+// composeService is a compose service, with only the fields Mocker sets
+type composeService struct {
+	Image       string                       `yaml:"image"`
+	Entrypoint  []string                     `yaml:"entrypoint,omitempty"`
+	Command     []string                     `yaml:"command,omitempty"`
+	Environment map[string]string            `yaml:"environment,omitempty"`
+	Ports       []string                     `yaml:"ports,omitempty"`
+	Volumes     []string                     `yaml:"volumes,omitempty"`
+	Devices     []string                     `yaml:"devices,omitempty"`
+	Deploy      *composeDeploy               `yaml:"deploy,omitempty"`
+	Healthcheck *composeHealthcheck          `yaml:"healthcheck,omitempty"`
+	DependsOn   map[string]composeDependency `yaml:"depends_on,omitempty"`
+	ShmSize     string                       `yaml:"shm_size,omitempty"`
+	Restart     string                       `yaml:"restart,omitempty"`
+	Labels      map[string]string            `yaml:"labels"`
+}
+
+type composeDeploy struct {
+	Resources composeResources `yaml:"resources"`
+}
+
+type composeResources struct {
+	Limits       map[string]string    `yaml:"limits,omitempty"`
+	Reservations *composeReservations `yaml:"reservations,omitempty"`
+}
+
+type composeReservations struct {
+	Devices []composeDevice `yaml:"devices"`
+}
+
+type composeDevice struct {
+	Driver       string   `yaml:"driver"`
+	Count        any      `yaml:"count,omitempty"` // a number, or "all"
+	DeviceIDs    []string `yaml:"device_ids,omitempty"`
+	Capabilities []string `yaml:"capabilities"`
+}
+
+type composeHealthcheck struct {
+	Test        []string `yaml:"test"`
+	Interval    string   `yaml:"interval"`
+	Timeout     string   `yaml:"timeout"`
+	StartPeriod string   `yaml:"start_period"`
+	Retries     int      `yaml:"retries"`
+}
+
+type composeDependency struct {
+	Condition string `yaml:"condition"`
+}
+
+// composeVolume is a top-level compose volume. Name fixes the volume's name,
+// so it isn't prefixed with the project's
+type composeVolume struct {
+	Name string `yaml:"name,omitempty"`
+}
+
+// composeOptions is what the compose services are generated from
+type composeOptions struct {
+	runnerConfig
+	serve bool // also publish the API at serve's address
+	webUI bool
+}
+
+// composeProject returns the services and volumes that run the runner as
+// configured in o
+func composeProject(o composeOptions) (map[string]composeService, map[string]composeVolume, error) {
+	runner := composeService{
+		Image:   o.image,
+		Ports:   []string{fmt.Sprintf("%d:%d", ollamaPort(), DefaultOllamaPort)},
+		Volumes: []string{"models:/root/.ollama"},
+		Healthcheck: &composeHealthcheck{
+			Test:        runnerHealthcheck.Test,
+			Interval:    runnerHealthcheck.Interval.String(),
+			Timeout:     runnerHealthcheck.Timeout.String(),
+			StartPeriod: runnerHealthcheck.StartPeriod.String(),
+			Retries:     runnerHealthcheck.Retries,
+		},
+		ShmSize: o.shmSize,
+		Restart: configOr(o.restart, DefaultRestartPolicy),
+		Labels:  map[string]string{ComposeLabel: "runner"},
+	}
+	if o.serve {
+		_, port, _ := strings.Cut(DefaultListenAddress, ":")
+		runner.Ports = append(runner.Ports, fmt.Sprintf("127.0.0.1:%s:%d", port, DefaultOllamaPort))
+	}
+	var env []string
+	if o.cpuOnly {
+		env = cpuOnlyEnv
+	}
+	for _, variable := range slices.Concat(env, o.env) {
+		if runner.Environment == nil {
+			runner.Environment = map[string]string{}
+		}
+		name, value, _ := strings.Cut(variable, "=")
+		runner.Environment[name] = value
+	}
+	resources, err := composeRunnerResources(o.runnerConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+	if resources != nil {
+		runner.Deploy = &composeDeploy{Resources: *resources}
+	}
+	if o.rocm && !o.cpuOnly {
+		runner.Devices = rocmDevices
+	}
+
+	services := map[string]composeService{ComposeRunnerService: runner}
+	volumes := map[string]composeVolume{"models": {Name: volumeName()}}
+	healthy := map[string]composeDependency{ComposeRunnerService: {Condition: "service_healthy"}}
+	if len(o.models) > 0 {
+		services[ComposePullService] = composeService{
+			Image:       o.image,
+			Entrypoint:  []string{"sh", "-c", composePullScript, ComposePullService},
+			Command:     o.models,
+			Environment: map[string]string{"OLLAMA_HOST": ComposeRunnerService + ":" + strconv.Itoa(DefaultOllamaPort)},
+			DependsOn:   healthy,
+			Restart:     "no",
+			Labels:      map[string]string{ComposeLabel: "pull"},
+		}
+	}
+	if o.webUI {
+		services[ComposeWebUIService] = composeService{
+			Image:       OpenWebUIImage,
+			Environment: map[string]string{"OLLAMA_BASE_URL": "http://" + ComposeRunnerService + ":" + strconv.Itoa(DefaultOllamaPort)},
+			Ports:       []string{"3000:8080"},
+			Volumes:     []string{"open-webui:/app/backend/data"},
+			DependsOn:   healthy,
+			Restart:     DefaultRestartPolicy,
+			Labels:      map[string]string{ComposeLabel: "webui"},
+		}
+		volumes["open-webui"] = composeVolume{}
+	}
+	return services, volumes, nil
+}
+
+// composeRunnerResources turns the runner's GPU, memory and CPU settings
+// into compose's deploy resources, or nil if there are none
+func composeRunnerResources(o runnerConfig) (*composeResources, error) {
+	var resources composeResources
+	limits := map[string]string{}
+	if o.memory != "" {
+		var memory opts.MemBytes
+		if err := memory.Set(o.memory); err != nil {
+			return nil, fmt.Errorf("invalid memory limit %q: %w", o.memory, err)
+		}
+		limits["memory"] = strconv.FormatInt(memory.Value(), 10)
+	}
+	if o.cpus != "" {
+		var cpus opts.NanoCPUs
+		if err := cpus.Set(o.cpus); err != nil {
+			return nil, fmt.Errorf("invalid CPU limit %q: %w", o.cpus, err)
+		}
+		limits["cpus"] = o.cpus
+	}
+	if len(limits) > 0 {
+		resources.Limits = limits
+	}
+
+	if o.gpus != "" && !o.cpuOnly && !o.rocm {
+		var gpus opts.GpuOpts
+		if err := gpus.Set(o.gpus); err != nil {
+			return nil, fmt.Errorf("invalid GPU setting %q: %w", o.gpus, err)
+		}
+		resources.Reservations = &composeReservations{}
+		for _, request := range gpus.Value() {
+			device := composeDevice{Driver: configOr(request.Driver, "nvidia"), DeviceIDs: request.DeviceIDs, Capabilities: []string{"gpu"}}
+			switch {
+			case len(request.DeviceIDs) > 0:
+			case request.Count == -1:
+				device.Count = "all"
+			default:
+				device.Count = request.Count
+			}
+			for _, caps := range request.Capabilities {
+				for _, c := range caps {
+					if !slices.Contains(device.Capabilities, c) {
+						device.Capabilities = append(device.Capabilities, c)
+					}
+				}
+			}
+			resources.Reservations.Devices = append(resources.Reservations.Devices, device)
+		}
+	}
+
+	if resources.Limits == nil && resources.Reservations == nil {
+		return nil, nil
+	}
+	return &resources, nil
+}
+
+// reconcileCompose merges services and volumes into a compose file,
+// replacing those Mocker generated before and removing any it no longer
+// generates. Everything else in the file, comments included, is kept
+func reconcileCompose(data []byte, services map[string]composeService, volumes map[string]composeVolume) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, errors.New("the file isn't a compose file: its top level isn't a mapping")
+	}
+
+	serviceNodes := yamlMapping(root, "services")
+	for i := 0; i < len(serviceNodes.Content); i += 2 {
+		name, service := serviceNodes.Content[i].Value, serviceNodes.Content[i+1]
+		if _, ok := services[name]; !ok && composeRole(service) != "" {
+			serviceNodes.Content = slices.Delete(serviceNodes.Content, i, i+2)
+			i -= 2
+		}
+	}
+	for _, name := range sortedKeys(services) {
+		var node yaml.Node
+		if err := node.Encode(services[name]); err != nil {
+			return nil, err
+		}
+		yamlSet(serviceNodes, name, &node)
+	}
+
+	volumeNodes := yamlMapping(root, "volumes")
+	for _, name := range sortedKeys(volumes) {
+		if yamlGet(volumeNodes, name) != nil {
+			continue
+		}
+		var node yaml.Node
+		if err := node.Encode(volumes[name]); err != nil {
+			return nil, err
+		}
+		yamlSet(volumeNodes, name, &node)
+	}
+
+	var out bytes.Buffer
+	enc := yaml.NewEncoder(&out)
+	enc.SetIndent(2)
+	if err := enc.Encode(&doc); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// composeRole returns the role a service was generated for, from its
+// labels, or "" if Mocker didn't generate it
+func composeRole(service *yaml.Node) string {
+	labels := yamlGet(service, "labels")
+	switch {
+	case labels == nil:
+		return ""
+	case labels.Kind == yaml.MappingNode:
+		if role := yamlGet(labels, ComposeLabel); role != nil {
+			return role.Value
+		}
+	case labels.Kind == yaml.SequenceNode:
+		for _, label := range labels.Content {
+			if role, ok := strings.CutPrefix(label.Value, ComposeLabel+"="); ok {
+				return role
+			}
+		}
+	}
+	return ""
+}
+
+// yamlGet returns the value of key in a mapping node, or nil
+func yamlGet(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return mapping.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// yamlSet sets key in a mapping node, keeping its place if it's already there
+func yamlSet(mapping *yaml.Node, key string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			mapping.Content[i+1] = value
+			return
+		}
+	}
+	mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Value: key}, value)
+}
+
+// yamlMapping returns the mapping under key, adding an empty one if there's
+// none. An empty value, as in "volumes:" alone, becomes a mapping too
+func yamlMapping(mapping *yaml.Node, key string) *yaml.Node {
+	node := yamlGet(mapping, key)
+	if node == nil || node.Kind != yaml.MappingNode {
+		node = &yaml.Node{Kind: yaml.MappingNode}
+		yamlSet(mapping, key, node)
+	}
+	return node
+}
+
+// sortedKeys returns a map's keys in order, so output is stable
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	return keys
+}
+
+// Compose command
+func newComposeCommand(dockerCli command.Cli) *cobra.Command {
+	var file string
+	var models []string
+	var serve, webUI bool
+
+	cmd := &cobra.Command{
+		Use:   "compose",
+		Short: "Generate a Docker Compose service for the model runner, or add it to a compose file",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, model := range models {
+				if err := validateModelName(model); err != nil {
+					return err
+				}
+			}
+
+			runner, err := localRunnerConfig(ctx, cmd, "Docker Compose")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("models") {
+				runner.models = models
+			}
+			services, volumes, err := composeProject(composeOptions{runner, serve, webUI})
+			if err != nil {
+				return err
+			}
+
+			var existing []byte
+			if file != "" {
+				existing, err = os.ReadFile(file)
+				if err != nil && !errors.Is(err, os.ErrNotExist) {
+					return fmt.Errorf("failed to read %s: %w", file, err)
+				}
+			}
+			data, err := reconcileCompose(existing, services, volumes)
+			if err != nil {
+				return fmt.Errorf("failed to update %s: %w", file, err)
+			}
+			if file == "" {
+				_, err = dockerCli.Out().Write(data)
+				return err
+			}
+
+			if err := os.WriteFile(file, data, 0o644); err != nil {
+				return fmt.Errorf("failed to write %s: %w", file, err)
+			}
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Updated %s with %s; start them with `docker compose -f %s up -d`\n", file, strings.Join(sortedKeys(services), ", "), file)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Compose file to add the services to, replacing those added before (default print them)")
+	cmd.Flags().StringSliceVar(&models, "models", nil, "Models to pull once the runner is up (default the models installed in the local runner)")
+	cmd.Flags().BoolVar(&serve, "serve", false, "Also publish the API at serve's address, "+DefaultListenAddress)
+	cmd.Flags().BoolVar(&webUI, "webui", false, "Add Open WebUI, a chat web UI for the runner, on port 3000")
+
+	return cmd
+}
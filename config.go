@@ -0,0 +1,309 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+	"github.com/docker/cli/opts"
+	"gopkg.in/yaml.v3"
+)
+
+// mockerConfig holds persistent settings from the config file. Command-line
+// flags and MOCKER_* environment variables take precedence over it
+type mockerConfig struct {
+	ContainerName string   `yaml:"container_name,omitempty"`
+	Image         string   `yaml:"image,omitempty"`
+	Tag           string   `yaml:"tag,omitempty"`
+	Port          int      `yaml:"port,omitempty"`
+	Volume        string   `yaml:"volume,omitempty"`
+	GPU           string   `yaml:"gpu,omitempty"`
+	DefaultModel  string   `yaml:"default_model,omitempty"`
+	KeepAlive     string   `yaml:"keep_alive_default,omitempty"`
+	Render        bool     `yaml:"render,omitempty"`
+	Retries       *int     `yaml:"retries,omitempty"`
+	ReadyTimeout  string   `yaml:"ready_timeout,omitempty"`
+	Restart       string   `yaml:"restart,omitempty"`
+	OllamaHost    string   `yaml:"ollama_host,omitempty"`
+	Runtime       string   `yaml:"runtime,omitempty"`
+	Memory        string   `yaml:"memory,omitempty"`
+	CPUs          string   `yaml:"cpus,omitempty"`
+	ShmSize       string   `yaml:"shm_size,omitempty"`
+	Env           []string `yaml:"env,omitempty"`
+}
+
+// gpuModes are the accepted values of the gpu setting
+var gpuModes = []string{"auto", "cpu", "nvidia", "rocm"}
+
+// configPath returns the location of the config file, inside the docker
+// config directory so it follows DOCKER_CONFIG
+func configPath() string {
+	return filepath.Join(config.Dir(), "mocker", "config.yaml")
+}
+
+// loadConfig reads the config file. A missing file is an empty config
+func loadConfig() (mockerConfig, error) {
+	var cfg mockerConfig
+
+	data, err := os.ReadFile(configPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s: %w", configPath(), err)
+	}
+	return cfg, nil
+}
+
+// saveConfig writes the config file, creating its directory if needed
+func saveConfig(cfg mockerConfig) error {
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(configPath()), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+	if err := os.WriteFile(configPath(), data, 0o644); err != nil {
+		return fmt.Errorf("failed to write config: %w", err)
+	}
+	return nil
+}
+
+// settings is the config used by every command. It's loaded once; a broken
+// file is reported and otherwise ignored, so it can't lock you out of
+// `config set` to fix it
+var settings = sync.OnceValue(func() mockerConfig {
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: ignoring config file: %v\n", err)
+	}
+	return cfg
+})
+
+// configKey is a setting that can be read and written with `config get/set`.
+// A list setting takes several values, passed to set one per line
+type configKey struct {
+	name  string
+	usage string
+	list  bool
+	get   func(cfg *mockerConfig) string
+	set   func(cfg *mockerConfig, value string) error
+}
+
+// stringKey is a configKey for a plain string setting
+func stringKey(name, usage string, field func(cfg *mockerConfig) *string, validate func(string) error) configKey {
+	return configKey{
+		name:  name,
+		usage: usage,
+		get:   func(cfg *mockerConfig) string { return *field(cfg) },
+		set: func(cfg *mockerConfig, value string) error {
+			if value != "" && validate != nil {
+				if err := validate(value); err != nil {
+					return err
+				}
+			}
+			*field(cfg) = value
+			return nil
+		},
+	}
+}
+
+// noSpaces rejects values that can't be an image, tag or name
+func noSpaces(value string) error {
+	if strings.ContainsAny(value, " \t\n") {
+		return fmt.Errorf("%q must not contain whitespace", value)
+	}
+	return nil
+}
+
+// configKeys are the settings the config file supports
+var configKeys = []configKey{
+	stringKey("container_name", "Name of the runner container", func(cfg *mockerConfig) *string { return &cfg.ContainerName }, noSpaces),
+	stringKey("image", "Ollama image for the runner, overriding tag", func(cfg *mockerConfig) *string { return &cfg.Image }, noSpaces),
+	stringKey("tag", "Ollama release tag for the default image (e.g. 0.6.5)", func(cfg *mockerConfig) *string { return &cfg.Tag }, noSpaces),
+	{
+		name:  "port",
+		usage: "Host port the runner's API is published on",
+		get: func(cfg *mockerConfig) string {
+			if cfg.Port == 0 {
+				return ""
+			}
+			return strconv.Itoa(cfg.Port)
+		},
+		set: func(cfg *mockerConfig, value string) error {
+			if value == "" {
+				cfg.Port = 0
+				return nil
+			}
+			port, err := strconv.Atoi(value)
+			if err != nil || port < 1 || port > 65535 {
+				return fmt.Errorf("invalid port %q: must be a number from 1 to 65535", value)
+			}
+			cfg.Port = port
+			return nil
+		},
+	},
+	stringKey("volume", "Volume models are stored in", func(cfg *mockerConfig) *string { return &cfg.Volume }, noSpaces),
+	stringKey("gpu", "GPU mode: "+strings.Join(gpuModes, ", "), func(cfg *mockerConfig) *string { return &cfg.GPU }, func(value string) error {
+		for _, mode := range gpuModes {
+			if value == mode {
+				return nil
+			}
+		}
+		return fmt.Errorf("invalid gpu mode %q: must be one of %s", value, strings.Join(gpuModes, ", "))
+	}),
+	stringKey("default_model", "Model `run` uses when none is given", func(cfg *mockerConfig) *string { return &cfg.DefaultModel }, validateModelName),
+	stringKey("keep_alive_default", "How long models stay loaded after use (e.g. 30m, -1 or 0)", func(cfg *mockerConfig) *string { return &cfg.KeepAlive }, func(value string) error {
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			return nil
+		}
+		if _, err := time.ParseDuration(value); err != nil {
+			return fmt.Errorf("invalid keep_alive_default %q: must be a duration such as 30m, or a number of seconds", value)
+		}
+		return nil
+	}),
+	{
+		name:  "render",
+		usage: "Render responses as Markdown on a terminal by default (true or false)",
+		get: func(cfg *mockerConfig) string {
+			if !cfg.Render {
+				return ""
+			}
+			return "true"
+		},
+		set: func(cfg *mockerConfig, value string) error {
+			if value == "" {
+				cfg.Render = false
+				return nil
+			}
+			render, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid render setting %q: must be true or false", value)
+			}
+			cfg.Render = render
+			return nil
+		},
+	},
+	stringKey("ready_timeout", "How long to wait for a freshly started runner to become ready (e.g. 2m)", func(cfg *mockerConfig) *string { return &cfg.ReadyTimeout }, func(value string) error {
+		if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+			return fmt.Errorf("invalid ready_timeout %q: must be a positive duration such as 90s or 2m", value)
+		}
+		return nil
+	}),
+	stringKey("restart", "Restart policy for the runner (no, always, unless-stopped or on-failure)", func(cfg *mockerConfig) *string { return &cfg.Restart }, func(value string) error {
+		_, err := opts.ParseRestartPolicy(value)
+		return err
+	}),
+	stringKey("memory", "Memory limit for the runner container (e.g. 8g)", func(cfg *mockerConfig) *string { return &cfg.Memory }, func(value string) error {
+		var memory opts.MemBytes
+		return memory.Set(value)
+	}),
+	stringKey("cpus", "Number of CPUs the runner container may use (e.g. 4)", func(cfg *mockerConfig) *string { return &cfg.CPUs }, func(value string) error {
+		var cpus opts.NanoCPUs
+		return cpus.Set(value)
+	}),
+	stringKey("shm_size", "Size of /dev/shm in the runner container (e.g. 2g)", func(cfg *mockerConfig) *string { return &cfg.ShmSize }, func(value string) error {
+		var size opts.MemBytes
+		return size.Set(value)
+	}),
+	stringKey("ollama_host", "Use the Ollama server at this address instead of the runner container", func(cfg *mockerConfig) *string { return &cfg.OllamaHost }, noSpaces),
+	stringKey("runtime", "Where Ollama runs: container, or host to run it natively (for Metal on Apple Silicon)", func(cfg *mockerConfig) *string { return &cfg.Runtime }, func(value string) error {
+		if value != "container" && value != "host" {
+			return fmt.Errorf("runtime must be container or host, not %q", value)
+		}
+		return nil
+	}),
+	{
+		name:  "env",
+		usage: "Environment variables for the runner, as KEY=VALUE values",
+		list:  true,
+		get: func(cfg *mockerConfig) string {
+			env := make([]string, len(cfg.Env))
+			for i, variable := range cfg.Env {
+				if strings.ContainsAny(variable, " \t\n\"") {
+					variable = strconv.Quote(variable)
+				}
+				env[i] = variable
+			}
+			return strings.Join(env, " ")
+		},
+		set: func(cfg *mockerConfig, value string) error {
+			var env []string
+			for _, variable := range strings.Split(value, "\n") {
+				if variable == "" {
+					continue
+				}
+				if name, _, _ := strings.Cut(variable, "="); name == "" {
+					return fmt.Errorf("invalid env entry %q: must be KEY=VALUE", variable)
+				}
+				env = append(env, variable)
+			}
+			cfg.Env = env
+			return nil
+		},
+	},
+	{
+		name:  "retries",
+		usage: "How many times to retry a transient Docker or Ollama failure",
+		get: func(cfg *mockerConfig) string {
+			if cfg.Retries == nil {
+				return ""
+			}
+			return strconv.Itoa(*cfg.Retries)
+		},
+		set: func(cfg *mockerConfig, value string) error {
+			if value == "" {
+				cfg.Retries = nil
+				return nil
+			}
+			n, err := strconv.Atoi(value)
+			if err != nil || n < 0 {
+				return fmt.Errorf("invalid retries %q: must be a number of 0 or more", value)
+			}
+			cfg.Retries = &n
+			return nil
+		},
+	},
+}
+
+// findConfigKey looks up a setting by name
+func findConfigKey(name string) (configKey, error) {
+	for _, key := range configKeys {
+		if key.name == name {
+			return key, nil
+		}
+	}
+
+	names := make([]string, len(configKeys))
+	for i, key := range configKeys {
+		names[i] = key.name
+	}
+	return configKey{}, fmt.Errorf("unknown setting %q: must be one of %s", name, strings.Join(names, ", "))
+}
+
+// applyGPUMode turns the configured GPU mode into the equivalent flag, unless
+// a GPU-related flag was given
+func applyGPUMode() {
+	if cpuOnly.set || gpusFlag != "" || runtimeFlag != "" {
+		return
+	}
+	switch settings().GPU {
+	case "cpu":
+		cpuOnly = optionalBool{value: true, set: true}
+	case "nvidia":
+		gpusFlag = "all"
+	case "rocm":
+		runtimeFlag = "rocm"
+	}
+}
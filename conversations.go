@@ -0,0 +1,135 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+)
+
+// conversationsDir returns where chat transcripts are kept, one JSON file
+// per conversation, next to the other ledgers
+func conversationsDir() string {
+	return filepath.Join(config.Dir(), "mocker", "conversations")
+}
+
+// newConversationID returns a random ID for a conversation
+func newConversationID() string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// persist saves the conversation, giving it an ID the first time. Empty
+// conversations aren't worth keeping, so nothing is written until the
+// first exchange
+func (s *chatSession) persist() error {
+	if len(s.Messages) == 0 {
+		return nil
+	}
+	now := time.Now().UTC()
+	if s.ID == "" {
+		s.ID = newConversationID()
+		s.Created = now
+	}
+	s.Updated = now
+	return writeLedger(filepath.Join(conversationsDir(), s.ID+".json"), s)
+}
+
+// listConversations returns every saved conversation, most recent first
+func listConversations() ([]*chatSession, error) {
+	entries, err := os.ReadDir(conversationsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list conversations: %w", err)
+	}
+
+	var sessions []*chatSession
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		s := &chatSession{}
+		if err := readLedger(filepath.Join(conversationsDir(), entry.Name()), s); err != nil {
+			return nil, err
+		}
+		s.ID = strings.TrimSuffix(entry.Name(), ".json")
+		sessions = append(sessions, s)
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Updated.After(sessions[j].Updated) })
+	return sessions, nil
+}
+
+// findConversation returns the saved conversation with the given ID, which,
+// as with container IDs, may be shortened to any unique prefix
+func findConversation(id string) (*chatSession, error) {
+	if id == "" {
+		return nil, fmt.Errorf("no conversation ID given")
+	}
+	sessions, err := listConversations()
+	if err != nil {
+		return nil, err
+	}
+
+	var found *chatSession
+	for _, s := range sessions {
+		if !strings.HasPrefix(s.ID, id) {
+			continue
+		}
+		if found != nil {
+			return nil, fmt.Errorf("conversation ID %s is ambiguous", id)
+		}
+		found = s
+	}
+	if found == nil {
+		return nil, fmt.Errorf("no conversation with ID %s", id)
+	}
+	return found, nil
+}
+
+// removeConversation deletes a saved conversation
+func removeConversation(id string) error {
+	return os.Remove(filepath.Join(conversationsDir(), id+".json"))
+}
+
+// title summarizes a conversation by its first message
+func (s *chatSession) title() string {
+	for _, m := range s.Messages {
+		if m.Role == "user" {
+			title := strings.Join(strings.Fields(m.Content), " ")
+			if len(title) > 40 {
+				title = title[:39] + "…"
+			}
+			return title
+		}
+	}
+	return ""
+}
+
+// writeMarkdown writes a conversation as a Markdown transcript
+func (s *chatSession) writeMarkdown(w io.Writer) error {
+	_, _ = fmt.Fprintf(w, "# Conversation %s\n\nModel: %s  \nStarted: %s\n", s.ID, s.Model, s.Created.Local().Format(time.RFC1123))
+	if s.System != "" {
+		_, _ = fmt.Fprintf(w, "\n## System\n\n%s\n", s.System)
+	}
+	for _, m := range s.Messages {
+		role := "User"
+		if m.Role == "assistant" {
+			role = "Assistant"
+		}
+		if _, err := fmt.Fprintf(w, "\n## %s\n\n%s\n", role, strings.TrimSpace(m.Content)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
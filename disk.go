@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ModelsDir is where Ollama stores models inside the runner, in the volume
+const ModelsDir = "/root/.ollama/models"
+
+// manifest is an Ollama model manifest, which lists its blobs. The same
+// shape serves as an OCI image manifest when carried as an artifact
+type manifest struct {
+	SchemaVersion int             `json:"schemaVersion"`
+	MediaType     string          `json:"mediaType"`
+	ArtifactType  string          `json:"artifactType,omitempty"`
+	Config        manifestLayer   `json:"config"`
+	Layers        []manifestLayer `json:"layers"`
+}
+
+// manifestLayer is a blob referenced by a manifest
+type manifestLayer struct {
+	MediaType string `json:"mediaType"`
+	Digest    string `json:"digest"`
+	Size      int64  `json:"size"`
+}
+
+// readManifests returns the blobs of every installed model, keyed by
+// fully-qualified model reference. Manifests are stored as
+// manifests/<registry>/<namespace>/<model>/<tag>
+func readManifests() (map[modelReference][]manifestLayer, error) {
+	files, err := docker.ReadDir(containerName(), ModelsDir+"/manifests")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model manifests: %w", err)
+	}
+
+	models := map[modelReference][]manifestLayer{}
+	for rel, content := range files {
+		parts := strings.Split(rel, "/")
+		if len(parts) != 4 {
+			continue
+		}
+		var m manifest
+		if json.Unmarshal(content, &m) != nil {
+			continue
+		}
+		ref := modelReference{Registry: parts[0], Repository: parts[1] + "/" + parts[2], Tag: parts[3]}
+		models[ref] = append(m.Layers, m.Config)
+	}
+	return models, nil
+}
+
+// blobSizes returns the size on disk of every file in the blob store, keyed
+// by digest. Interrupted downloads are included under their file name
+func blobSizes() (map[string]int64, error) {
+	out, err := runInOllama("find", ModelsDir+"/blobs", "-type", "f", "-printf", "%s %f\n")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list model blobs: %w", err)
+	}
+
+	blobs := map[string]int64{}
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		size, file, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			continue
+		}
+		// Blob files are named after their digest, with a dash for the colon
+		blobs[strings.Replace(file, "-", ":", 1)] = n
+	}
+	return blobs, nil
+}
+
+// dfRow is a model's disk usage as reported by df
+type dfRow struct {
+	Name     string `json:"name"`
+	Size     string `json:"size"`
+	Shared   string `json:"shared"`
+	Unique   string `json:"unique"`
+	LastUsed string `json:"last_used"`
+}
+
+// diskUsage is the volume's disk usage as reported by df
+type diskUsage struct {
+	Models      []dfRow `json:"models"`
+	Total       string  `json:"total"`
+	Shared      string  `json:"shared"`
+	Reclaimable string  `json:"reclaimable"`
+}
+
+// measureDiskUsage works out how much space each model takes, how much of
+// it is shared with other models, and how much prune would free: every
+// blob not used by a model run or pulled within DefaultPruneAge
+func measureDiskUsage(ctx context.Context) (diskUsage, error) {
+	manifests, err := readManifests()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	blobs, err := blobSizes()
+	if err != nil {
+		return diskUsage{}, err
+	}
+	installed, err := listLocalModels(ctx)
+	if err != nil {
+		return diskUsage{}, err
+	}
+	usage, err := loadUsage()
+	if err != nil {
+		return diskUsage{}, err
+	}
+
+	refCount := map[string]int{}
+	for _, layers := range manifests {
+		for _, l := range layers {
+			refCount[l.Digest]++
+		}
+	}
+
+	du := diskUsage{Models: []dfRow{}}
+	kept := map[string]bool{}
+	for ref, layers := range manifests {
+		name := ref.Short()
+		used := time.Time{}
+		for _, m := range installed {
+			if parseModelReference(m.Name) == ref {
+				name, used = m.Name, lastUsed(usage, m)
+				break
+			}
+		}
+
+		var size, shared int64
+		for _, l := range layers {
+			size += l.Size
+			if refCount[l.Digest] > 1 {
+				shared += l.Size
+			}
+			if used.IsZero() || time.Since(used) <= DefaultPruneAge {
+				kept[l.Digest] = true
+			}
+		}
+
+		lastUsedText := "unknown"
+		if !used.IsZero() {
+			lastUsedText = humanizeSince(used)
+		}
+		du.Models = append(du.Models, dfRow{name, formatBytes(size), formatBytes(shared), formatBytes(size - shared), lastUsedText})
+	}
+	sort.Slice(du.Models, func(i, j int) bool { return du.Models[i].Name < du.Models[j].Name })
+
+	var total, shared, reclaimable int64
+	for digest, size := range blobs {
+		total += size
+		if refCount[digest] > 1 {
+			shared += size
+		}
+		if !kept[digest] {
+			reclaimable += size
+		}
+	}
+	du.Total = formatBytes(total)
+	du.Shared = formatBytes(shared)
+	du.Reclaimable = formatBytes(reclaimable)
+	return du, nil
+}
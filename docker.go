@@ -0,0 +1,835 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/image"
+	"github.com/docker/docker/api/types/volume"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/jsonmessage"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/docker/go-connections/nat"
+)
+
+// dockerRunner manages the runner container. Everything that talks to the
+// container goes through it, so tests can swap in a fake that records
+// invocations and returns canned results
+type dockerRunner interface {
+	// Ping checks that the daemon can be reached
+	Ping() error
+	// Inspect returns the state of a container, or ok false if it doesn't exist
+	Inspect(name string) (state containerState, ok bool, err error)
+	// Create pulls the spec's image, then creates and starts a container from it
+	Create(ctx context.Context, spec containerSpec) error
+	// Start starts an existing, stopped container
+	Start(name string) error
+	// Stop stops a container without removing it
+	Stop(name string) error
+	// Remove force-removes a container, succeeding if it doesn't exist
+	Remove(name string) error
+	// CreateVolume creates a volume, succeeding if it already exists
+	CreateVolume(name string) error
+	// Exec runs a command in a container, copying its output to the writers
+	// as it is produced. Cancelling ctx kills the command
+	Exec(ctx context.Context, name string, cmd []string, stdout, stderr io.Writer) error
+	// ExecInteractive runs a command in a container attached to the
+	// current terminal
+	ExecInteractive(ctx context.Context, name string, cmd []string) error
+	// Logs copies a container's logs to the writers
+	Logs(ctx context.Context, name string, opts logsOptions, stdout, stderr io.Writer) error
+	// Stats calls fn with a container's resource usage, once or, if stream
+	// is set, every time the daemon reports it until ctx is done
+	Stats(ctx context.Context, name string, stream bool, fn func(containerStats) error) error
+	// CopyFile streams size bytes of content into a file in a container
+	CopyFile(ctx context.Context, name, dst string, content io.Reader, size int64) error
+	// CopyArchive extracts a tar archive into a directory in a container
+	CopyArchive(ctx context.Context, name, dst string, archive io.Reader) error
+	// OpenFile streams a single file out of a container, returning its size
+	OpenFile(ctx context.Context, name, file string) (io.ReadCloser, int64, error)
+	// ReadDir returns the contents of every file under a directory in a
+	// container, keyed by path relative to it
+	ReadDir(name, dir string) (map[string][]byte, error)
+	// Runtimes returns the names of the container runtimes the daemon offers
+	Runtimes() ([]string, error)
+	// Rootless reports whether the daemon runs without root privileges
+	Rootless() (bool, error)
+	// ListLabeled returns the state of every container, running or not,
+	// that has label, keyed by container name
+	ListLabeled(label string) (map[string]containerState, error)
+}
+
+// containerState is the part of a container's configuration mocker inspects
+type containerState struct {
+	Running bool
+	Status  string
+	Health  string // starting, healthy or unhealthy; empty without a healthcheck
+	Restart string // restart policy
+	Image   string
+	Labels  map[string]string
+	// HostPort is the first host port a container port is published on, or
+	// 0 if none is
+	HostPort int
+}
+
+// containerSpec describes the runner container to create
+type containerSpec struct {
+	Name        string
+	Image       string
+	Cmd         []string // arguments to the image's entrypoint, if not its default
+	Env         []string
+	Labels      map[string]string
+	Mounts      []string
+	Ports       map[int]int // host port to container port; host port 0 lets the daemon pick
+	Healthcheck *container.HealthConfig
+	HostConfig  container.HostConfig
+}
+
+// containerStats is a container's resource usage
+type containerStats struct {
+	CPUPercent float64
+	MemUsage   uint64
+	MemLimit   uint64
+}
+
+// logsOptions selects which of a container's logs to show
+type logsOptions struct {
+	Follow bool
+	Tail   string // number of lines, or "all"
+	Since  string // timestamp or relative duration, like docker logs --since
+}
+
+// docker is the dockerRunner used by all commands
+var docker dockerRunner = apiDockerRunner{}
+
+// dockerCLI is the docker CLI the plugin was invoked from. It's set in main,
+// and its API client targets the same daemon, whether that's picked by a
+// context, --host or DOCKER_HOST
+var dockerCLI command.Cli
+
+// containerRuntime returns the container engine to drive: MOCKER_RUNTIME if
+// set, otherwise docker, falling back to podman when docker isn't installed
+// or is podman-docker's stand-in for it. It's resolved once, since every
+// runner operation asks
+var containerRuntime = sync.OnceValue(func() string {
+	if runtime := os.Getenv("MOCKER_RUNTIME"); runtime != "" {
+		return runtime
+	}
+	docker, err := exec.LookPath("docker")
+	if err != nil || isPodmanShim(docker) {
+		if _, err := exec.LookPath("podman"); err == nil {
+			return "podman"
+		}
+	}
+	return "docker"
+})
+
+// isPodmanShim reports whether a docker executable is really podman: a
+// link to it, or the wrapper script podman-docker installs
+func isPodmanShim(path string) bool {
+	if target, err := filepath.EvalSymlinks(path); err == nil && filepath.Base(target) == "podman" {
+		return true
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 1024)
+	n, _ := io.ReadFull(f, head)
+	return bytes.HasPrefix(head[:n], []byte("#!")) && bytes.Contains(head[:n], []byte("podman"))
+}
+
+// podmanSocket reports whether the runner is driven through podman's own API
+// socket rather than the engine the docker CLI points at
+func podmanSocket() bool {
+	return filepath.Base(containerRuntime()) == "podman" && os.Getenv("DOCKER_HOST") == ""
+}
+
+// engineName names the container engine for messages
+func engineName() string {
+	if isPodman() {
+		return "Podman"
+	}
+	return "Docker"
+}
+
+// isPodman reports whether the runner is managed through podman, either
+// directly or by a docker CLI whose engine turns out to be podman
+func isPodman() bool {
+	return filepath.Base(containerRuntime()) == "podman" || podmanEngine()
+}
+
+// podmanEngine reports whether the engine the docker CLI talks to is podman's
+// Docker-compatible API, as when DOCKER_HOST points at podman's socket or
+// podman-docker has put it at docker's
+var podmanEngine = sync.OnceValue(func() bool {
+	if dockerCLI == nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	version, err := dockerCLI.Client().ServerVersion(ctx)
+	if err != nil {
+		return false
+	}
+	for _, component := range version.Components {
+		if strings.Contains(component.Name, "Podman") {
+			return true
+		}
+	}
+	return false
+})
+
+// dockerEndpoint returns the name of the docker context in use and the
+// address of the engine it points at
+func dockerEndpoint() (string, string) {
+	if dockerCLI == nil {
+		return "", ""
+	}
+	return dockerCLI.CurrentContext(), dockerCLI.DockerEndpoint().Host
+}
+
+// runnerHostname returns the host the runner's published port is reachable
+// on: the daemon's host for remote TCP and SSH endpoints, localhost otherwise
+func runnerHostname() string {
+	if dockerCLI == nil || podmanSocket() {
+		return "localhost"
+	}
+	u, err := url.Parse(dockerCLI.DockerEndpoint().Host)
+	if err != nil {
+		return "localhost"
+	}
+	switch u.Scheme {
+	case "tcp", "ssh", "http", "https":
+		if h := u.Hostname(); h != "" {
+			return h
+		}
+	}
+	return "localhost"
+}
+
+var (
+	errDockerNotInstalled = errors.New("docker is not installed: neither docker nor podman was found")
+	errDockerNotRunning   = errors.New("docker daemon is not running or not reachable")
+)
+
+// checkDockerAvailable distinguishes a missing container engine from a
+// daemon that can't be reached, so callers can give actionable errors
+func checkDockerAvailable() error {
+	// Docker isn't needed to talk to an external or host Ollama
+	if externalOllama() != "" || hostRuntime() {
+		return nil
+	}
+	err := docker.Ping()
+	if err == nil || errors.Is(err, errDockerNotInstalled) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", errDockerNotRunning, err)
+}
+
+// podmanCli is the docker CLI with its API client swapped for one talking to
+// podman's Docker-compatible API
+type podmanCli struct {
+	command.Cli
+	client client.APIClient
+}
+
+func (c podmanCli) Client() client.APIClient { return c.client }
+
+// engineCli returns the CLI whose API client drives the runner: the invoking
+// docker CLI, or under podman one pointed at podman's API socket
+var engineCli = sync.OnceValues(func() (command.Cli, error) {
+	if !podmanSocket() {
+		return dockerCLI, nil
+	}
+
+	output, err := exec.Command(containerRuntime(), "info", "--format", "{{.Host.RemoteSocket.Path}}").Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return nil, errDockerNotInstalled
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to find the podman API socket: %w", err)
+	}
+
+	socket := strings.TrimSpace(string(output))
+	if !strings.Contains(socket, "://") {
+		socket = "unix://" + socket
+	}
+	c, err := client.NewClientWithOpts(client.WithHost(socket), client.WithAPIVersionNegotiation())
+	if err != nil {
+		return nil, err
+	}
+	return podmanCli{Cli: dockerCLI, client: c}, nil
+})
+
+// engineClient returns the API client that drives the runner
+func engineClient() (client.APIClient, error) {
+	cli, err := engineCli()
+	if err != nil {
+		return nil, err
+	}
+	return cli.Client(), nil
+}
+
+// apiDockerRunner is the default dockerRunner, which uses the Docker Engine API
+type apiDockerRunner struct{}
+
+func (apiDockerRunner) Ping() error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err = c.Ping(ctx)
+	return err
+}
+
+func (apiDockerRunner) Inspect(name string) (containerState, bool, error) {
+	c, err := engineClient()
+	if err != nil {
+		return containerState{}, false, err
+	}
+	info, err := c.ContainerInspect(context.Background(), name)
+	if errdefs.IsNotFound(err) {
+		return containerState{}, false, nil
+	}
+	if err != nil {
+		return containerState{}, false, err
+	}
+
+	var state containerState
+	if info.State != nil {
+		state.Running = info.State.Running
+		state.Status = info.State.Status
+		if info.State.Health != nil {
+			state.Health = info.State.Health.Status
+		}
+	}
+	if info.HostConfig != nil {
+		state.Restart = string(info.HostConfig.RestartPolicy.Name)
+	}
+	if info.Config != nil {
+		state.Image = info.Config.Image
+		state.Labels = info.Config.Labels
+	}
+	// The ports actually published come from the running container; a
+	// stopped one only has the bindings it was created with
+	var bindings nat.PortMap
+	if info.NetworkSettings != nil {
+		bindings = info.NetworkSettings.Ports
+	}
+	if len(bindings) == 0 && info.HostConfig != nil {
+		bindings = info.HostConfig.PortBindings
+	}
+	state.HostPort = publishedPort(bindings)
+	return state, true, nil
+}
+
+// publishedPort returns the first host port in bindings, or 0 if there's none
+func publishedPort(bindings nat.PortMap) int {
+	for _, binding := range bindings {
+		for _, b := range binding {
+			if port, err := strconv.Atoi(b.HostPort); err == nil && port != 0 {
+				return port
+			}
+		}
+	}
+	return 0
+}
+
+func (apiDockerRunner) ListLabeled(label string) (map[string]containerState, error) {
+	c, err := engineClient()
+	if err != nil {
+		return nil, err
+	}
+	list, err := c.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", label)),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	states := make(map[string]containerState, len(list))
+	for _, summary := range list {
+		if len(summary.Names) == 0 {
+			continue
+		}
+		state := containerState{
+			Running: summary.State == "running",
+			Status:  summary.State,
+			Image:   summary.Image,
+			Labels:  summary.Labels,
+		}
+		for _, port := range summary.Ports {
+			if port.PublicPort != 0 {
+				state.HostPort = int(port.PublicPort)
+				break
+			}
+		}
+		states[strings.TrimPrefix(summary.Names[0], "/")] = state
+	}
+	return states, nil
+}
+
+func (apiDockerRunner) Create(ctx context.Context, spec containerSpec) error {
+	cli, err := engineCli()
+	if err != nil {
+		return err
+	}
+	c := cli.Client()
+
+	// Always pull, so a new runner picks up the latest image
+	auth, _ := command.RetrieveAuthTokenFromImage(cli.ConfigFile(), spec.Image)
+	pull, err := c.ImagePull(ctx, spec.Image, image.PullOptions{RegistryAuth: auth})
+	if err != nil {
+		return fmt.Errorf("failed to pull %s: %w", spec.Image, err)
+	}
+	defer pull.Close()
+	if err := jsonmessage.DisplayJSONMessagesStream(pull, io.Discard, 0, false, nil); err != nil {
+		return fmt.Errorf("failed to pull %s: %w", spec.Image, err)
+	}
+
+	config := &container.Config{
+		Image:        spec.Image,
+		Cmd:          spec.Cmd,
+		Env:          spec.Env,
+		Labels:       spec.Labels,
+		Healthcheck:  spec.Healthcheck,
+		ExposedPorts: nat.PortSet{},
+	}
+	hostConfig := spec.HostConfig
+	hostConfig.Binds = spec.Mounts
+	hostConfig.PortBindings = nat.PortMap{}
+	for hostPort, containerPort := range spec.Ports {
+		port := nat.Port(fmt.Sprintf("%d/tcp", containerPort))
+		config.ExposedPorts[port] = struct{}{}
+		binding := nat.PortBinding{}
+		if hostPort != 0 {
+			binding.HostPort = fmt.Sprint(hostPort)
+		}
+		hostConfig.PortBindings[port] = []nat.PortBinding{binding}
+	}
+
+	created, err := c.ContainerCreate(ctx, config, &hostConfig, nil, nil, spec.Name)
+	if err != nil {
+		return err
+	}
+	return c.ContainerStart(ctx, created.ID, container.StartOptions{})
+}
+
+func (apiDockerRunner) Start(name string) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	return c.ContainerStart(context.Background(), name, container.StartOptions{})
+}
+
+func (apiDockerRunner) Stop(name string) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	return c.ContainerStop(context.Background(), name, container.StopOptions{})
+}
+
+func (apiDockerRunner) Remove(name string) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	err = c.ContainerRemove(context.Background(), name, container.RemoveOptions{Force: true})
+	if errdefs.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (apiDockerRunner) CreateVolume(name string) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	// Creating a volume that already exists is a no-op
+	_, err = c.VolumeCreate(context.Background(), volume.CreateOptions{Name: name})
+	return err
+}
+
+func (apiDockerRunner) Exec(ctx context.Context, name string, cmd []string, stdout, stderr io.Writer) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+
+	// A process started by exec outlives the client that started it, so
+	// when the command can be cancelled it records its PID to be killed by
+	execCmd := cmd
+	var pidFile string
+	if ctx.Done() != nil {
+		pidFile = fmt.Sprintf("/tmp/mocker-exec-%d-%d.pid", os.Getpid(), time.Now().UnixNano())
+		execCmd = append([]string{"sh", "-c", `echo $$ > "$0" && exec "$@"`, pidFile}, cmd...)
+		defer func() {
+			cleanup := fmt.Sprintf(`rm -f %q`, pidFile)
+			if ctx.Err() != nil {
+				cleanup = fmt.Sprintf(`kill $(cat %q) 2>/dev/null; %s`, pidFile, cleanup)
+			}
+			_ = execDetached(c, name, []string{"sh", "-c", cleanup})
+		}()
+	}
+
+	// Nothing runs until the exec is attached, so failing to get that far
+	// is safe to retry
+	var created container.ExecCreateResponse
+	var attached types.HijackedResponse
+	err = withRetry(ctx, isTransient, func() error {
+		created, err = c.ContainerExecCreate(ctx, name, container.ExecOptions{
+			Cmd:          execCmd,
+			AttachStdout: true,
+			AttachStderr: true,
+		})
+		if err != nil {
+			return err
+		}
+		attached, err = c.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	defer attached.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stdcopy.StdCopy(stdout, stderr, attached.Reader)
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	result, err := c.ContainerExecInspect(context.Background(), created.ID)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s exited with status %d", cmd[0], result.ExitCode)
+	}
+	return nil
+}
+
+// execDetached runs a short command in a container without waiting for it,
+// for cleaning up after a cancelled one
+func execDetached(c client.APIClient, name string, cmd []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	created, err := c.ContainerExecCreate(ctx, name, container.ExecOptions{Cmd: cmd})
+	if err != nil {
+		return err
+	}
+	return c.ContainerExecStart(ctx, created.ID, container.ExecStartOptions{Detach: true})
+}
+
+func (apiDockerRunner) ExecInteractive(ctx context.Context, name string, cmd []string) error {
+	cli, err := engineCli()
+	if err != nil {
+		return err
+	}
+	c := cli.Client()
+	tty := cli.In().IsTerminal()
+
+	created, err := c.ContainerExecCreate(ctx, name, container.ExecOptions{
+		Cmd:          cmd,
+		Tty:          tty,
+		AttachStdin:  true,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return err
+	}
+
+	attached, err := c.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{Tty: tty})
+	if err != nil {
+		return err
+	}
+	defer attached.Close()
+
+	if tty {
+		// Keystrokes, including Ctrl+C, go straight to the process in the container
+		if err := cli.In().SetRawTerminal(); err != nil {
+			return err
+		}
+		defer cli.In().RestoreTerminal()
+		if err := cli.Out().SetRawTerminal(); err != nil {
+			return err
+		}
+		defer cli.Out().RestoreTerminal()
+
+		height, width := cli.Out().GetTtySize()
+		_ = c.ContainerExecResize(ctx, created.ID, container.ResizeOptions{Height: height, Width: width})
+	}
+
+	go func() {
+		_, _ = io.Copy(attached.Conn, cli.In())
+		_ = attached.CloseWrite()
+	}()
+
+	done := make(chan error, 1)
+	go func() {
+		var err error
+		if tty {
+			_, err = io.Copy(cli.Out(), attached.Reader)
+		} else {
+			_, err = stdcopy.StdCopy(cli.Out(), cli.Err(), attached.Reader)
+		}
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			return err
+		}
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	result, err := c.ContainerExecInspect(context.Background(), created.ID)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("%s exited with status %d", cmd[0], result.ExitCode)
+	}
+	return nil
+}
+
+func (apiDockerRunner) Logs(ctx context.Context, name string, opts logsOptions, stdout, stderr io.Writer) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+
+	logs, err := c.ContainerLogs(ctx, name, container.LogsOptions{
+		ShowStdout: true,
+		ShowStderr: true,
+		Follow:     opts.Follow,
+		Tail:       opts.Tail,
+		Since:      opts.Since,
+	})
+	if err != nil {
+		return err
+	}
+	defer logs.Close()
+
+	// The runner has no TTY, so its logs are multiplexed
+	_, err = stdcopy.StdCopy(stdout, stderr, logs)
+	return err
+}
+
+func (apiDockerRunner) Stats(ctx context.Context, name string, stream bool, fn func(containerStats) error) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.ContainerStats(ctx, name, stream)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var s container.StatsResponse
+		if err := dec.Decode(&s); err != nil {
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+		if err := fn(statsFromResponse(s)); err != nil {
+			return err
+		}
+	}
+}
+
+// statsFromResponse computes usage the way `docker stats` does: CPU as the
+// share of host CPU time since the previous sample, and memory without the
+// page cache, which the kernel can reclaim
+func statsFromResponse(s container.StatsResponse) containerStats {
+	stats := containerStats{MemUsage: s.MemoryStats.Usage, MemLimit: s.MemoryStats.Limit}
+
+	cpuDelta := float64(s.CPUStats.CPUUsage.TotalUsage) - float64(s.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(s.CPUStats.SystemUsage) - float64(s.PreCPUStats.SystemUsage)
+	cpus := float64(s.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(s.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		stats.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	// cgroup v2 reports inactive_file, v1 total_inactive_file
+	cache, ok := s.MemoryStats.Stats["inactive_file"]
+	if !ok {
+		cache = s.MemoryStats.Stats["total_inactive_file"]
+	}
+	if cache < stats.MemUsage {
+		stats.MemUsage -= cache
+	}
+	return stats
+}
+
+func (apiDockerRunner) CopyFile(ctx context.Context, name, dst string, content io.Reader, size int64) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+
+	// The API copies tar archives, so wrap the file in one as it streams,
+	// since model blobs are far too big to buffer
+	pr, pw := io.Pipe()
+	go func() {
+		tw := tar.NewWriter(pw)
+		err := tw.WriteHeader(&tar.Header{Name: path.Base(dst), Mode: 0o644, Size: size})
+		if err == nil {
+			_, err = io.Copy(tw, content)
+		}
+		if err == nil {
+			err = tw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+	defer pr.Close()
+
+	return c.CopyToContainer(ctx, name, path.Dir(dst), pr, container.CopyToContainerOptions{})
+}
+
+func (apiDockerRunner) CopyArchive(ctx context.Context, name, dst string, archive io.Reader) error {
+	c, err := engineClient()
+	if err != nil {
+		return err
+	}
+	return c.CopyToContainer(ctx, name, dst, archive, container.CopyToContainerOptions{})
+}
+
+func (apiDockerRunner) OpenFile(ctx context.Context, name, file string) (io.ReadCloser, int64, error) {
+	c, err := engineClient()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	archive, _, err := c.CopyFromContainer(ctx, name, file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	// A single file comes back as a one-entry archive
+	tr := tar.NewReader(archive)
+	hdr, err := tr.Next()
+	if err != nil {
+		archive.Close()
+		return nil, 0, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{tr, archive}, hdr.Size, nil
+}
+
+func (apiDockerRunner) ReadDir(name, dir string) (map[string][]byte, error) {
+	c, err := engineClient()
+	if err != nil {
+		return nil, err
+	}
+
+	archive, _, err := c.CopyFromContainer(context.Background(), name, dir)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+
+	// The archive's entries are rooted at the directory's own name
+	files := map[string][]byte{}
+	tr := tar.NewReader(archive)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		content, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		rel := strings.TrimPrefix(hdr.Name, path.Base(dir)+"/")
+		files[rel] = content
+	}
+}
+
+func (apiDockerRunner) Runtimes() ([]string, error) {
+	c, err := engineClient()
+	if err != nil {
+		return nil, err
+	}
+	info, err := c.Info(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	var runtimes []string
+	for name := range info.Runtimes {
+		runtimes = append(runtimes, name)
+	}
+	return runtimes, nil
+}
+
+func (apiDockerRunner) Rootless() (bool, error) {
+	c, err := engineClient()
+	if err != nil {
+		return false, err
+	}
+	info, err := c.Info(context.Background())
+	if err != nil {
+		return false, err
+	}
+	for _, option := range info.SecurityOptions {
+		if option == "name=rootless" {
+			return true, nil
+		}
+	}
+	return false, nil
+}
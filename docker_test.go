@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	// Keep the tests away from the user's config and runner settings, and
+	// from looking for a container engine on the PATH
+	dir, err := os.MkdirTemp("", "mocker-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("DOCKER_CONFIG", dir)
+	os.Setenv("MOCKER_RUNTIME", "docker")
+	for _, name := range []string{"DOCKER_HOST", "DOCKER_CONTEXT", "OLLAMA_HOST", "MOCKER_RUNNER", "MOCKER_CONTAINER_NAME", "MOCKER_IMAGE", "MOCKER_VOLUME", "MOCKER_PORT", "MOCKER_MEMORY", "MOCKER_CPUS", "MOCKER_SHM_SIZE", "MOCKER_RESTART", "MOCKER_KEEP_ALIVE"} {
+		os.Unsetenv(name)
+	}
+
+	code := m.Run()
+	os.RemoveAll(dir)
+	os.Exit(code)
+}
+
+// fakeDocker is a dockerRunner that answers from canned container state
+// instead of a daemon, recording every call made to it
+type fakeDocker struct {
+	mu         sync.Mutex
+	calls      []string
+	containers map[string]containerState
+	created    []containerSpec
+	execOutput string
+	createErr  error // returned by Create, leaving the containers as they are
+}
+
+// useFakeDocker swaps the fake in for the real runner for the rest of the
+// test
+func useFakeDocker(t *testing.T, containers map[string]containerState) *fakeDocker {
+	t.Helper()
+	if containers == nil {
+		containers = map[string]containerState{}
+	}
+	fake := &fakeDocker{containers: containers}
+	real := docker
+	docker = fake
+	t.Cleanup(func() { docker = real })
+	return fake
+}
+
+func (f *fakeDocker) record(call string, args ...string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, strings.Join(append([]string{call}, args...), " "))
+}
+
+// called reports whether a method was called, with any arguments
+func (f *fakeDocker) called(method string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return slices.ContainsFunc(f.calls, func(call string) bool {
+		return call == method || strings.HasPrefix(call, method+" ")
+	})
+}
+
+func (f *fakeDocker) Ping() error {
+	f.record("Ping")
+	return nil
+}
+
+func (f *fakeDocker) Inspect(name string) (containerState, bool, error) {
+	f.record("Inspect", name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.containers[name]
+	return state, ok, nil
+}
+
+func (f *fakeDocker) Create(ctx context.Context, spec containerSpec) error {
+	f.record("Create", spec.Name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.createErr != nil {
+		return f.createErr
+	}
+	f.created = append(f.created, spec)
+	f.containers[spec.Name] = containerState{Running: true, Status: "running", Image: spec.Image, Labels: spec.Labels}
+	return nil
+}
+
+func (f *fakeDocker) Start(name string) error {
+	f.record("Start", name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	state, ok := f.containers[name]
+	if !ok {
+		return fmt.Errorf("no such container: %s", name)
+	}
+	state.Running = true
+	f.containers[name] = state
+	return nil
+}
+
+func (f *fakeDocker) Stop(name string) error {
+	f.record("Stop", name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if state, ok := f.containers[name]; ok {
+		state.Running = false
+		f.containers[name] = state
+	}
+	return nil
+}
+
+func (f *fakeDocker) Remove(name string) error {
+	f.record("Remove", name)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.containers, name)
+	return nil
+}
+
+func (f *fakeDocker) CreateVolume(name string) error {
+	f.record("CreateVolume", name)
+	return nil
+}
+
+func (f *fakeDocker) Exec(ctx context.Context, name string, cmd []string, stdout, stderr io.Writer) error {
+	f.record("Exec", append([]string{name}, cmd...)...)
+	_, err := io.WriteString(stdout, f.execOutput)
+	return err
+}
+
+func (f *fakeDocker) ExecInteractive(ctx context.Context, name string, cmd []string) error {
+	f.record("ExecInteractive", append([]string{name}, cmd...)...)
+	return nil
+}
+
+func (f *fakeDocker) Logs(ctx context.Context, name string, opts logsOptions, stdout, stderr io.Writer) error {
+	f.record("Logs", name)
+	return nil
+}
+
+func (f *fakeDocker) Stats(ctx context.Context, name string, stream bool, fn func(containerStats) error) error {
+	f.record("Stats", name)
+	return fn(containerStats{})
+}
+
+func (f *fakeDocker) CopyFile(ctx context.Context, name, dst string, content io.Reader, size int64) error {
+	f.record("CopyFile", name, dst)
+	_, err := io.Copy(io.Discard, content)
+	return err
+}
+
+func (f *fakeDocker) CopyArchive(ctx context.Context, name, dst string, archive io.Reader) error {
+	f.record("CopyArchive", name, dst)
+	_, err := io.Copy(io.Discard, archive)
+	return err
+}
+
+func (f *fakeDocker) OpenFile(ctx context.Context, name, file string) (io.ReadCloser, int64, error) {
+	f.record("OpenFile", name, file)
+	return io.NopCloser(strings.NewReader("")), 0, nil
+}
+
+func (f *fakeDocker) ReadDir(name, dir string) (map[string][]byte, error) {
+	f.record("ReadDir", name, dir)
+	return map[string][]byte{}, nil
+}
+
+func (f *fakeDocker) Runtimes() ([]string, error) {
+	f.record("Runtimes")
+	return []string{"runc"}, nil
+}
+
+func (f *fakeDocker) Rootless() (bool, error) {
+	f.record("Rootless")
+	return false, nil
+}
+
+func (f *fakeDocker) ListLabeled(label string) (map[string]containerState, error) {
+	f.record("ListLabeled", label)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	states := map[string]containerState{}
+	for name, state := range f.containers {
+		if _, ok := state.Labels[label]; ok {
+			states[name] = state
+		}
+	}
+	return states, nil
+}
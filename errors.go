@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"strings"
+
+	"github.com/docker/cli/cli"
+	"github.com/spf13/cobra"
+)
+
+// Failure classes, so scripts can tell why a command failed without
+// matching on its message. Each is wrapped by the errors of its class
+var (
+	errRunnerNotRunning   = errors.New("the Mocker Model Runner is not running")
+	errBackendUnreachable = errors.New("failed to reach Ollama API")
+	errPullFailed         = errors.New("failed to pull")
+	errPermissionDenied   = errors.New("permission denied")
+)
+
+// failureClass is how a class of failure is reported: a stable code for
+// JSON output and the status the plugin exits with
+type failureClass struct {
+	code     string
+	exitCode int
+	errs     []error
+}
+
+// failureClasses are checked in order, so a failure matching several gets
+// the most specific class. Anything else exits 1
+var failureClasses = []failureClass{
+	{"interrupted", 130, []error{context.Canceled}},
+	{"runner_busy", 8, []error{errRunnerBusy}},
+	{"permission_denied", 7, []error{errPermissionDenied, fs.ErrPermission}},
+	{"pull_failed", 6, []error{errPullFailed}},
+	{"model_not_found", 5, []error{errModelNotFound}},
+	{"backend_unreachable", 4, []error{errBackendUnreachable, errDockerNotRunning, errDockerNotInstalled}},
+	{"runner_not_running", 3, []error{errRunnerNotRunning}},
+}
+
+// classifiedError puts an error in a failure class without changing its
+// message
+type classifiedError struct {
+	error
+	class error
+}
+
+func (e classifiedError) Unwrap() []error { return []error{e.error, e.class} }
+
+// classify puts err in the failure class of class
+func classify(err, class error) error {
+	return classifiedError{err, class}
+}
+
+// classOf returns the failure class of err
+func classOf(err error) failureClass {
+	// The docker client reports a socket it can't open only in its message
+	if strings.Contains(strings.ToLower(err.Error()), "permission denied") {
+		err = classify(err, errPermissionDenied)
+	}
+	for _, class := range failureClasses {
+		for _, target := range class.errs {
+			if errors.Is(err, target) {
+				return class
+			}
+		}
+	}
+	return failureClass{code: "error", exitCode: 1}
+}
+
+// reportErrors makes every command exit with the status for the class of
+// its failure. Commands asked for JSON output report the failure as JSON
+// too, and an interrupted command exits as a shell does on Ctrl+C
+func reportErrors(cmd *cobra.Command) {
+	for _, sub := range cmd.Commands() {
+		reportErrors(sub)
+	}
+	run := cmd.RunE
+	if run == nil {
+		return
+	}
+	cmd.RunE = func(cmd *cobra.Command, args []string) error {
+		err := run(cmd, args)
+		if err == nil {
+			return nil
+		}
+
+		class := classOf(err)
+		status := err.Error()
+		if class.code == "interrupted" {
+			status = "Interrupted"
+		}
+		if wantsJSON(cmd) {
+			data, _ := json.Marshal(map[string]any{"error": status, "code": class.code, "exit_code": class.exitCode})
+			status = string(data)
+		}
+		return cli.StatusError{Cause: err, Status: status, StatusCode: class.exitCode}
+	}
+}
+
+// wantsJSON reports whether a command was asked for JSON output, with
+// --format json or --json
+func wantsJSON(cmd *cobra.Command) bool {
+	if f := cmd.Flags().Lookup("format"); f != nil && f.Value.String() == "json" {
+		return true
+	}
+	f := cmd.Flags().Lookup("json")
+	return f != nil && f.Value.String() == "true"
+}
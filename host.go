@@ -0,0 +1,378 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/cli/config"
+)
+
+// HostAgentLabel names the launchd agent that supervises a host Ollama on macOS
+const HostAgentLabel = "io.mocker.ollama"
+
+// hostRuntime reports whether Ollama runs natively on this machine instead
+// of in the runner container: --runtime host, or the runtime setting when
+// no --runtime is given
+func hostRuntime() bool {
+	if runtimeFlag != "" {
+		return runtimeFlag == "host"
+	}
+	return settings().Runtime == "host"
+}
+
+// hostDir is where Mocker keeps what it needs to run Ollama on the host: a
+// downloaded binary, its log and its PID
+func hostDir() string {
+	return filepath.Join(config.Dir(), "mocker", "host")
+}
+
+// hostLogPath is where a host Ollama's output goes
+func hostLogPath() string {
+	return filepath.Join(hostDir(), "ollama.log")
+}
+
+// hostAPIAddress is the address a host Ollama listens on. It's kept to this
+// machine, as a published container port would be
+func hostAPIAddress() string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(ollamaPort()))
+}
+
+// hostOllamaRunning reports whether a host Ollama answers on its port
+func hostOllamaRunning() bool {
+	probe := &http.Client{Timeout: time.Second}
+	resp, err := probe.Get("http://" + hostAPIAddress() + "/api/version")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// hostGPUStatus describes the acceleration a host Ollama gets. On Apple
+// Silicon that's Metal; elsewhere Ollama finds what GPUs it can itself
+func hostGPUStatus() string {
+	if runtime.GOOS == "darwin" && runtime.GOARCH == "arm64" {
+		return "active (Metal)"
+	}
+	return "detected by Ollama"
+}
+
+// ensureHostOllama starts Ollama on the host unless it's already answering,
+// fetching it first if need be
+func ensureHostOllama(ctx context.Context) error {
+	if hostOllamaRunning() {
+		return nil
+	}
+	binary, err := hostOllamaBinary(ctx)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintln(os.Stderr, "Starting Ollama on this machine...")
+	if err := os.MkdirAll(hostDir(), 0o755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", hostDir(), err)
+	}
+	env, err := runnerEnv()
+	if err != nil {
+		return fmt.Errorf("invalid --env value: %w", err)
+	}
+	if runtime.GOOS == "darwin" {
+		err = startLaunchAgent(binary, env)
+	} else {
+		err = startHostProcess(binary, env)
+	}
+	if err != nil {
+		return classify(fmt.Errorf("failed to start Ollama: %w", err), errRunnerNotRunning)
+	}
+	return waitForRunner(ctx)
+}
+
+// stopHostOllama stops a host Ollama started by Mocker, reporting whether
+// one was running. It waits a few seconds for it to let go of its port, so
+// it can be started again straight away
+func stopHostOllama() (bool, error) {
+	stop := stopHostProcess
+	if runtime.GOOS == "darwin" {
+		stop = stopLaunchAgent
+	}
+	stopped, err := stop()
+	if err != nil || !stopped {
+		return stopped, err
+	}
+	for deadline := time.Now().Add(10 * time.Second); hostOllamaRunning() && time.Now().Before(deadline); {
+		time.Sleep(200 * time.Millisecond)
+	}
+	return true, nil
+}
+
+// stopHostRunner is `docker model stop` for the host runtime. Ollama can't
+// be stopped if something other than Mocker started it
+func stopHostRunner(dockerCli command.Cli) error {
+	stopped, err := stopHostOllama()
+	if err != nil {
+		return fmt.Errorf("failed to stop Ollama: %w", err)
+	}
+	switch {
+	case stopped:
+		_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner stopped")
+	case hostOllamaRunning():
+		return fmt.Errorf("the Ollama at %s wasn't started by Mocker; stop it the way it was started", ollamaAPIURL())
+	default:
+		_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running")
+	}
+	return nil
+}
+
+// runnerPublicKey returns the key Ollama signs ollama.com requests with,
+// from the runner container or, for the host runtime, the user's home
+func runnerPublicKey() (string, error) {
+	if !hostRuntime() {
+		return runInOllama("cat", "/root/.ollama/id_ed25519.pub")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	key, err := os.ReadFile(filepath.Join(home, ".ollama", "id_ed25519.pub"))
+	return string(key), err
+}
+
+// hostOllamaBinary finds Ollama on the PATH or, on macOS, downloads the
+// official build, pinned to the tag setting if there is one
+func hostOllamaBinary(ctx context.Context) (string, error) {
+	if path, err := exec.LookPath("ollama"); err == nil {
+		return path, nil
+	}
+	binary := filepath.Join(hostDir(), "ollama")
+	if _, err := os.Stat(binary); err == nil {
+		return binary, nil
+	}
+	if runtime.GOOS != "darwin" {
+		return "", fmt.Errorf("ollama was not found on the PATH; install it from https://ollama.com/download to use the host runtime")
+	}
+
+	url := "https://github.com/ollama/ollama/releases/latest/download/ollama-darwin.tgz"
+	if tag := settings().Tag; tag != "" && tag != "latest" {
+		url = "https://github.com/ollama/ollama/releases/download/v" + strings.TrimPrefix(tag, "v") + "/ollama-darwin.tgz"
+	}
+	fmt.Fprintf(os.Stderr, "Downloading Ollama for macOS from %s...\n", url)
+	if err := downloadHostOllama(ctx, url, hostDir()); err != nil {
+		return "", fmt.Errorf("failed to download Ollama: %w", err)
+	}
+	if _, err := os.Stat(binary); err != nil {
+		return "", fmt.Errorf("the Ollama download from %s didn't contain an ollama binary", url)
+	}
+	return binary, nil
+}
+
+// downloadHostOllama unpacks an Ollama release archive into dir
+func downloadHostOllama(ctx context.Context, url, dir string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+
+	gz, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(hdr.Name)
+		if hdr.Typeflag != tar.TypeReg || filepath.IsAbs(name) || strings.HasPrefix(name, "..") {
+			continue
+		}
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return err
+		}
+		f, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(hdr.Mode)&0o755|0o600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, tr)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// launchAgentTemplate is the launchd agent that keeps a host Ollama running,
+// restarting it if it exits and starting it again at login
+var launchAgentTemplate = template.Must(template.New("agent").Parse(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>Label</key>
+	<string>{{.Label}}</string>
+	<key>ProgramArguments</key>
+	<array>
+		<string>{{.Binary}}</string>
+		<string>serve</string>
+	</array>
+	<key>EnvironmentVariables</key>
+	<dict>
+		<key>OLLAMA_HOST</key>
+		<string>{{.Address}}</string>
+{{- range .Env}}
+		<key>{{html .Name}}</key>
+		<string>{{html .Value}}</string>
+{{- end}}
+	</dict>
+	<key>RunAtLoad</key>
+	<true/>
+	<key>KeepAlive</key>
+	<true/>
+	<key>StandardOutPath</key>
+	<string>{{.Log}}</string>
+	<key>StandardErrorPath</key>
+	<string>{{.Log}}</string>
+</dict>
+</plist>
+`))
+
+// launchAgentPath is where the launchd agent is installed
+func launchAgentPath() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, "Library", "LaunchAgents", HostAgentLabel+".plist")
+}
+
+// launchDomain is the launchd domain of the user's GUI session
+func launchDomain() string {
+	return "gui/" + strconv.Itoa(os.Getuid())
+}
+
+// startLaunchAgent installs the launchd agent for binary and starts it with
+// the extra environment variables in env, replacing any previous one
+func startLaunchAgent(binary string, env []string) error {
+	type variable struct{ Name, Value string }
+	var variables []variable
+	for _, v := range env {
+		name, value, _ := strings.Cut(v, "=")
+		variables = append(variables, variable{name, value})
+	}
+	var plist bytes.Buffer
+	err := launchAgentTemplate.Execute(&plist, map[string]any{
+		"Label":   HostAgentLabel,
+		"Binary":  binary,
+		"Address": hostAPIAddress(),
+		"Log":     hostLogPath(),
+		"Env":     variables,
+	})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(launchAgentPath()), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(launchAgentPath(), plist.Bytes(), 0o644); err != nil {
+		return err
+	}
+
+	_ = exec.Command("launchctl", "bootout", launchDomain()+"/"+HostAgentLabel).Run()
+	if output, err := exec.Command("launchctl", "bootstrap", launchDomain(), launchAgentPath()).CombinedOutput(); err != nil {
+		return fmt.Errorf("launchctl bootstrap: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// stopLaunchAgent stops the launchd agent and uninstalls it, so Ollama
+// doesn't come back at the next login
+func stopLaunchAgent() (bool, error) {
+	if _, err := os.Stat(launchAgentPath()); errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	_ = exec.Command("launchctl", "bootout", launchDomain()+"/"+HostAgentLabel).Run()
+	if err := os.Remove(launchAgentPath()); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// hostPIDPath records the PID of a host Ollama started without launchd
+func hostPIDPath() string {
+	return filepath.Join(hostDir(), "ollama.pid")
+}
+
+// startHostProcess runs `ollama serve` in the background with the extra
+// environment variables in env, detached from this command so it keeps
+// running after it
+func startHostProcess(binary string, env []string) error {
+	log, err := os.OpenFile(hostLogPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer log.Close()
+
+	cmd := exec.Command(binary, "serve")
+	cmd.Env = append(append(os.Environ(), env...), "OLLAMA_HOST="+hostAPIAddress())
+	cmd.Stdout, cmd.Stderr = log, log
+	cmd.SysProcAttr = detachedProcAttr()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if err := os.WriteFile(hostPIDPath(), []byte(strconv.Itoa(cmd.Process.Pid)), 0o644); err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// stopHostProcess stops a host Ollama started with startHostProcess
+func stopHostProcess() (bool, error) {
+	data, err := os.ReadFile(hostPIDPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(hostPIDPath())
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		return false, nil
+	}
+	proc, err := os.FindProcess(pid)
+	if err != nil {
+		return false, nil
+	}
+	if err := terminateProcess(proc); err != nil {
+		// It had already exited
+		return false, nil
+	}
+	return true, nil
+}
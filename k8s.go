@@ -0,0 +1,325 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/opts"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// preloadScript is what the init container runs to pull the models given as
+// its arguments into the models volume before Ollama starts
+const preloadScript = `ollama serve >/dev/null 2>&1 &
+until ollama list >/dev/null 2>&1; do sleep 1; done
+for model in "$@"; do ollama pull "$model" || exit 1; done`
+
+// k8sObject is a Kubernetes object, with only the fields the manifests use
+type k8sObject struct {
+	APIVersion string      `yaml:"apiVersion"`
+	Kind       string      `yaml:"kind"`
+	Metadata   k8sMetadata `yaml:"metadata"`
+	Spec       any         `yaml:"spec"`
+}
+
+type k8sMetadata struct {
+	Name      string            `yaml:"name,omitempty"`
+	Namespace string            `yaml:"namespace,omitempty"`
+	Labels    map[string]string `yaml:"labels,omitempty"`
+}
+
+type k8sPVCSpec struct {
+	AccessModes      []string     `yaml:"accessModes"`
+	StorageClassName string       `yaml:"storageClassName,omitempty"`
+	Resources        k8sResources `yaml:"resources"`
+}
+
+type k8sServiceSpec struct {
+	Selector map[string]string `yaml:"selector"`
+	Ports    []k8sServicePort  `yaml:"ports"`
+}
+
+type k8sServicePort struct {
+	Name       string `yaml:"name"`
+	Port       int    `yaml:"port"`
+	TargetPort int    `yaml:"targetPort"`
+}
+
+type k8sDeploymentSpec struct {
+	Replicas int `yaml:"replicas"`
+	// The models volume can only be mounted by one pod at a time, so the
+	// old pod has to go before the new one starts
+	Strategy struct {
+		Type string `yaml:"type"`
+	} `yaml:"strategy"`
+	Selector struct {
+		MatchLabels map[string]string `yaml:"matchLabels"`
+	} `yaml:"selector"`
+	Template struct {
+		Metadata k8sMetadata `yaml:"metadata"`
+		Spec     k8sPodSpec  `yaml:"spec"`
+	} `yaml:"template"`
+}
+
+type k8sPodSpec struct {
+	InitContainers []k8sContainer `yaml:"initContainers,omitempty"`
+	Containers     []k8sContainer `yaml:"containers"`
+	Volumes        []k8sVolume    `yaml:"volumes"`
+}
+
+type k8sContainer struct {
+	Name           string           `yaml:"name"`
+	Image          string           `yaml:"image"`
+	Command        []string         `yaml:"command,omitempty"`
+	Args           []string         `yaml:"args,omitempty"`
+	Env            []k8sEnvVar      `yaml:"env,omitempty"`
+	Ports          []k8sPort        `yaml:"ports,omitempty"`
+	Resources      *k8sResources    `yaml:"resources,omitempty"`
+	VolumeMounts   []k8sVolumeMount `yaml:"volumeMounts"`
+	ReadinessProbe *k8sProbe        `yaml:"readinessProbe,omitempty"`
+	LivenessProbe  *k8sProbe        `yaml:"livenessProbe,omitempty"`
+}
+
+type k8sEnvVar struct {
+	Name  string `yaml:"name"`
+	Value string `yaml:"value"`
+}
+
+type k8sPort struct {
+	Name          string `yaml:"name"`
+	ContainerPort int    `yaml:"containerPort"`
+}
+
+type k8sResources struct {
+	Requests map[string]string `yaml:"requests,omitempty"`
+	Limits   map[string]string `yaml:"limits,omitempty"`
+}
+
+type k8sVolume struct {
+	Name                  string `yaml:"name"`
+	PersistentVolumeClaim struct {
+		ClaimName string `yaml:"claimName"`
+	} `yaml:"persistentVolumeClaim"`
+}
+
+type k8sVolumeMount struct {
+	Name      string `yaml:"name"`
+	MountPath string `yaml:"mountPath"`
+}
+
+type k8sProbe struct {
+	HTTPGet struct {
+		Path string `yaml:"path"`
+		Port int    `yaml:"port"`
+	} `yaml:"httpGet"`
+	InitialDelaySeconds int `yaml:"initialDelaySeconds,omitempty"`
+	PeriodSeconds       int `yaml:"periodSeconds"`
+}
+
+// k8sOptions is what the manifests are generated from
+type k8sOptions struct {
+	runnerConfig
+	namespace    string
+	storage      string
+	storageClass string
+}
+
+// k8sManifests returns the PersistentVolumeClaim, Deployment and Service that
+// run the runner as configured in o on Kubernetes, as one YAML stream
+func k8sManifests(o k8sOptions) ([]byte, error) {
+	labels := map[string]string{"app.kubernetes.io/name": o.name, "app.kubernetes.io/managed-by": "mocker"}
+	meta := k8sMetadata{Name: o.name, Namespace: o.namespace, Labels: labels}
+	volume := o.name + "-models"
+
+	pvc := k8sObject{APIVersion: "v1", Kind: "PersistentVolumeClaim", Metadata: meta, Spec: k8sPVCSpec{
+		AccessModes:      []string{"ReadWriteOnce"},
+		StorageClassName: o.storageClass,
+		Resources:        k8sResources{Requests: map[string]string{"storage": o.storage}},
+	}}
+	pvc.Metadata.Name = volume
+
+	resources, err := k8sRunnerResources(o.runnerConfig)
+	if err != nil {
+		return nil, err
+	}
+	runner := k8sContainer{
+		Name:           "ollama",
+		Image:          o.image,
+		Ports:          []k8sPort{{Name: "http", ContainerPort: DefaultOllamaPort}},
+		Resources:      resources,
+		VolumeMounts:   []k8sVolumeMount{{Name: "models", MountPath: "/root/.ollama"}},
+		ReadinessProbe: k8sHTTPProbe("/api/tags", 0),
+		LivenessProbe:  k8sHTTPProbe("/api/version", 30),
+	}
+	var env []string
+	if o.cpuOnly {
+		env = cpuOnlyEnv
+	}
+	for _, variable := range slices.Concat(env, o.env) {
+		name, value, _ := strings.Cut(variable, "=")
+		runner.Env = append(runner.Env, k8sEnvVar{Name: name, Value: value})
+	}
+
+	var deploymentSpec k8sDeploymentSpec
+	deploymentSpec.Replicas = 1
+	deploymentSpec.Strategy.Type = "Recreate"
+	deploymentSpec.Selector.MatchLabels = map[string]string{"app.kubernetes.io/name": o.name}
+	deploymentSpec.Template.Metadata = k8sMetadata{Labels: labels}
+	deploymentSpec.Template.Spec = k8sPodSpec{
+		Containers: []k8sContainer{runner},
+		Volumes:    []k8sVolume{{Name: "models"}},
+	}
+	deploymentSpec.Template.Spec.Volumes[0].PersistentVolumeClaim.ClaimName = volume
+	if len(o.models) > 0 {
+		deploymentSpec.Template.Spec.InitContainers = []k8sContainer{{
+			Name:         "preload-models",
+			Image:        o.image,
+			Command:      []string{"sh", "-c", preloadScript, "preload-models"},
+			Args:         o.models,
+			VolumeMounts: runner.VolumeMounts,
+		}}
+	}
+	deployment := k8sObject{APIVersion: "apps/v1", Kind: "Deployment", Metadata: meta, Spec: deploymentSpec}
+
+	service := k8sObject{APIVersion: "v1", Kind: "Service", Metadata: meta, Spec: k8sServiceSpec{
+		Selector: deploymentSpec.Selector.MatchLabels,
+		Ports:    []k8sServicePort{{Name: "http", Port: DefaultOllamaPort, TargetPort: DefaultOllamaPort}},
+	}}
+
+	var out bytes.Buffer
+	for i, object := range []k8sObject{pvc, deployment, service} {
+		if i > 0 {
+			out.WriteString("---\n")
+		}
+		enc := yaml.NewEncoder(&out)
+		enc.SetIndent(2)
+		if err := enc.Encode(object); err != nil {
+			return nil, err
+		}
+		if err := enc.Close(); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// k8sHTTPProbe checks that Ollama answers on path
+func k8sHTTPProbe(path string, delay int) *k8sProbe {
+	probe := &k8sProbe{InitialDelaySeconds: delay, PeriodSeconds: 10}
+	probe.HTTPGet.Path = path
+	probe.HTTPGet.Port = DefaultOllamaPort
+	return probe
+}
+
+// k8sRunnerResources turns the runner's GPU, memory and CPU settings into a
+// container's resource limits. Kubernetes can't hand a pod every GPU on a
+// node, so "all" is one GPU
+func k8sRunnerResources(o runnerConfig) (*k8sResources, error) {
+	limits := map[string]string{}
+	if o.memory != "" {
+		var memory opts.MemBytes
+		if err := memory.Set(o.memory); err != nil {
+			return nil, fmt.Errorf("invalid memory limit %q: %w", o.memory, err)
+		}
+		limits["memory"] = strconv.FormatInt(memory.Value(), 10)
+	}
+	if o.cpus != "" {
+		var cpus opts.NanoCPUs
+		if err := cpus.Set(o.cpus); err != nil {
+			return nil, fmt.Errorf("invalid CPU limit %q: %w", o.cpus, err)
+		}
+		limits["cpu"] = strconv.FormatInt(cpus.Value()/1e6, 10) + "m"
+	}
+	switch {
+	case o.cpuOnly:
+	case o.rocm:
+		limits["amd.com/gpu"] = "1"
+	case o.gpus != "":
+		var gpus opts.GpuOpts
+		if err := gpus.Set(o.gpus); err != nil {
+			return nil, fmt.Errorf("invalid GPU setting %q: %w", o.gpus, err)
+		}
+		count := 0
+		for _, request := range gpus.Value() {
+			switch {
+			case len(request.DeviceIDs) > 0:
+				count += len(request.DeviceIDs)
+			case request.Count > 0:
+				count += request.Count
+			default:
+				count++
+			}
+		}
+		limits["nvidia.com/gpu"] = strconv.Itoa(count)
+	}
+	if len(limits) == 0 {
+		return nil, nil
+	}
+	return &k8sResources{Limits: limits}, nil
+}
+
+// K8s command
+func newK8sCommand(dockerCli command.Cli) *cobra.Command {
+	var namespace, storage, storageClass string
+	var models []string
+	var apply bool
+
+	cmd := &cobra.Command{
+		Use:   "k8s",
+		Short: "Generate Kubernetes manifests that run the model runner as configured here",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, model := range models {
+				if err := validateModelName(model); err != nil {
+					return err
+				}
+			}
+
+			runner, err := localRunnerConfig(ctx, cmd, "Kubernetes")
+			if err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("models") {
+				runner.models = models
+			}
+
+			manifests, err := k8sManifests(k8sOptions{runner, namespace, storage, storageClass})
+			if err != nil {
+				return err
+			}
+			if !apply {
+				_, err = dockerCli.Out().Write(manifests)
+				return err
+			}
+
+			kubectl, err := exec.LookPath("kubectl")
+			if err != nil {
+				return fmt.Errorf("kubectl was not found on the PATH; install it, or leave out --apply and apply the manifests yourself")
+			}
+			kubectlApply := exec.CommandContext(ctx, kubectl, "apply", "-f", "-")
+			kubectlApply.Stdin = bytes.NewReader(manifests)
+			kubectlApply.Stdout, kubectlApply.Stderr = dockerCli.Out(), dockerCli.Err()
+			if err := kubectlApply.Run(); err != nil {
+				return fmt.Errorf("kubectl apply failed: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&namespace, "namespace", "n", "", "Namespace to create the objects in (default the current kubeconfig context's)")
+	cmd.Flags().StringSliceVar(&models, "models", nil, "Models to pull before the runner starts (default the models installed in the local runner)")
+	cmd.Flags().StringVar(&storage, "storage", "50Gi", "Size of the volume models are kept in")
+	cmd.Flags().StringVar(&storageClass, "storage-class", "", "Storage class of the models volume (default the cluster's default)")
+	cmd.Flags().BoolVar(&apply, "apply", false, "Apply the manifests to the cluster of the current kubeconfig context with kubectl, instead of printing them")
+
+	return cmd
+}
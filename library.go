@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// OllamaLibraryURL is the site that hosts the Ollama model library. It has
+// no search API, so mocker reads the same pages a browser would
+const OllamaLibraryURL = "https://ollama.com"
+
+var (
+	// libraryTitleRegex, and the others below, match the fields ollama.com
+	// marks with x-test attributes in its search results
+	libraryTitleRegex       = regexp.MustCompile(`x-test-search-response-title[^>]*>([^<]+)<`)
+	libraryDescriptionRegex = regexp.MustCompile(`(?s)<p class="[^"]*break-words[^"]*"[^>]*>(.*?)</p>`)
+	libraryCapabilityRegex  = regexp.MustCompile(`x-test-capability[^>]*>([^<]+)<`)
+	librarySizeRegex        = regexp.MustCompile(`x-test-size[^>]*>([^<]+)<`)
+	libraryPullCountRegex   = regexp.MustCompile(`x-test-pull-count[^>]*>([^<]+)<`)
+	libraryUpdatedRegex     = regexp.MustCompile(`x-test-updated[^>]*>([^<]+)<`)
+)
+
+// searchRow is a model in the Ollama library as reported by search
+type searchRow struct {
+	Name         string   `json:"name"`
+	Description  string   `json:"description"`
+	Sizes        []string `json:"sizes"`
+	Capabilities []string `json:"capabilities"`
+	Pulls        string   `json:"pulls"`
+	Updated      string   `json:"updated"`
+}
+
+// fetchLibraryPage returns the HTML of a page on ollama.com
+func fetchLibraryPage(ctx context.Context, path string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, OllamaLibraryURL+path, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach %s: %w", OllamaLibraryURL, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return "", errModelNotFound
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s returned %s", OllamaLibraryURL, resp.Status)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", OllamaLibraryURL, err)
+	}
+	return string(body), nil
+}
+
+// searchLibrary returns the models in the Ollama library matching query
+func searchLibrary(ctx context.Context, query string) ([]searchRow, error) {
+	page, err := fetchLibraryPage(ctx, "/search?q="+url.QueryEscape(query))
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []searchRow{}
+	// Each result starts with an element marked x-test-model
+	for _, item := range strings.Split(page, "x-test-model")[1:] {
+		title := libraryTitleRegex.FindStringSubmatch(item)
+		if title == nil {
+			continue
+		}
+		row := searchRow{
+			Name:         strings.TrimSpace(html.UnescapeString(title[1])),
+			Sizes:        allSubmatches(librarySizeRegex, item),
+			Capabilities: allSubmatches(libraryCapabilityRegex, item),
+		}
+		if m := libraryDescriptionRegex.FindStringSubmatch(item); m != nil {
+			row.Description = strings.Join(strings.Fields(html.UnescapeString(m[1])), " ")
+		}
+		if m := libraryPullCountRegex.FindStringSubmatch(item); m != nil {
+			row.Pulls = strings.TrimSpace(m[1])
+		}
+		if m := libraryUpdatedRegex.FindStringSubmatch(item); m != nil {
+			row.Updated = strings.TrimSpace(m[1])
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// allSubmatches returns the trimmed first group of every match of re in s
+func allSubmatches(re *regexp.Regexp, s string) []string {
+	values := []string{}
+	for _, m := range re.FindAllStringSubmatch(s, -1) {
+		values = append(values, strings.TrimSpace(html.UnescapeString(m[1])))
+	}
+	return values
+}
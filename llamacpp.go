@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// LlamaCppImage is the image a llama.cpp runner is created from. Its
+	// tag is suffixed for GPU builds, e.g. server-cuda
+	LlamaCppImage = "ghcr.io/ggml-org/llama.cpp:server"
+	// LlamaCppPort is the port llama-server listens on in the container
+	LlamaCppPort = 8080
+	// ModelsDirLabel records the host directory a llama.cpp runner serves
+	// GGUF files from
+	ModelsDirLabel = "mocker.models-dir"
+)
+
+// llamaCppBackend serves a GGUF file with llama.cpp's llama-server, through
+// its OpenAI-compatible API. The file is read from a directory on the host,
+// so there's no Ollama store in between
+type llamaCppBackend struct{}
+
+func (llamaCppBackend) Name() string      { return "llama.cpp" }
+func (llamaCppBackend) ReadyPath() string { return "/health" }
+
+func (llamaCppBackend) List(ctx context.Context) ([]localModel, error) {
+	return openAIModels(ctx)
+}
+
+func (llamaCppBackend) Pull(ctx context.Context, name string, fn func(pullResponse)) error {
+	return fmt.Errorf("%w: llama.cpp serves GGUF files from %s; put the file there and create the runner for it", errUnsupportedByBackend, containerLabel(ModelsDirLabel))
+}
+
+func (llamaCppBackend) Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	return openAIGenerate(ctx, req, w, stallTimeout)
+}
+
+func (llamaCppBackend) Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	return openAIChat(ctx, req.Model, req.Messages, req.Options, nil, w, 0)
+}
+
+func (llamaCppBackend) Embed(ctx context.Context, model string, inputs []string, _ any) ([][]float64, error) {
+	return openAIEmbed(ctx, model, inputs)
+}
+
+// llamaCppHealthcheck has Docker check that llama-server answers, using the
+// curl its image ships
+var llamaCppHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "curl", "-fs", "http://localhost:8080/health"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 2 * time.Minute,
+	Retries:     3,
+}
+
+// ggufFile resolves the GGUF file a llama.cpp runner serves from dir, given
+// its name with or without the .gguf extension. The file is only checked
+// for when the daemon is local, as a remote one mounts its own directory
+func ggufFile(dir, model string) (string, error) {
+	file := filepath.Clean(model)
+	if !strings.HasSuffix(file, ".gguf") {
+		file += ".gguf"
+	}
+	if filepath.IsAbs(file) || strings.HasPrefix(file, "..") {
+		return "", fmt.Errorf("--model must name a GGUF file inside %s", dir)
+	}
+	if runnerHostname() == "localhost" {
+		if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+			return "", fmt.Errorf("no GGUF file %s in %s", file, dir)
+		}
+	}
+	return file, nil
+}
+
+// createLlamaCppRunner creates a runner that serves a GGUF file in dir with
+// llama-server on the runner's port, then waits for it to load. The
+// directory is mounted read-only. The model is served under its file name
+// without the extension. An NVIDIA or AMD GPU is used when one is
+// available, as for Ollama
+func createLlamaCppRunner(ctx context.Context, dir, model string) error {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	file, err := ggufFile(dir, model)
+	if err != nil {
+		return err
+	}
+	name := strings.TrimSuffix(filepath.Base(file), ".gguf")
+
+	spec := containerSpec{
+		Name:  containerName(),
+		Image: LlamaCppImage,
+		Cmd: []string{
+			"--model", "/models/" + filepath.ToSlash(file),
+			"--alias", name,
+			"--host", "0.0.0.0",
+			"--port", fmt.Sprint(LlamaCppPort),
+		},
+		Labels:      map[string]string{BackendLabel: "llama.cpp", ModelLabel: name, ModelsDirLabel: dir},
+		Mounts:      []string{dir + ":/models:ro"},
+		Ports:       map[int]int{ollamaPort(): LlamaCppPort},
+		Healthcheck: llamaCppHealthcheck,
+		HostConfig: container.HostConfig{
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+		},
+	}
+
+	// The GPU builds only help if every layer is offloaded to the GPU
+	switch {
+	case cpuOnly.value:
+	case gpusFlag != "" || detectGPUs():
+		gpus := configOr(gpusFlag, "all")
+		if err := applyGPUs(&spec.HostConfig, gpus); err != nil {
+			return fmt.Errorf("invalid --gpus value %q: %w", gpus, err)
+		}
+		spec.Image += "-cuda"
+		spec.Labels[GPUsLabel] = gpus
+		spec.Cmd = append(spec.Cmd, "--n-gpu-layers", "999")
+	case runtimeFlag == "rocm" || detectROCm():
+		_ = applyROCm(&spec.HostConfig, "rocm")
+		spec.Image += "-rocm"
+		spec.Labels[RuntimeLabel] = "rocm"
+		spec.Cmd = append(spec.Cmd, "--n-gpu-layers", "999")
+	}
+	if err := applyResourceLimits(&spec); err != nil {
+		return err
+	}
+	if err := applyRunnerEnv(&spec); err != nil {
+		return err
+	}
+
+	if err := createRunner(ctx, spec); err != nil {
+		_ = docker.Remove(containerName())
+		return classify(fmt.Errorf("failed to start llama.cpp container: %w", err), errRunnerNotRunning)
+	}
+
+	if dockerCLI.Err().IsTerminal() {
+		stop := startSpinner(dockerCLI.Err(), "Waiting for llama.cpp to load "+name)
+		defer stop()
+	}
+	return waitForAPI(ctx, llamaCppBackend{}.ReadyPath(), readyTimeout())
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// LocalAIImage is the all-in-one LocalAI image, which comes with models
+	// for chat, embeddings, speech and images already configured
+	LocalAIImage = "localai/localai:latest-aio-cpu"
+	// LocalAIGPUImage is the all-in-one LocalAI image for NVIDIA GPUs
+	LocalAIGPUImage = "localai/localai:latest-aio-gpu-nvidia-cuda-12"
+	// LocalAIPlainImage is the LocalAI image without bundled models, for a
+	// models directory of your own
+	LocalAIPlainImage = "localai/localai:latest"
+	// LocalAIPlainGPUImage is the LocalAI image without bundled models for
+	// NVIDIA GPUs
+	LocalAIPlainGPUImage = "localai/localai:latest-gpu-nvidia-cuda-12"
+	// LocalAIPort is the port LocalAI listens on in the container
+	LocalAIPort = 8080
+)
+
+// localAIBackend serves models with LocalAI, whose OpenAI-compatible API
+// also covers audio, images and embeddings
+type localAIBackend struct{}
+
+func (localAIBackend) Name() string      { return "localai" }
+func (localAIBackend) ReadyPath() string { return "/readyz" }
+
+func (localAIBackend) List(ctx context.Context) ([]localModel, error) {
+	return openAIModels(ctx)
+}
+
+// Pull installs a model from LocalAI's gallery, e.g. localai@phi-2. LocalAI
+// downloads it in the background, so the job is polled until it's done
+func (localAIBackend) Pull(ctx context.Context, name string, fn func(pullResponse)) error {
+	body, err := json.Marshal(map[string]string{"id": name})
+	if err != nil {
+		return err
+	}
+	resp, err := apiPost(ctx, "/models/apply", body)
+	if err != nil {
+		return fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return openAIError(resp)
+	}
+	var job struct {
+		UUID string `json:"uuid"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&job); err != nil {
+		return fmt.Errorf("failed to decode gallery job: %w", err)
+	}
+
+	for {
+		status, err := localAIJob(ctx, job.UUID)
+		if err != nil {
+			return err
+		}
+		switch {
+		case status.Error != nil:
+			return fmt.Errorf("failed to install %s: %v", name, status.Error)
+		case status.Processed:
+			fn(pullResponse{Status: "success"})
+			return nil
+		case status.Progress > 0:
+			fn(pullResponse{Status: fmt.Sprintf("downloading %d%%", int(status.Progress)/10*10)})
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// localAIJobStatus is the state of a LocalAI gallery job
+type localAIJobStatus struct {
+	Processed bool    `json:"processed"`
+	Progress  float64 `json:"progress"`
+	Error     any     `json:"error"`
+}
+
+// localAIJob returns the state of a gallery job
+func localAIJob(ctx context.Context, uuid string) (localAIJobStatus, error) {
+	var status localAIJobStatus
+	resp, err := apiGet(ctx, "/models/jobs/"+uuid)
+	if err != nil {
+		return status, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return status, openAIError(resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return status, fmt.Errorf("failed to decode gallery job: %w", err)
+	}
+	return status, nil
+}
+
+func (localAIBackend) Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	return openAIGenerate(ctx, req, w, stallTimeout)
+}
+
+func (localAIBackend) Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	return openAIChat(ctx, req.Model, req.Messages, req.Options, nil, w, 0)
+}
+
+func (localAIBackend) Embed(ctx context.Context, model string, inputs []string, _ any) ([][]float64, error) {
+	return openAIEmbed(ctx, model, inputs)
+}
+
+// localAIHealthcheck has Docker check that LocalAI answers, using the curl
+// its image ships
+var localAIHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "curl", "-fs", "http://localhost:8080/readyz"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 10 * time.Minute,
+	Retries:     3,
+}
+
+// localAIVolume is the volume LocalAI keeps its models and their configs in,
+// unless it's given a directory of its own
+func localAIVolume() string {
+	return volumeName() + "-localai"
+}
+
+// createLocalAIRunner creates a runner that serves models with LocalAI on
+// the runner's port, then waits for it to be ready. Without a models
+// directory it's the all-in-one image, whose bundled models are kept in a
+// volume. With one, the directory's model configs and weights are mounted
+// for LocalAI to serve, and gallery installs are written there too. An
+// NVIDIA GPU is used when one is available
+func createLocalAIRunner(ctx context.Context, dir string) error {
+	spec := containerSpec{
+		Name:        containerName(),
+		Image:       LocalAIImage,
+		Env:         append(huggingFaceEnv(), "MODELS_PATH=/models"),
+		Labels:      map[string]string{BackendLabel: "localai"},
+		Mounts:      []string{localAIVolume() + ":/models"},
+		Ports:       map[int]int{ollamaPort(): LocalAIPort},
+		Healthcheck: localAIHealthcheck,
+		HostConfig: container.HostConfig{
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+		},
+	}
+	if dir != "" {
+		dir, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		spec.Image = LocalAIPlainImage
+		spec.Mounts = []string{dir + ":/models"}
+		spec.Labels[ModelsDirLabel] = dir
+	}
+
+	if !cpuOnly.value && (gpusFlag != "" || detectGPUs()) {
+		gpus := configOr(gpusFlag, "all")
+		if err := applyGPUs(&spec.HostConfig, gpus); err != nil {
+			return fmt.Errorf("invalid --gpus value %q: %w", gpus, err)
+		}
+		spec.Labels[GPUsLabel] = gpus
+		if dir == "" {
+			spec.Image = LocalAIGPUImage
+		} else {
+			spec.Image = LocalAIPlainGPUImage
+		}
+	}
+	if err := applyResourceLimits(&spec); err != nil {
+		return err
+	}
+	if err := applyRunnerEnv(&spec); err != nil {
+		return err
+	}
+
+	if dir == "" {
+		if err := docker.CreateVolume(localAIVolume()); err != nil {
+			return fmt.Errorf("failed to create volume %s: %w", localAIVolume(), err)
+		}
+	}
+	if err := createRunner(ctx, spec); err != nil {
+		_ = docker.Remove(containerName())
+		return classify(fmt.Errorf("failed to start LocalAI container: %w", err), errRunnerNotRunning)
+	}
+
+	if dockerCLI.Err().IsTerminal() {
+		stop := startSpinner(dockerCLI.Err(), "Waiting for LocalAI to be ready")
+		defer stop()
+	}
+	return waitForAPI(ctx, localAIBackend{}.ReadyPath(), max(readyTimeout(), HubReadyTimeout))
+}
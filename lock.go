@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+)
+
+// lockRunner takes a lock shared by every docker model process working on
+// this runner, so concurrent commands don't race to remove and create its
+// container. Runners on different engines are locked separately. It waits
+// for the lock until ctx is cancelled. The lock is held until the returned
+// function is called or the process exits
+func lockRunner(ctx context.Context) (func(), error) {
+	_, host := dockerEndpoint()
+	engine := sha256.Sum256([]byte(host))
+	path := filepath.Join(config.Dir(), "mocker", fmt.Sprintf("runner-%s-%x.lock", containerName(), engine[:4]))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create lock directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open runner lock: %w", err)
+	}
+
+	// The lock is usually only held for a moment, so only say what's
+	// happening if it's taking a while
+	start, told := time.Now(), false
+	for {
+		locked, err := tryLockFile(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("failed to lock %s: %w", path, err)
+		}
+		if locked {
+			break
+		}
+		if !told && time.Since(start) > time.Second {
+			fmt.Fprintln(os.Stderr, "Waiting for another docker model command to start the runner...")
+			told = true
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+
+	return func() {
+		_ = unlockFile(f)
+		f.Close()
+	}, nil
+}
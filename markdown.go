@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// ANSI styles used when rendering Markdown
+const (
+	ansiReset     = "\x1b[0m"
+	ansiBold      = "\x1b[1m"
+	ansiDim       = "\x1b[2m"
+	ansiItalic    = "\x1b[3m"
+	ansiUnderline = "\x1b[4m"
+	ansiGreen     = "\x1b[32m"
+	ansiYellow    = "\x1b[33m"
+	ansiBlue      = "\x1b[34m"
+	ansiMagenta   = "\x1b[35m"
+	ansiCyan      = "\x1b[36m"
+)
+
+var (
+	mdHeadingRegex = regexp.MustCompile(`^(#{1,6})\s+(.*)$`)
+	mdListRegex    = regexp.MustCompile(`^(\s*)([-*+]|\d+[.)])\s+(.*)$`)
+	mdRuleRegex    = regexp.MustCompile(`^\s*((-\s*){3,}|(\*\s*){3,}|(_\s*){3,})$`)
+	mdFenceRegex   = regexp.MustCompile("^\\s*(```|~~~)\\s*([\\w+#-]*)")
+
+	mdCodeRegex   = regexp.MustCompile("`([^`]+)`")
+	mdBoldRegex   = regexp.MustCompile(`\*\*([^*]+)\*\*|__([^_]+)__`)
+	mdItalicRegex = regexp.MustCompile(`(^|[^*\w])\*([^*\s][^*]*)\*|(^|\W)_([^_\s][^_]*)_`)
+	mdLinkRegex   = regexp.MustCompile(`\[([^\]]+)\]\(([^)\s]+)\)`)
+
+	codeTokenRegex = regexp.MustCompile(`"(?:[^"\\]|\\.)*"|'(?:[^'\\]|\\.)*'|\b\d+(?:\.\d+)?\b|\b[A-Za-z_]\w*\b`)
+)
+
+// codeKeywords are highlighted in code blocks. They're shared across
+// languages, which is close enough for reading answers in a terminal
+var codeKeywords = map[string]bool{}
+
+func init() {
+	for _, k := range strings.Fields(`func function def fn class struct interface impl trait enum type
+		return if else elif for while do switch case default break continue goto match
+		import from package use mod export module require include
+		var let const mut static pub public private protected final void new delete
+		try catch except finally raise throw throws async await yield defer go select chan range
+		in of is as not and or with lambda self this super
+		nil null None true false True False undefined echo fi then done esac`) {
+		codeKeywords[k] = true
+	}
+}
+
+// hashCommentLanguages use # for comments
+var hashCommentLanguages = map[string]bool{
+	"python": true, "py": true, "sh": true, "bash": true, "shell": true, "zsh": true, "console": true,
+	"ruby": true, "rb": true, "yaml": true, "yml": true, "toml": true, "dockerfile": true,
+	"r": true, "perl": true, "make": true, "makefile": true, "ini": true, "conf": true,
+}
+
+// markdownWriter renders streamed Markdown for a terminal. Formatting can
+// depend on a whole line, so output appears a line at a time
+type markdownWriter struct {
+	out    io.Writer
+	buf    []byte
+	inCode bool
+	lang   string
+}
+
+func newMarkdownWriter(out io.Writer) *markdownWriter {
+	return &markdownWriter{out: out}
+}
+
+func (m *markdownWriter) Write(p []byte) (int, error) {
+	m.buf = append(m.buf, p...)
+	for {
+		i := bytes.IndexByte(m.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		if _, err := io.WriteString(m.out, m.renderLine(string(m.buf[:i]))+"\n"); err != nil {
+			return 0, err
+		}
+		m.buf = m.buf[i+1:]
+	}
+}
+
+// Flush renders a final line that wasn't terminated
+func (m *markdownWriter) Flush() error {
+	if len(m.buf) == 0 {
+		return nil
+	}
+	line := m.renderLine(string(m.buf))
+	m.buf = nil
+	_, err := io.WriteString(m.out, line)
+	return err
+}
+
+// renderLine formats a single line of Markdown
+func (m *markdownWriter) renderLine(line string) string {
+	if match := mdFenceRegex.FindStringSubmatch(line); match != nil {
+		m.inCode = !m.inCode
+		m.lang = strings.ToLower(match[2])
+		if m.inCode && m.lang != "" {
+			return ansiDim + "  " + m.lang + ansiReset
+		}
+		return ""
+	}
+	if m.inCode {
+		return "  " + highlightCode(line, m.lang)
+	}
+
+	if match := mdHeadingRegex.FindStringSubmatch(line); match != nil {
+		style := ansiBold + ansiCyan
+		if len(match[1]) == 1 {
+			style += ansiUnderline
+		}
+		return style + renderInline(match[2], style) + ansiReset
+	}
+	if mdRuleRegex.MatchString(line) {
+		return ansiDim + strings.Repeat("─", 40) + ansiReset
+	}
+	if quote, ok := strings.CutPrefix(strings.TrimLeft(line, " "), ">"); ok {
+		return ansiDim + "│ " + ansiReset + ansiItalic + renderInline(strings.TrimPrefix(quote, " "), ansiItalic) + ansiReset
+	}
+	if match := mdListRegex.FindStringSubmatch(line); match != nil {
+		bullet := match[2]
+		if !strings.ContainsAny(bullet[len(bullet)-1:], ".)") {
+			bullet = "•"
+		}
+		return match[1] + ansiYellow + bullet + ansiReset + " " + renderInline(match[3], "")
+	}
+	return renderInline(line, "")
+}
+
+// renderInline formats code spans, emphasis and links within a line. base
+// is the style surrounding the line, restored after each span
+func renderInline(text, base string) string {
+	restore := ansiReset + base
+
+	// Code spans are set aside first so their contents aren't formatted
+	var spans []string
+	text = mdCodeRegex.ReplaceAllStringFunc(text, func(s string) string {
+		spans = append(spans, ansiMagenta+s[1:len(s)-1]+restore)
+		return "\x00"
+	})
+
+	text = mdLinkRegex.ReplaceAllString(text, ansiUnderline+"$1"+restore+" "+ansiDim+"($2)"+restore)
+	text = mdBoldRegex.ReplaceAllString(text, ansiBold+"$1$2"+restore)
+	text = mdItalicRegex.ReplaceAllString(text, "$1$3"+ansiItalic+"$2$4"+restore)
+
+	for _, span := range spans {
+		text = strings.Replace(text, "\x00", span, 1)
+	}
+	return text
+}
+
+// highlightCode colors keywords, strings, numbers and comments in a line
+// of code
+func highlightCode(line, lang string) string {
+	code, comment := line, ""
+	markers := []string{"//"}
+	if hashCommentLanguages[lang] {
+		markers = []string{"#"}
+	} else if lang == "sql" || lang == "lua" || lang == "haskell" {
+		markers = []string{"--"}
+	}
+	for _, marker := range markers {
+		if i := commentStart(line, marker); i >= 0 {
+			code, comment = line[:i], line[i:]
+			break
+		}
+	}
+
+	code = codeTokenRegex.ReplaceAllStringFunc(code, func(token string) string {
+		switch {
+		case token[0] == '"' || token[0] == '\'':
+			return ansiGreen + token + ansiReset
+		case token[0] >= '0' && token[0] <= '9':
+			return ansiYellow + token + ansiReset
+		case codeKeywords[token]:
+			return ansiBlue + token + ansiReset
+		}
+		return token
+	})
+	if comment != "" {
+		comment = ansiDim + comment + ansiReset
+	}
+	return code + comment
+}
+
+// commentStart returns where a comment starts in a line of code, ignoring
+// markers inside string literals, or -1 if there's no comment
+func commentStart(line, marker string) int {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0 && c == '\\':
+			i++
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case strings.HasPrefix(line[i:], marker):
+			return i
+		}
+	}
+	return -1
+}
@@ -2,35 +2,138 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
+	"net"
+	"net/http"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path/filepath"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/docker/cli/cli-plugins/metadata"
 	"github.com/docker/cli/cli-plugins/plugin"
 	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/opts"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/errdefs"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 const (
-	OllamaContainerName = "mocker-model-runner"
-	OllamaImage         = "ollama/ollama:latest"
-	AppVersion          = "0.1.0"
+	OllamaContainerName    = "mocker-model-runner"
+	OllamaRepository       = "ollama/ollama"
+	OllamaImage            = OllamaRepository + ":latest"
+	OllamaROCmImage        = OllamaRepository + ":rocm"
+	OllamaVolume           = "ollama"
+	AppVersion             = "0.1.0"
+	CPUOnlyLabel           = "mocker.cpu-only"
+	GPUsLabel              = "mocker.gpus"
+	MemoryLabel            = "mocker.memory"
+	CPUsLabel              = "mocker.cpus"
+	ShmSizeLabel           = "mocker.shm-size"
+	EnvLabel               = "mocker.env"
+	RuntimeLabel           = "mocker.runtime"
+	RestartLabel           = "mocker.restart"
+	RunnerLabel            = "mocker.runner"
+	DefaultRunnerName      = "default"
+	DefaultRestartPolicy   = "unless-stopped"
+	DefaultOllamaPort      = 11434
+	DefaultReadyTimeout    = 30 * time.Second
+	DefaultPullParallelism = 3
+	DefaultRetries         = 5
+	MaxRetryDelay          = 30 * time.Second
+)
+
+// cpuOnlyEnv hides every GPU from Ollama so it runs on the CPU without
+// probing for (and warning about) missing devices
+var cpuOnlyEnv = []string{
+	"CUDA_VISIBLE_DEVICES=-1",
+	"ROCR_VISIBLE_DEVICES=-1",
+	"HIP_VISIBLE_DEVICES=-1",
+}
+
+// optionalBool is a boolean flag value that remembers whether it was set
+type optionalBool struct {
+	value bool
+	set   bool
+}
+
+func (b *optionalBool) String() string { return strconv.FormatBool(b.value) }
+func (b *optionalBool) Type() string   { return "bool" }
+
+func (b *optionalBool) Set(s string) error {
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		return err
+	}
+	b.value, b.set = v, true
+	return nil
+}
+
+// Global flags shared by all subcommands
+var (
+	noBanner      bool
+	cpuOnly       optionalBool
+	gpusFlag      string
+	memoryFlag    string
+	cpusFlag      string
+	shmSizeFlag   string
+	envFlag       []string
+	runtimeFlag   string
+	restartFlag   string
+	ollamaHost    string
+	forceRecreate bool
+	portFlag      int
+	runnerFlag    string
+
+	readyTimeoutFlag time.Duration
+	retriesFlag      optionalInt
 )
 
 func main() {
 	plugin.Run(func(dockerCli command.Cli) *cobra.Command {
+		dockerCLI = dockerCli
+
 		cmd := &cobra.Command{
 			Use:   "model",
 			Short: "Run and manage AI models",
 			Long:  "Run and manage AI models using open-source tools",
 		}
 
+		cmd.PersistentFlags().BoolVar(&noBanner, "no-banner", false, "Suppress the tongue-in-cheek banner messages")
+		cmd.PersistentFlags().StringVar(&ollamaHost, "ollama-host", "", "Use the Ollama server at this address instead of the runner container (e.g. localhost:11434, or OLLAMA_HOST)")
+		cmd.PersistentFlags().IntVar(&portFlag, "port", 0, "Host port to expose the model runner on (default 11434, or MOCKER_PORT)")
+		cmd.PersistentFlags().StringVar(&runnerFlag, "runner", "", "Name of a runner created with runner create to use instead of the default one (or MOCKER_RUNNER)")
+		cmd.PersistentFlags().Var(&retriesFlag, "retries", "How many times to retry a transient Docker or Ollama failure (default 5, or MOCKER_RETRIES)")
+		cmd.PersistentFlags().DurationVar(&readyTimeoutFlag, "ready-timeout", 0, "How long to wait for a freshly started runner to become ready (default 30s, or MOCKER_READY_TIMEOUT)")
+		cmd.PersistentFlags().StringVar(&gpusFlag, "gpus", "", "GPU devices to give the runner (e.g. all, or 0,1); requires the NVIDIA container toolkit")
+		cmd.PersistentFlags().StringVar(&memoryFlag, "memory", "", "Memory limit for the runner container (e.g. 8g, or MOCKER_MEMORY)")
+		cmd.PersistentFlags().StringVar(&cpusFlag, "cpus", "", "Number of CPUs the runner container may use (e.g. 4, or MOCKER_CPUS)")
+		cmd.PersistentFlags().StringArrayVar(&envFlag, "env", nil, "Set an environment variable in the runner, as KEY=VALUE or KEY to pass on this shell's value; repeatable")
+		cmd.PersistentFlags().StringVar(&shmSizeFlag, "shm-size", "", "Size of /dev/shm in the runner container (e.g. 2g, or MOCKER_SHM_SIZE)")
+		cmd.PersistentFlags().StringVar(&runtimeFlag, "runtime", "", "Runtime for the runner: rocm for AMD GPUs (detected automatically when possible), or host to run Ollama natively instead of in a container")
+		cmd.PersistentFlags().StringVar(&restartFlag, "restart", "", "Restart policy for the runner: no, always, unless-stopped or on-failure[:max-retries] (default unless-stopped, or MOCKER_RESTART)")
+		cmd.PersistentFlags().BoolVar(&forceRecreate, "force-recreate", false, "Recreate the runner if it was created with different --gpus, --memory, --cpus, --shm-size, --restart or --env")
+		cmd.PersistentFlags().Var(&cpuOnly, "cpu-only", "Run models on the CPU only, recreating the runner if its mode differs")
+		cmd.PersistentFlags().Lookup("cpu-only").NoOptDefVal = "true"
+
 		// Add subcommands
 		cmd.AddCommand(
 			newStatusCommand(dockerCli),
@@ -40,7 +143,37 @@ func main() {
 			newPullCommand(dockerCli),
 			newRmCommand(dockerCli),
 			newRunCommand(dockerCli),
+			newStopCommand(dockerCli),
+			newWhichCommand(dockerCli),
+			newLogsCommand(dockerCli),
+			newShowCommand(dockerCli),
+			newCreateCommand(dockerCli),
+			newServeCommand(dockerCli),
+			newPsCommand(dockerCli),
+			newConfigCommand(dockerCli),
+			newInspectCommand(dockerCli),
+			newStatsCommand(dockerCli),
+			newRunnerCommand(dockerCli),
+			newPruneCommand(dockerCli),
+			newDfCommand(dockerCli),
+			newPushCommand(dockerCli),
+			newExportOCICommand(dockerCli),
+			newExportCommand(dockerCli),
+			newImportCommand(dockerCli),
+			newBuildCommand(dockerCli),
+			newCpCommand(dockerCli),
+			newSearchCommand(dockerCli),
+			newTagsCommand(dockerCli),
+			newOutdatedCommand(dockerCli),
+			newUpdateCommand(dockerCli),
+			newBatchCommand(dockerCli),
+			newEmbedCommand(dockerCli),
+			newChatCommand(dockerCli),
+			newConversationsCommand(dockerCli),
+			newK8sCommand(dockerCli),
+			newComposeCommand(dockerCli),
 		)
+		reportErrors(cmd)
 
 		return cmd
 	},
@@ -53,363 +186,4558 @@ func main() {
 		})
 }
 
-// isOllamaRunning checks if the Ollama container is running
-func isOllamaRunning() bool {
-	cmd := exec.Command("docker", "ps", "--format", "{{.Names}}")
-	output, err := cmd.Output()
-	if err != nil {
-		return false
+// commandContext returns the context a command's work runs under. It's
+// cancelled by Ctrl+C or SIGTERM, as well as when the docker CLI stops the
+// plugin. Once it's cancelled the signals get their default behaviour back,
+// so a second Ctrl+C exits at once if something doesn't stop promptly
+func commandContext(cmd *cobra.Command) (context.Context, context.CancelFunc) {
+	ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx, stop
+}
+
+// envOrDefault returns the value of an environment variable, or def if unset
+func envOrDefault(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
 	}
+	return def
+}
 
-	return strings.Contains(string(output), OllamaContainerName)
+// containerName returns the name of the runner container (MOCKER_CONTAINER_NAME)
+func containerName() string {
+	return runnerContainerName(runnerName())
 }
 
-// ensureOllamaRunning ensures the Ollama container is running
-func ensureOllamaRunning() error {
-	if !isOllamaRunning() {
-		fmt.Println("Starting Mocker Model Runner...")
-
-		// First try to remove any existing container with this name
-		removeCmd := exec.Command("docker", "rm", "-f", OllamaContainerName)
-		_ = removeCmd.Run() // Ignore errors if it doesn't exist
-
-		// Create the volume if it doesn't exist
-		volumeCmd := exec.Command("docker", "volume", "create", "ollama")
-		_ = volumeCmd.Run() // Ignore errors if it already exists
-
-		// Then run the container
-		cmd := exec.Command(
-			"docker", "run", "-d",
-			"--name", OllamaContainerName,
-			"-v", "ollama:/root/.ollama",
-			"-p", "11434:11434",
-			"--pull", "always", // Ensure image is pulled
-			OllamaImage,
-		)
+// runnerContainerName returns the container of the runner called runner,
+// "" or "default" being the default runner
+func runnerContainerName(runner string) string {
+	name := envOrDefault("MOCKER_CONTAINER_NAME", configOr(settings().ContainerName, OllamaContainerName))
+	if runner != "" && runner != DefaultRunnerName {
+		name += "-" + runner
+	}
+	return name
+}
 
-		if output, err := cmd.CombinedOutput(); err != nil {
-			return fmt.Errorf("failed to start Ollama container: %w\nOutput: %s", err, string(output))
-		}
+// runnerName returns the name of the runner to use, from --runner, then
+// MOCKER_RUNNER, or "" for the default runner
+func runnerName() string {
+	name := runnerFlag
+	if name == "" {
+		name = os.Getenv("MOCKER_RUNNER")
+	}
+	if name == DefaultRunnerName {
+		return ""
+	}
+	return name
+}
 
-		// Wait a moment for Ollama to initialize
-		time.Sleep(2 * time.Second)
+// runnerNameRegex matches what can follow the default container name to
+// make a valid container name
+var runnerNameRegex = regexp.MustCompile(`^[a-zA-Z0-9][a-zA-Z0-9_.-]*$`)
+
+// validateRunnerName rejects runner names that can't be part of a container
+// name
+func validateRunnerName(name string) error {
+	if !runnerNameRegex.MatchString(name) {
+		return fmt.Errorf("invalid runner name %q: must start with a letter or digit, followed by letters, digits or . _ -", name)
 	}
 	return nil
 }
 
-// runInOllama executes a command in the Ollama container
-func runInOllama(args ...string) (string, error) {
-	cmdArgs := append([]string{"exec", OllamaContainerName}, args...)
-	cmd := exec.Command("docker", cmdArgs...)
+// creatingRunner is set by `runner create`, the one command that may create
+// a named runner that doesn't exist yet
+var creatingRunner bool
+
+// namedRunnerPort caches the port a named runner is published on, once known
+var namedRunnerPort int
+
+// runnerPort returns the host port a named runner's API is published on,
+// or 0 while that isn't known, as before it's created
+func runnerPort() int {
+	if namedRunnerPort == 0 {
+		if state, ok, err := docker.Inspect(containerName()); err == nil && ok {
+			namedRunnerPort = state.HostPort
+		}
+	}
+	return namedRunnerPort
+}
 
-	output, err := cmd.CombinedOutput()
+// freeHostPort returns a port nothing on this machine is listening on, for
+// a named runner created without --port. For a remote daemon it returns 0,
+// leaving the daemon to pick one
+func freeHostPort() int {
+	if runnerHostname() != "localhost" {
+		return 0
+	}
+	l, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
-		return "", fmt.Errorf("command failed: %w\nOutput: %s", err, string(output))
+		return 0
 	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
 
-	return string(output), nil
+// imageName returns the Ollama image the runner is created from (MOCKER_IMAGE)
+func imageName() string {
+	return imageFor(runtimeFlag == "rocm")
 }
 
-// runInOllamaInteractive executes a command in the Ollama container with interactive TTY
-func runInOllamaInteractive(args ...string) error {
-	cmdArgs := append([]string{"exec", "-it", OllamaContainerName}, args...)
-	cmd := exec.Command("docker", cmdArgs...)
+// imageFor returns the Ollama image to use, picking the ROCm build for AMD
+// GPUs unless MOCKER_IMAGE or the configured image overrides it
+func imageFor(rocm bool) string {
+	if image := envOrDefault("MOCKER_IMAGE", settings().Image); image != "" {
+		return image
+	}
+	image := OllamaImage
+	if rocm {
+		image = OllamaROCmImage
+	}
+	if tag := settings().Tag; tag != "" {
+		image = OllamaRepository + ":" + tag
+		if rocm {
+			image += "-rocm"
+		}
+	}
+	// Podman won't guess a registry for short names when it can't prompt
+	if isPodman() {
+		return "docker.io/" + image
+	}
+	return image
+}
 
-	// Connect standard input, output, and error
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+// volumeName returns the volume models are stored in (MOCKER_VOLUME)
+func volumeName() string {
+	return envOrDefault("MOCKER_VOLUME", configOr(settings().Volume, OllamaVolume))
+}
 
-	return cmd.Run()
+// configOr returns a configured value, or def if it isn't set
+func configOr(value, def string) string {
+	if value != "" {
+		return value
+	}
+	return def
 }
 
-// Status command
-func newStatusCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "status",
-		Short: "Check if the model runner is running",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if isOllamaRunning() {
-				_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is active")
-			} else {
-				_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running")
-			}
-			return nil
-		},
+// ollamaPort resolves the host port the runner's API is published on, from
+// --port, then MOCKER_PORT, then the config file, then the Ollama default.
+// A named runner has its own port, so only --port overrides it
+func ollamaPort() int {
+	if portFlag != 0 {
+		return portFlag
+	}
+	if runnerName() != "" {
+		return runnerPort()
 	}
+	if p, err := strconv.Atoi(os.Getenv("MOCKER_PORT")); err == nil && p > 0 {
+		return p
+	}
+	if p := settings().Port; p > 0 {
+		return p
+	}
+	return DefaultOllamaPort
 }
 
-// Help command - displays custom help, different from the auto-generated cobra help
-func newHelpCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "help",
-		Short: "Show the custom help",
-		Run: func(cmd *cobra.Command, args []string) {
-			_, _ = fmt.Fprintln(dockerCli.Out(), "Usage:  docker model COMMAND")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "Commands:")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  list        List models available locally")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  pull        Download a model from Docker Hub")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  rm          Remove a downloaded model")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  run         Run a model interactively or with a prompt")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  status      Check if the model runner is running")
-			_, _ = fmt.Fprintln(dockerCli.Out(), "  version     Show the current version")
-		},
+// externalOllama returns the base URL of an Ollama server to use instead of
+// the runner container, from --ollama-host, then OLLAMA_HOST, then the config
+// file, or "" when Mocker manages the runner as usual
+func externalOllama() string {
+	host := ollamaHost
+	if host == "" {
+		host = envOrDefault("OLLAMA_HOST", settings().OllamaHost)
 	}
+	if host == "" {
+		return ""
+	}
+	return ollamaHostURL(host)
 }
 
-// Version command
-func newVersionCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "version",
-		Short: "Show the current version",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := ensureOllamaRunning(); err != nil {
-				return err
-			}
+// ollamaHostURL turns an address in any form OLLAMA_HOST accepts, such as
+// "gpu-box", "10.0.0.5:11500" or "https://ollama.example.com/ollama", into a
+// base URL. Ollama's default port is assumed, and the addresses a server
+// listens on everywhere are taken to mean this machine
+func ollamaHostURL(host string) string {
+	scheme := "http"
+	if s, rest, ok := strings.Cut(host, "://"); ok {
+		scheme, host = s, rest
+	}
+	host, path, _ := strings.Cut(host, "/")
 
-			version, err := runInOllama("ollama", "--version")
-			if err != nil {
-				return err
-			}
+	hostname, port, err := net.SplitHostPort(host)
+	if err != nil {
+		hostname, port = strings.Trim(host, "[]"), ""
+	}
+	switch {
+	case port != "":
+	case scheme == "https":
+		port = "443"
+	default:
+		port = strconv.Itoa(DefaultOllamaPort)
+	}
+	switch hostname {
+	case "", "0.0.0.0", "::":
+		hostname = "localhost"
+	}
 
-			_, _ = fmt.Fprintf(dockerCli.Out(), "Mocker version: %s\nOllama version: %s", AppVersion, version)
-			return nil
-		},
+	u := scheme + "://" + net.JoinHostPort(hostname, port)
+	if path = strings.Trim(path, "/"); path != "" {
+		u += "/" + path
 	}
+	return u
 }
 
-// getModelDetails fetches architecture and quantization details for a model
-func getModelDetails(modelName string) (string, string, error) {
-	output, err := runInOllama("ollama", "show", modelName)
-	if err != nil {
-		return "unknown", "unknown", err
+// requireManagedRunner fails commands that start or stop the runner when an
+// external Ollama is used instead, as it's someone else's to manage
+func requireManagedRunner(cmd *cobra.Command) error {
+	if url := externalOllama(); url != "" {
+		return fmt.Errorf("`%s` works on the Mocker Model Runner container, so it can't be used with the external Ollama at %s", cmd.CommandPath(), url)
 	}
+	return nil
+}
 
-	// Use regex to find architecture and quantization
-	archRegex := regexp.MustCompile(`architecture\s+(\S+)`)
-	quantRegex := regexp.MustCompile(`quantization\s+(\S+)`)
+// requireRunnerContainer fails commands that work on the runner container
+// itself when an external or host Ollama is used instead, as there is no
+// container
+func requireRunnerContainer(cmd *cobra.Command) error {
+	if err := requireManagedRunner(cmd); err != nil {
+		return err
+	}
+	if hostRuntime() {
+		return fmt.Errorf("`%s` works on the Mocker Model Runner container, so it can't be used with the host runtime; Ollama's log is at %s", cmd.CommandPath(), hostLogPath())
+	}
+	return nil
+}
 
-	archMatch := archRegex.FindStringSubmatch(output)
-	quantMatch := quantRegex.FindStringSubmatch(output)
+// readyTimeout resolves how long to wait for the runner's API to come up,
+// from --ready-timeout, then MOCKER_READY_TIMEOUT, then the config file, then
+// the default
+func readyTimeout() time.Duration {
+	if readyTimeoutFlag > 0 {
+		return readyTimeoutFlag
+	}
+	if d, err := time.ParseDuration(os.Getenv("MOCKER_READY_TIMEOUT")); err == nil && d > 0 {
+		return d
+	}
+	if d, err := time.ParseDuration(settings().ReadyTimeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultReadyTimeout
+}
 
-	arch := "unknown"
-	quant := "unknown"
+// showBanners reports whether the jokey banner messages should be printed.
+// They are only shown on an interactive terminal unless --no-banner is set
+func showBanners(dockerCli command.Cli) bool {
+	return !noBanner && dockerCli.Out().IsTerminal()
+}
 
-	if len(archMatch) > 1 {
-		arch = archMatch[1]
+// quip formats an aside for a status message, or returns nothing when
+// banners are suppressed
+func quip(dockerCli command.Cli, joke string) string {
+	if !showBanners(dockerCli) {
+		return ""
 	}
+	return " (" + joke + ")"
+}
 
-	if len(quantMatch) > 1 {
-		quant = quantMatch[1]
+// isOllamaRunning checks if the Ollama container is running
+func isOllamaRunning() bool {
+	// An external Ollama is assumed to be up; using it reports if it isn't
+	if externalOllama() != "" {
+		return true
 	}
-
-	return arch, quant, nil
+	if hostRuntime() {
+		return hostOllamaRunning()
+	}
+	state, ok, err := docker.Inspect(containerName())
+	return err == nil && ok && state.Running
 }
 
-// List command
-func newListCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "list",
-		Short: "List models available locally",
-		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := ensureOllamaRunning(); err != nil {
-				return err
-			}
+// containerLabel returns the value of a label on the Ollama container, or an
+// empty string if the container or label doesn't exist
+func containerLabel(label string) string {
+	state, ok, err := docker.Inspect(containerName())
+	if err != nil || !ok {
+		return ""
+	}
+	return state.Labels[label]
+}
 
-			listOutput, err := runInOllama("ollama", "list")
-			if err != nil {
-				return err
-			}
+// runnerEnv returns the environment variables requested for the runner: the
+// env setting, then --env, a later value for a variable replacing an
+// earlier one. A bare KEY takes its value from this shell, as with
+// `docker run --env`
+func runnerEnv() ([]string, error) {
+	values := map[string]string{}
+	for _, variable := range append(slices.Clone(settings().Env), envFlag...) {
+		variable, err := opts.ValidateEnv(variable)
+		if err != nil {
+			return nil, err
+		}
+		name, value, ok := strings.Cut(variable, "=")
+		if !ok {
+			// Not set in this shell either, so there's nothing to pass on
+			continue
+		}
+		values[name] = value
+	}
 
-			// Process the output
-			_, _ = fmt.Fprintln(dockerCli.Out(), "+MODEL       PARAMETERS  QUANTIZATION    ARCHITECTURE  MODEL ID      CREATED     SIZE")
+	env := make([]string, 0, len(values))
+	for _, name := range sortedKeys(values) {
+		env = append(env, name+"="+values[name])
+	}
+	return env, nil
+}
 
-			scanner := bufio.NewScanner(strings.NewReader(listOutput))
-			// Skip header line
-			if scanner.Scan() {
-				_ = scanner.Text()
-			}
+// runnerEnvLabel records the requested environment on the runner as a JSON
+// list, or "" if there is none, so a change can be detected
+func runnerEnvLabel(env []string) string {
+	if len(env) == 0 {
+		return ""
+	}
+	data, _ := json.Marshal(env)
+	return string(data)
+}
 
-			// Process each line
-			for scanner.Scan() {
-				line := scanner.Text()
-				fields := strings.Fields(line)
-				if len(fields) < 5 {
-					continue
-				}
+// labelEnv reads back the environment recorded by runnerEnvLabel
+func labelEnv(label string) []string {
+	var env []string
+	if label != "" {
+		_ = json.Unmarshal([]byte(label), &env)
+	}
+	return env
+}
 
-				modelName := fields[0]
-				modelID := fields[1]
-				size := fields[2]
-				sizeUnit := fields[3]
+// applyRunnerEnv adds the requested environment variables to a runner
+func applyRunnerEnv(spec *containerSpec) error {
+	env, err := runnerEnv()
+	if err != nil {
+		return fmt.Errorf("invalid --env value: %w", err)
+	}
+	spec.Env = append(spec.Env, env...)
+	spec.Labels[EnvLabel] = runnerEnvLabel(env)
+	return nil
+}
 
-				// Get architecture and quantization details
-				arch, quant, _ := getModelDetails(modelName)
+// envRequested reports whether an environment was asked for with --env or
+// the env setting
+func envRequested() bool {
+	return len(envFlag) > 0 || len(settings().Env) > 0
+}
 
-				// Join all remaining fields for the time info
-				timeIndex := 5
-				if timeIndex < len(fields) {
-					timeInfo := strings.Join(fields[timeIndex:], " ")
+// runnerNeedsRecreate reports whether the existing container was created in a
+// different CPU mode than the one explicitly requested
+func runnerNeedsRecreate() bool {
+	return cpuOnly.set && (containerLabel(CPUOnlyLabel) == "true") != cpuOnly.value
+}
 
-					// Estimate parameters based on size (simplified)
-					var params string
-					if strings.ToUpper(sizeUnit) == "GB" {
-						sizeVal, _ := strconv.ParseFloat(size, 64)
-						params = fmt.Sprintf("%.2f B", sizeVal*1000)
-					} else {
-						params = fmt.Sprintf("%.2f M", float64(parseSize(size)))
-					}
+// ensureOllamaRunning ensures the Ollama container is running. Cancelling
+// ctx abandons pulling the runner's image or waiting for it to be ready
+func ensureOllamaRunning(ctx context.Context) error {
+	// An external Ollama is someone else's to start, so just check it answers
+	if externalOllama() != "" {
+		_, err := ollamaVersion(ctx)
+		return err
+	}
+	if hostRuntime() {
+		if runnerName() != "" {
+			return fmt.Errorf("--runner selects a runner container; the host runtime has a single Ollama")
+		}
+		unlock, err := lockRunner(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+		return ensureHostOllama(ctx)
+	}
 
-					_, _ = fmt.Fprintf(dockerCli.Out(), "+%-11s %-11s %-15s %-13s %-12s %-11s %s %s\n",
-						modelName, params, quant, arch, modelID, timeInfo, size, sizeUnit)
-				}
-			}
+	applyGPUMode()
+	if cpuOnly.value && gpusFlag != "" {
+		return fmt.Errorf("--cpu-only and --gpus cannot be used together")
+	}
+	switch {
+	case runtimeFlag != "" && runtimeFlag != "rocm":
+		return fmt.Errorf("unsupported runtime %q: only rocm is supported", runtimeFlag)
+	case runtimeFlag != "" && cpuOnly.value:
+		return fmt.Errorf("--cpu-only and --runtime cannot be used together")
+	case runtimeFlag != "" && gpusFlag != "":
+		return fmt.Errorf("--gpus is for NVIDIA GPUs and cannot be used with --runtime %s", runtimeFlag)
+	}
 
-			return nil
-		},
+	// What follows checks the runner's state and then acts on it, so only
+	// one command at a time may do it
+	unlock, err := lockRunner(ctx)
+	if err != nil {
+		return err
 	}
-}
+	defer unlock()
 
-// parseSize parses a size string to float
-func parseSize(size string) float64 {
-	val, _ := strconv.ParseFloat(size, 64)
-	return val
-}
+	existing := isOllamaRunning()
+	restarted := false
+	if !existing {
+		if err := checkDockerAvailable(); err != nil {
+			return err
+		}
+		// A runner stopped with `stop --keep` can simply be started again
+		if err := docker.Start(containerName()); err == nil {
+			fmt.Fprintln(os.Stderr, "Restarting Mocker Model Runner...")
+			existing, restarted = true, true
+		}
+	}
+	// Only the default runner is created on demand; a named one has to be
+	// created first, so a typo doesn't start a new runner
+	if !existing && runnerName() != "" && !creatingRunner {
+		if _, ok, err := docker.Inspect(containerName()); err == nil && !ok {
+			return classify(fmt.Errorf("no runner named %q; create it with `docker model runner create %s`", runnerName(), runnerName()), errRunnerNotRunning)
+		}
+	}
 
-// Pull command
-func newPullCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "pull [model]",
-		Short: "Download a model from Docker Hub",
-		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			modelName := args[0]
-			_, _ = fmt.Fprintf(dockerCli.Out(), "Pulling model %s (this is just Ollama in disguise, but don't tell anyone)...\n", modelName)
+	// A runner created with another backend is kept as it is; it's only
+	// replaced by creating it again
+	if existing && activeBackend().Name() != "ollama" {
+		if restarted {
+			return waitForRunner(ctx)
+		}
+		return nil
+	}
 
-			if err := ensureOllamaRunning(); err != nil {
-				return err
+	if existing {
+		drift := runnerDrift()
+		switch {
+		case runnerNeedsRecreate():
+			fmt.Fprintln(os.Stderr, "Recreating Mocker Model Runner for the requested CPU mode...")
+		case len(drift) > 0 && forceRecreate:
+			fmt.Fprintf(os.Stderr, "Recreating Mocker Model Runner to apply %s...\n", strings.Join(drift, ", "))
+		default:
+			// Recreating unloads every model, so only warn unless asked to
+			if len(drift) > 0 {
+				fmt.Fprintf(os.Stderr, "Warning: the running Mocker Model Runner was not created with the requested %s; pass --force-recreate to apply\n", strings.Join(drift, ", "))
 			}
+			if restarted {
+				return waitForRunner(ctx)
+			}
+			return nil
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "Starting Mocker Model Runner...")
+	}
 
-			// Run the pull command with interactive output
-			execCmd := exec.Command("docker", "exec", OllamaContainerName, "ollama", "pull", modelName)
+	// Recreating keeps the GPU mode the runner was created with, unless
+	// another one is asked for
+	keepGPUMode := existing && !cpuOnly.set && gpusFlag == "" && runtimeFlag == ""
+	if keepGPUMode {
+		cpuOnly.value = containerLabel(CPUOnlyLabel) == "true"
+		gpusFlag, runtimeFlag = containerLabel(GPUsLabel), containerLabel(RuntimeLabel)
+	}
 
-			// Create a pipe for command output
-			stdout, err := execCmd.StdoutPipe()
-			if err != nil {
-				return err
-			}
-			stderr, err := execCmd.StderrPipe()
-			if err != nil {
-				return err
+	// The runner comes back after a daemon restart or a crash, unless it
+	// was stopped on purpose
+	spec := containerSpec{
+		Name:        containerName(),
+		Image:       imageName(),
+		Labels:      map[string]string{CPUOnlyLabel: strconv.FormatBool(cpuOnly.value), BackendLabel: "ollama"},
+		Mounts:      []string{volumeName() + ":/root/.ollama"},
+		Ports:       map[int]int{ollamaPort(): DefaultOllamaPort},
+		Healthcheck: runnerHealthcheck,
+		HostConfig: container.HostConfig{
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+		},
+	}
+	for _, setting := range runnerSettings() {
+		// So does every other setting not requested now
+		if existing && setting.value == "" && setting.label != GPUsLabel && setting.label != RuntimeLabel {
+			setting.value = containerLabel(setting.label)
+		}
+		spec.Labels[setting.label] = setting.value
+		if setting.value != "" {
+			if err := setting.apply(&spec.HostConfig, setting.value); err != nil {
+				return fmt.Errorf("invalid %s value %q: %w", setting.flag, setting.value, err)
 			}
+		}
+	}
+	if spec.Labels[RestartLabel] == "" {
+		spec.Labels[RestartLabel] = DefaultRestartPolicy
+	}
+	if cpuOnly.value {
+		spec.Env = slices.Clone(cpuOnlyEnv)
+	}
+	if existing && !envRequested() {
+		env := labelEnv(containerLabel(EnvLabel))
+		spec.Env = append(spec.Env, env...)
+		spec.Labels[EnvLabel] = runnerEnvLabel(env)
+	} else if err := applyRunnerEnv(&spec); err != nil {
+		return err
+	}
 
-			// Start the command
-			if err := execCmd.Start(); err != nil {
+	// Use a GPU when one is available, unless told otherwise
+	autoGPUs := false
+	if gpusFlag == "" && runtimeFlag == "" && !cpuOnly.value && !keepGPUMode {
+		switch {
+		case detectGPUs():
+			if err := applyGPUs(&spec.HostConfig, "all"); err != nil {
 				return err
 			}
+			spec.Labels[GPUsLabel] = "all"
+			autoGPUs = true
+		case detectROCm():
+			_ = applyROCm(&spec.HostConfig, "rocm")
+			spec.Image = imageFor(true)
+			spec.Labels[RuntimeLabel] = "rocm"
+		}
+	}
 
-			// Combine stdout and stderr
-			outputReader := io.MultiReader(stdout, stderr)
-			scanner := bufio.NewScanner(outputReader)
-
-			// Regular expression to find size values
-			sizeRegex := regexp.MustCompile(`pulling [a-f0-9]+\.\.\. 100% ▕[█▏ ]+\s+(\d+(?:\.\d+)?)\s+([KMG]B)`)
+	// First remove any existing container with this name
+	if err := docker.Remove(containerName()); err != nil {
+		return fmt.Errorf("failed to remove old Ollama container: %w", err)
+	}
 
-			// Collect size data
-			var totalSizeKB float64
+	// Create the volume if it doesn't exist
+	if err := docker.CreateVolume(volumeName()); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", volumeName(), err)
+	}
 
-			// Process output line by line
-			for scanner.Scan() {
-				line := scanner.Text()
-				_, _ = fmt.Fprintln(dockerCli.Out(), line)
+	// Then run the container
+	err = createRunner(ctx, spec)
+	if err != nil && autoGPUs && isGPUUnavailable(err.Error()) {
+		// The GPU was detected but the daemon can't pass it through, which
+		// usually means the NVIDIA container toolkit isn't installed
+		hint := "install the NVIDIA container toolkit"
+		if isPodman() {
+			hint += " and generate its CDI spec with `nvidia-ctk cdi generate`"
+		}
+		fmt.Fprintf(os.Stderr, "Warning: an NVIDIA GPU was detected but %s can't use it; %s for GPU acceleration. Falling back to the CPU\n", engineName(), hint)
+		_ = docker.Remove(containerName())
+		spec.HostConfig.DeviceRequests, spec.HostConfig.Devices = nil, nil
+		spec.Labels[GPUsLabel] = ""
+		err = createRunner(ctx, spec)
+	}
+	if err != nil {
+		// Don't leave a created-but-unstartable container behind
+		_ = docker.Remove(containerName())
+		if isPortInUse(err.Error()) {
+			return fmt.Errorf("port %d is already in use on this host; choose another with --port or MOCKER_PORT", ollamaPort())
+		}
+		if isPrivilegedPort(err.Error()) {
+			return fmt.Errorf("a rootless %s can't publish port %d, as it's below 1024; choose another with --port or MOCKER_PORT", engineName(), ollamaPort())
+		}
+		return classify(fmt.Errorf("failed to start Ollama container: %w", err), errRunnerNotRunning)
+	}
 
-				// Try to extract file size
-				matches := sizeRegex.FindStringSubmatch(line)
-				if len(matches) == 3 {
-					size, _ := strconv.ParseFloat(matches[1], 64)
-					unit := matches[2]
+	return waitForRunner(ctx)
+}
 
-					// Convert to KB for standardization
-					switch unit {
-					case "MB":
-						size *= 1000
-					case "GB":
-						size *= 1000000
-					}
+// waitForRunner waits for a freshly started runner's API to answer. On a
+// terminal a spinner shows it's still coming up, so a slow start doesn't
+// look like a hang
+func waitForRunner(ctx context.Context) error {
+	if dockerCLI.Err().IsTerminal() {
+		stop := startSpinner(dockerCLI.Err(), "Waiting for Ollama to be ready")
+		defer stop()
+	}
+	return waitForOllama(ctx, readyTimeout())
+}
 
-					totalSizeKB += size
-				}
+// createRunner creates and starts the runner container, retrying failures
+// that look transient. A container that failed to start is removed before
+// the next attempt, so its name is free again
+func createRunner(ctx context.Context, spec containerSpec) error {
+	runner := runnerName()
+	if runner == "" {
+		runner = DefaultRunnerName
+	}
+	spec.Labels[RunnerLabel] = runner
+	// A named runner created without --port gets a port of its own
+	if containerPort, ok := spec.Ports[0]; ok {
+		delete(spec.Ports, 0)
+		spec.Ports[freeHostPort()] = containerPort
+	}
+	return withRetry(ctx, isTransient, func() error {
+		err := docker.Create(ctx, spec)
+		// A runner that was created but failed to start is removed so a
+		// retry can take its name; a container that already had the name,
+		// or that isn't a runner, isn't ours to remove
+		if err != nil && !errdefs.IsConflict(err) {
+			if state, ok, _ := docker.Inspect(spec.Name); ok && state.Labels[RunnerLabel] == runner {
+				_ = docker.Remove(spec.Name)
 			}
+		}
+		return err
+	})
+}
 
-			// Wait for command to finish
-			if err := execCmd.Wait(); err != nil {
-				return fmt.Errorf("error pulling model: %w", err)
-			}
+// runnerSetting is a runner option that only takes effect when the
+// container is created, recorded as a label so drift can be detected
+type runnerSetting struct {
+	flag  string
+	label string
+	value string
+	apply func(hc *container.HostConfig, value string) error
+}
 
-			// Display download summary
-			if totalSizeKB > 1000 {
-				_, _ = fmt.Fprintf(dockerCli.Out(), "Downloaded: %.2f MB\n", totalSizeKB/1000)
-			} else {
-				_, _ = fmt.Fprintf(dockerCli.Out(), "Downloaded: %.2f KB\n", totalSizeKB)
+// runnerSettings returns the creation-time settings requested on the command
+// line. Values are parsed the same way `docker run` parses its flags
+func runnerSettings() []runnerSetting {
+	return []runnerSetting{
+		{"--gpus", GPUsLabel, gpusFlag, applyGPUs},
+		{"--runtime", RuntimeLabel, runtimeFlag, applyROCm},
+		{"--memory", MemoryLabel, requestedLimit(memoryFlag, "MOCKER_MEMORY", settings().Memory), func(hc *container.HostConfig, value string) error {
+			var memory opts.MemBytes
+			if err := memory.Set(value); err != nil {
+				return err
 			}
-
-			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s pulled successfully (just like some other tools do, but we're honest about it)\n", modelName)
+			hc.Memory = memory.Value()
 			return nil
-		},
-	}
-}
-
-// Remove command
-func newRmCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "rm [model]",
-		Short: "Remove a downloaded model",
-		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			modelName := args[0]
-
-			if err := ensureOllamaRunning(); err != nil {
+		}},
+		{"--cpus", CPUsLabel, requestedLimit(cpusFlag, "MOCKER_CPUS", settings().CPUs), func(hc *container.HostConfig, value string) error {
+			var cpus opts.NanoCPUs
+			if err := cpus.Set(value); err != nil {
 				return err
 			}
-
-			_, err := runInOllama("ollama", "rm", modelName)
+			hc.NanoCPUs = cpus.Value()
+			return nil
+		}},
+		{"--shm-size", ShmSizeLabel, requestedLimit(shmSizeFlag, "MOCKER_SHM_SIZE", settings().ShmSize), func(hc *container.HostConfig, value string) error {
+			var size opts.MemBytes
+			if err := size.Set(value); err != nil {
+				return err
+			}
+			hc.ShmSize = size.Value()
+			return nil
+		}},
+		{"--restart", RestartLabel, requestedRestart(), func(hc *container.HostConfig, value string) error {
+			policy, err := opts.ParseRestartPolicy(value)
 			if err != nil {
 				return err
 			}
-
-			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s removed successfully (and we didn't charge you a subscription for it)\n", modelName)
+			hc.RestartPolicy = policy
 			return nil
-		},
+		}},
 	}
 }
 
-// Run command
-func newRunCommand(dockerCli command.Cli) *cobra.Command {
-	return &cobra.Command{
-		Use:   "run [model] [prompt]",
-		Short: "Run a model interactively or with a prompt",
-		Args:  cobra.MinimumNArgs(1),
-		RunE: func(cmd *cobra.Command, args []string) error {
-			modelName := args[0]
-			args = args[1:] // Remove model name from args
+// requestedLimit resolves a resource limit from its flag, then its MOCKER_*
+// variable, then the config file, or "" for no limit
+func requestedLimit(flag, env, configured string) string {
+	if flag != "" {
+		return flag
+	}
+	return envOrDefault(env, configured)
+}
 
-			if err := ensureOllamaRunning(); err != nil {
-				return err
-			}
+// applyResourceLimits applies the requested memory, CPU and shared memory
+// limits to a runner with a backend other than Ollama, recording them as
+// labels as the Ollama runner does
+func applyResourceLimits(spec *containerSpec) error {
+	for _, setting := range runnerSettings() {
+		if setting.value == "" || (setting.label != MemoryLabel && setting.label != CPUsLabel && setting.label != ShmSizeLabel) {
+			continue
+		}
+		if err := setting.apply(&spec.HostConfig, setting.value); err != nil {
+			return fmt.Errorf("invalid %s value %q: %w", setting.flag, setting.value, err)
+		}
+		spec.Labels[setting.label] = setting.value
+	}
+	return nil
+}
 
-			if len(args) > 0 {
-				// Single prompt mode
-				prompt := strings.Join(args, " ")
-				_, _ = fmt.Fprintln(dockerCli.Out(), "Running with prompt (Ollama is doing all the work, but we'll take credit)...")
-				// Use interactive mode to handle streaming output properly
-				return runInOllamaInteractive("ollama", "run", modelName, prompt)
-			} else {
-				// Interactive chat mode
-				_, _ = fmt.Fprintln(dockerCli.Out(), "Interactive chat mode started. Type 'Ctrl+C' to exit.")
-				_, _ = fmt.Fprintln(dockerCli.Out(), "(What you're about to use is just Ollama's interface with our name on it)")
-				return runInOllamaInteractive("ollama", "run", modelName)
-			}
+// requestedRestart returns the restart policy asked for with --restart,
+// MOCKER_RESTART or the config file, or "" to use the default
+func requestedRestart() string {
+	if restartFlag != "" {
+		return restartFlag
+	}
+	return envOrDefault("MOCKER_RESTART", settings().Restart)
+}
+
+// runnerConfig is how a runner is set up, for recreating it somewhere else
+type runnerConfig struct {
+	name    string
+	image   string
+	models  []string
+	cpuOnly bool
+	gpus    string // as for --gpus
+	rocm    bool
+	memory  string
+	cpus    string
+	shmSize string
+	env     []string
+	restart string
+}
+
+// localRunnerConfig returns the local runner's configuration, as it was
+// created, along with the models installed in it unless --models names
+// others, so that it can be replicated on target. Flags given to the
+// command win
+func localRunnerConfig(ctx context.Context, cmd *cobra.Command, target string) (runnerConfig, error) {
+	o := runnerConfig{
+		name:    containerName(),
+		image:   imageName(),
+		gpus:    gpusFlag,
+		rocm:    runtimeFlag == "rocm",
+		memory:  requestedLimit(memoryFlag, "MOCKER_MEMORY", settings().Memory),
+		cpus:    requestedLimit(cpusFlag, "MOCKER_CPUS", settings().CPUs),
+		shmSize: requestedLimit(shmSizeFlag, "MOCKER_SHM_SIZE", settings().ShmSize),
+		cpuOnly: cpuOnly.value,
+		restart: requestedRestart(),
+	}
+	env, err := runnerEnv()
+	if err != nil {
+		return o, fmt.Errorf("invalid --env value: %w", err)
+	}
+	o.env = env
+	if externalOllama() != "" || hostRuntime() {
+		return o, nil
+	}
+	state, ok, err := docker.Inspect(containerName())
+	if err != nil || !ok {
+		return o, nil
+	}
+	if backend := configOr(state.Labels[BackendLabel], DefaultBackend); backend != "ollama" {
+		return o, fmt.Errorf("the runner serves models with %s, but only Ollama runners can be run on %s", backend, target)
+	}
+
+	if !cmd.Flags().Changed("gpus") && !cmd.Flags().Changed("runtime") && !cpuOnly.set {
+		o.gpus = state.Labels[GPUsLabel]
+		o.rocm = state.Labels[RuntimeLabel] == "rocm"
+		o.cpuOnly = state.Labels[CPUOnlyLabel] == "true"
+		if state.Image != "" {
+			o.image = state.Image
+		}
+	}
+	if !cmd.Flags().Changed("memory") {
+		o.memory = state.Labels[MemoryLabel]
+	}
+	if !cmd.Flags().Changed("cpus") {
+		o.cpus = state.Labels[CPUsLabel]
+	}
+	if !cmd.Flags().Changed("shm-size") {
+		o.shmSize = state.Labels[ShmSizeLabel]
+	}
+	if !cmd.Flags().Changed("env") {
+		o.env = labelEnv(state.Labels[EnvLabel])
+	}
+	if !cmd.Flags().Changed("restart") && state.Labels[RestartLabel] != "" {
+		o.restart = state.Labels[RestartLabel]
+	}
+	if state.Running && !cmd.Flags().Changed("models") {
+		models, err := listLocalModels(ctx)
+		if err != nil {
+			return o, err
+		}
+		for _, m := range models {
+			o.models = append(o.models, m.Name)
+		}
+	}
+	return o, nil
+}
+
+// runnerHealthcheck has Docker check that Ollama answers, so a runner that
+// hangs shows up as unhealthy
+var runnerHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "ollama", "ps"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 30 * time.Second,
+	Retries:     3,
+}
+
+// applyGPUs requests GPU devices for the runner, like `docker run --gpus`
+func applyGPUs(hc *container.HostConfig, value string) error {
+	var gpus opts.GpuOpts
+	if err := gpus.Set(value); err != nil {
+		return err
+	}
+	if isPodman() {
+		return applyCDIGPUs(hc, gpus.Value())
+	}
+	hc.DeviceRequests = gpus.Value()
+	return nil
+}
+
+// applyCDIGPUs passes NVIDIA GPUs to a podman runner. Podman's API ignores
+// device requests, so the GPUs are named as CDI devices instead, as
+// `podman run --gpus` does. SELinux would otherwise keep the container from
+// using them
+func applyCDIGPUs(hc *container.HostConfig, requests []container.DeviceRequest) error {
+	for _, request := range requests {
+		ids := request.DeviceIDs
+		switch {
+		case len(ids) > 0:
+		case request.Count == -1:
+			ids = []string{"all"}
+		default:
+			return fmt.Errorf("podman can't select a number of GPUs; pass all or device=<id>,... instead")
+		}
+		for _, id := range ids {
+			hc.Devices = append(hc.Devices, container.DeviceMapping{PathOnHost: "nvidia.com/gpu=" + id})
+		}
+	}
+	hc.SecurityOpt = append(hc.SecurityOpt, "label=disable")
+	return nil
+}
+
+// rocmDevices are the host devices Ollama's ROCm build needs to reach AMD GPUs
+var rocmDevices = []string{"/dev/kfd", "/dev/dri"}
+
+// applyROCm passes the AMD GPU devices through to the runner. Rootless
+// podman maps the devices' groups away unless the container keeps the
+// user's, and without them it can't open the devices
+func applyROCm(hc *container.HostConfig, _ string) error {
+	for _, device := range rocmDevices {
+		hc.Devices = append(hc.Devices, container.DeviceMapping{
+			PathOnHost:        device,
+			PathInContainer:   device,
+			CgroupPermissions: "rwm",
+		})
+	}
+	if isPodman() {
+		if rootless, err := docker.Rootless(); err == nil && rootless {
+			hc.GroupAdd = append(hc.GroupAdd, "keep-groups")
+		}
+	}
+	return nil
+}
+
+// detectROCm reports whether a local daemon's host exposes the AMD GPU
+// compute device
+func detectROCm() bool {
+	if runnerHostname() != "localhost" {
+		return false
+	}
+	_, err := os.Stat("/dev/kfd")
+	return err == nil
+}
+
+// detectGPUs reports whether an NVIDIA GPU looks usable: the daemon offers
+// the NVIDIA runtime, or a local daemon's host has nvidia-smi listing a GPU
+func detectGPUs() bool {
+	if runtimes, err := docker.Runtimes(); err == nil {
+		for _, runtime := range runtimes {
+			if runtime == "nvidia" {
+				return true
+			}
+		}
+	}
+
+	// nvidia-smi only says something about this machine, not a remote daemon
+	if runnerHostname() != "localhost" {
+		return false
+	}
+	output, err := exec.Command("nvidia-smi", "-L").Output()
+	return err == nil && strings.Contains(string(output), "GPU")
+}
+
+// isGPUUnavailable reports whether starting the runner failed because the
+// daemon couldn't provide the requested GPUs. Podman reports GPUs it has no
+// CDI spec for as unresolvable devices
+func isGPUUnavailable(output string) bool {
+	return strings.Contains(output, "could not select device driver") ||
+		strings.Contains(output, "unresolvable CDI devices")
+}
+
+// runnerDrift returns the flags of requested settings that the existing
+// container was created without
+func runnerDrift() []string {
+	var drift []string
+	for _, setting := range runnerSettings() {
+		if setting.value != "" && containerLabel(setting.label) != setting.value {
+			drift = append(drift, setting.flag)
+		}
+	}
+	if envRequested() {
+		if env, err := runnerEnv(); err == nil && containerLabel(EnvLabel) != runnerEnvLabel(env) {
+			drift = append(drift, "--env")
+		}
+	}
+	return drift
+}
+
+// isPrivilegedPort reports whether starting the runner failed because a
+// rootless engine isn't allowed to publish its port
+func isPrivilegedPort(output string) bool {
+	return strings.Contains(output, "cannot expose privileged port")
+}
+
+// isPortInUse reports whether starting the runner failed because the host port is taken
+func isPortInUse(output string) bool {
+	return strings.Contains(output, "port is already allocated") ||
+		strings.Contains(output, "address already in use")
+}
+
+// runInOllama executes a command in the Ollama container
+func runInOllama(args ...string) (string, error) {
+	var output bytes.Buffer
+	if err := docker.Exec(context.Background(), containerName(), args, &output, &output); err != nil {
+		return "", fmt.Errorf("command failed: %w\nOutput: %s", err, output.String())
+	}
+
+	return output.String(), nil
+}
+
+// statusRow is the state reported by status
+type statusRow struct {
+	Docker    string `json:"docker"`
+	Runner    string `json:"runner"`
+	Context   string `json:"context"`
+	Engine    string `json:"engine"`
+	Container string `json:"container"`
+	Backend   string `json:"backend,omitempty"`
+	API       string `json:"api,omitempty"`
+	Health    string `json:"health,omitempty"`
+	GPU       string `json:"gpu,omitempty"`
+	// Queue is that of a serve on the --listen address, if one is running
+	Queue *queueStats `json:"queue,omitempty"`
+}
+
+// Status command
+func newStatusCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Check if the model runner is running",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			status := statusRow{Docker: "running", Runner: "not running", Container: containerName()}
+			status.Context, status.Engine = dockerEndpoint()
+
+			if url := externalOllama(); url != "" {
+				status = statusRow{Docker: "not used", Runner: "external", API: url, Health: "healthy"}
+				ctx, cancel := context.WithTimeout(cmd.Context(), 5*time.Second)
+				defer cancel()
+				if _, err := ollamaVersion(ctx); err != nil {
+					status.Health = "unreachable"
+				}
+			} else if hostRuntime() {
+				status = statusRow{Docker: "not used", Runner: "host", API: ollamaAPIURL(), Health: "not running"}
+				if hostOllamaRunning() {
+					status.Health = "healthy"
+					status.GPU = hostGPUStatus()
+				}
+			} else if err := checkDockerAvailable(); errors.Is(err, errDockerNotInstalled) {
+				status.Docker = "not installed"
+			} else if errors.Is(err, errDockerNotRunning) {
+				status.Docker = "not running"
+			} else if state, ok, err := docker.Inspect(containerName()); err == nil && ok && state.Running {
+				status.Runner = "active"
+				status.Backend = configOr(state.Labels[BackendLabel], DefaultBackend)
+				status.API = ollamaAPIURL()
+				status.Health = state.Health
+				status.GPU = gpuStatus()
+			}
+			status.Queue, _ = serveQueueStats(cmd.Context(), listen)
+
+			return render(dockerCli.Out(), output, status, []statusRow{status}, func() error {
+				// Only worth mentioning when it's not the usual local engine
+				if status.Context != "" && status.Context != "default" {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Docker context: %s (%s)\n", status.Context, status.Engine)
+				}
+				switch {
+				case status.Runner == "external":
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Using the external Ollama at %s\n", status.API)
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Health: %s\n", status.Health)
+				case status.Runner == "host" && status.Health == "healthy":
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Mocker Model Runner is active on this machine at %s\n", status.API)
+					_, _ = fmt.Fprintf(dockerCli.Out(), "GPU acceleration: %s\n", status.GPU)
+				case status.Runner == "host":
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running on this machine")
+				case status.Docker == "not installed":
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Docker is not installed")
+				case status.Docker == "not running":
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Docker daemon is not running")
+				case status.Runner == "active":
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is active")
+					// Only worth mentioning when it's not the usual backend
+					if status.Backend != DefaultBackend {
+						_, _ = fmt.Fprintf(dockerCli.Out(), "Backend: %s\n", status.Backend)
+					}
+					if status.Health != "" {
+						_, _ = fmt.Fprintf(dockerCli.Out(), "Health: %s\n", status.Health)
+					}
+					_, _ = fmt.Fprintf(dockerCli.Out(), "GPU acceleration: %s\n", status.GPU)
+				default:
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running")
+				}
+				if q := status.Queue; q != nil {
+					if q.Limit > 0 {
+						_, _ = fmt.Fprintf(dockerCli.Out(), "Serve queue: %d waiting, %d of %d slots in use\n", q.Waiting, q.Active, q.Limit)
+					} else {
+						_, _ = fmt.Fprintf(dockerCli.Out(), "Serve queue: %d in flight, no limit\n", q.Active)
+					}
+				}
+				return nil
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+	cmd.Flags().StringVar(&listen, "listen", DefaultListenAddress, "Address of a running `serve` to report the queue of")
+
+	return cmd
+}
+
+// gpuStatus describes whether the runner was created with GPU access
+func gpuStatus() string {
+	if gpus := containerLabel(GPUsLabel); gpus != "" {
+		return "active (--gpus " + gpus + ")"
+	}
+	if containerLabel(RuntimeLabel) == "rocm" {
+		return "active (ROCm)"
+	}
+	if containerLabel(CPUOnlyLabel) == "true" {
+		return "off (--cpu-only)"
+	}
+	return "off"
+}
+
+// runnerRow is the runner container's configuration as reported by
+// runner inspect
+type runnerRow struct {
+	Container string   `json:"container"`
+	Status    string   `json:"status"`
+	Health    string   `json:"health,omitempty"`
+	Backend   string   `json:"backend"`
+	Model     string   `json:"model,omitempty"` // the model a single-model backend serves
+	Restart   string   `json:"restart"`
+	Context   string   `json:"context"`
+	Engine    string   `json:"engine"`
+	Image     string   `json:"image"`
+	API       string   `json:"api"`
+	Volume    string   `json:"volume"`
+	GPU       string   `json:"gpu"`
+	Memory    string   `json:"memory"`
+	CPUs      string   `json:"cpus"`
+	ShmSize   string   `json:"shm_size"`
+	Env       []string `json:"env,omitempty"`
+}
+
+// runnerListRow is a runner as listed by runner ls
+type runnerListRow struct {
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Status    string `json:"status"`
+	Backend   string `json:"backend"`
+	API       string `json:"api"`
+}
+
+// Runner command group
+func newRunnerCommand(dockerCli command.Cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "runner",
+		Short: "Manage the model runner container",
+		Args:  cobra.NoArgs,
+	}
+
+	start := &cobra.Command{
+		Use:   "start",
+		Short: "Start the model runner",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireManagedRunner(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is running")
+			return nil
+		},
+	}
+
+	stop := &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the model runner, keeping its container",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireManagedRunner(cmd); err != nil {
+				return err
+			}
+			if hostRuntime() {
+				return stopHostRunner(dockerCli)
+			}
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if !isOllamaRunning() {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running")
+				return nil
+			}
+			if err := docker.Stop(containerName()); err != nil {
+				return fmt.Errorf("failed to stop Ollama container: %w", err)
+			}
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner stopped")
+			return nil
+		},
+	}
+
+	restart := &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the model runner",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireManagedRunner(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if hostRuntime() {
+				if _, err := stopHostOllama(); err != nil {
+					return fmt.Errorf("failed to stop Ollama: %w", err)
+				}
+			} else if isOllamaRunning() {
+				if err := docker.Stop(containerName()); err != nil {
+					return fmt.Errorf("failed to stop Ollama container: %w", err)
+				}
+			}
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner restarted")
+			return nil
+		},
+	}
+
+	rm := &cobra.Command{
+		Use:   "rm",
+		Short: "Remove the model runner container, keeping downloaded models",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if !containerExists() {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Mocker Model Runner container %s does not exist\n", containerName())
+				return nil
+			}
+			if err := docker.Remove(containerName()); err != nil {
+				return fmt.Errorf("failed to remove Ollama container: %w", err)
+			}
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner removed")
+			return nil
+		},
+	}
+
+	var output outputOptions
+	inspect := &cobra.Command{
+		Use:   "inspect",
+		Short: "Show the model runner's configuration",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+
+			state, ok, err := docker.Inspect(containerName())
+			if err != nil {
+				return fmt.Errorf("failed to inspect Ollama container: %w", err)
+			}
+			if !ok {
+				return fmt.Errorf("the Mocker Model Runner container %s does not exist; start it with `docker model runner start`", containerName())
+			}
+
+			row := runnerRow{
+				Container: containerName(),
+				Status:    state.Status,
+				Health:    state.Health,
+				Backend:   configOr(state.Labels[BackendLabel], DefaultBackend),
+				Model:     state.Labels[ModelLabel],
+				Restart:   configOr(state.Restart, "no"),
+				Image:     state.Image,
+				API:       ollamaAPIURL(),
+				Volume:    volumeName(),
+				GPU:       gpuStatus(),
+				Memory:    configOr(state.Labels[MemoryLabel], "unlimited"),
+				CPUs:      configOr(state.Labels[CPUsLabel], "unlimited"),
+				ShmSize:   configOr(state.Labels[ShmSizeLabel], "default"),
+				Env:       labelEnv(state.Labels[EnvLabel]),
+			}
+
+			row.Context, row.Engine = dockerEndpoint()
+			switch row.Backend {
+			case "vllm", "tgi":
+				row.Volume = huggingFaceVolume()
+			case "llama.cpp":
+				row.Volume = state.Labels[ModelsDirLabel]
+			case "localai":
+				row.Volume = configOr(state.Labels[ModelsDirLabel], localAIVolume())
+			}
+
+			return render(dockerCli.Out(), output, row, []runnerRow{row}, func() error {
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintf(w, "Container:\t%s\n", row.Container)
+				_, _ = fmt.Fprintf(w, "Status:\t%s\n", row.Status)
+				if row.Health != "" {
+					_, _ = fmt.Fprintf(w, "Health:\t%s\n", row.Health)
+				}
+				_, _ = fmt.Fprintf(w, "Backend:\t%s\n", row.Backend)
+				if row.Model != "" {
+					_, _ = fmt.Fprintf(w, "Model:\t%s\n", row.Model)
+				}
+				_, _ = fmt.Fprintf(w, "Restart:\t%s\n", row.Restart)
+				_, _ = fmt.Fprintf(w, "Context:\t%s\n", row.Context)
+				_, _ = fmt.Fprintf(w, "Engine:\t%s\n", row.Engine)
+				_, _ = fmt.Fprintf(w, "Image:\t%s\n", row.Image)
+				_, _ = fmt.Fprintf(w, "API:\t%s\n", row.API)
+				_, _ = fmt.Fprintf(w, "Volume:\t%s\n", row.Volume)
+				_, _ = fmt.Fprintf(w, "GPU:\t%s\n", row.GPU)
+				_, _ = fmt.Fprintf(w, "Memory:\t%s\n", row.Memory)
+				_, _ = fmt.Fprintf(w, "CPUs:\t%s\n", row.CPUs)
+				_, _ = fmt.Fprintf(w, "Shm size:\t%s\n", row.ShmSize)
+				if len(row.Env) > 0 {
+					_, _ = fmt.Fprintf(w, "Env:\t%s\n", strings.Join(row.Env, ", "))
+				}
+				return w.Flush()
+			})
+		},
+	}
+	addOutputFlags(inspect, &output)
+
+	var backendName, model, modelsDir string
+	var shards int
+	create := &cobra.Command{
+		Use:   "create [name]",
+		Short: "Create the model runner, or a named one, with a chosen backend",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+			if len(args) == 1 {
+				if err := validateRunnerName(args[0]); err != nil {
+					return err
+				}
+				runnerFlag = args[0]
+			}
+			creatingRunner = true
+
+			backend, err := backendNamed(backendName)
+			if err != nil {
+				return err
+			}
+			switch {
+			case (backend.Name() == "vllm" || backend.Name() == "tgi") && model == "":
+				return fmt.Errorf("--model is required with --backend %s, to name the Hugging Face model it serves", backend.Name())
+			case backend.Name() == "llama.cpp" && (modelsDir == "" || model == ""):
+				return fmt.Errorf("--models-dir and --model are required with --backend llama.cpp, to name the GGUF file it serves")
+			case backend.Name() == "ollama" && model != "":
+				return fmt.Errorf("--model is only used with --backend vllm, llama.cpp or tgi; pull models into Ollama with `docker model pull`")
+			case backend.Name() == "localai" && model != "":
+				return fmt.Errorf("--model isn't used with --backend localai, which serves every model it has; install more with `docker model pull`")
+			case backend.Name() != "llama.cpp" && backend.Name() != "localai" && modelsDir != "":
+				return fmt.Errorf("--models-dir is only used with --backend llama.cpp or localai")
+			case backend.Name() != "tgi" && shards != 0:
+				return fmt.Errorf("--num-shard is only used with --backend tgi")
+			case shards < 0:
+				return fmt.Errorf("--num-shard must be at least 1")
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if containerExists() {
+				return fmt.Errorf("the Mocker Model Runner container %s already exists; remove it with `docker model runner rm` first", containerName())
+			}
+
+			if backend.Name() == "ollama" {
+				err = ensureOllamaRunning(ctx)
+			} else {
+				var unlock func()
+				if unlock, err = lockRunner(ctx); err != nil {
+					return err
+				}
+				defer unlock()
+				if model != "" {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Creating Mocker Model Runner with %s serving %s...\n", backend.Name(), model)
+				} else {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Creating Mocker Model Runner with %s...\n", backend.Name())
+				}
+				switch backend.Name() {
+				case "vllm":
+					err = createVLLMRunner(ctx, model)
+				case "llama.cpp":
+					err = createLlamaCppRunner(ctx, modelsDir, model)
+				case "tgi":
+					err = createTGIRunner(ctx, model, shards)
+				case "localai":
+					err = createLocalAIRunner(ctx, modelsDir)
+				}
+			}
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Mocker Model Runner created with the %s backend\n", backend.Name())
+			return nil
+		},
+	}
+	create.Flags().StringVar(&backendName, "backend", DefaultBackend, "Backend to serve models with: ollama, vllm, llama.cpp, tgi or localai")
+	create.Flags().StringVar(&model, "model", "", "Model for the backend to serve: a Hugging Face model for vllm and tgi, a GGUF file in --models-dir for llama.cpp")
+	create.Flags().StringVar(&modelsDir, "models-dir", "", "Directory of models to mount: GGUF files for llama.cpp (read-only), model configs and weights for localai")
+	create.Flags().IntVar(&shards, "num-shard", 0, "Number of GPUs the tgi backend shards the model across")
+
+	var lsOutput outputOptions
+	ls := &cobra.Command{
+		Use:     "ls",
+		Aliases: []string{"list"},
+		Short:   "List the runners",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+			if err := lsOutput.validate(); err != nil {
+				return err
+			}
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+
+			states, err := docker.ListLabeled(RunnerLabel)
+			if err != nil {
+				return fmt.Errorf("failed to list runners: %w", err)
+			}
+			rows := []runnerListRow{}
+			for name, state := range states {
+				api := ""
+				if state.HostPort != 0 {
+					api = "http://" + net.JoinHostPort(runnerHostname(), strconv.Itoa(state.HostPort))
+				}
+				rows = append(rows, runnerListRow{
+					Name:      state.Labels[RunnerLabel],
+					Container: name,
+					Status:    state.Status,
+					Backend:   configOr(state.Labels[BackendLabel], DefaultBackend),
+					API:       api,
+				})
+			}
+			slices.SortFunc(rows, func(a, b runnerListRow) int { return strings.Compare(a.Name, b.Name) })
+
+			return render(dockerCli.Out(), lsOutput, rows, rows, func() error {
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "NAME\tCONTAINER\tSTATUS\tBACKEND\tAPI")
+				for _, row := range rows {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.Name, row.Container, row.Status, row.Backend, row.API)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	addOutputFlags(ls, &lsOutput)
+
+	cmd.AddCommand(create, start, stop, restart, rm, inspect, ls)
+	return cmd
+}
+
+// Stop command
+func newStopCommand(dockerCli command.Cli) *cobra.Command {
+	var keep bool
+
+	cmd := &cobra.Command{
+		Use:   "stop [model]",
+		Short: "Stop the model runner, or unload a model from memory",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 1 {
+				if keep {
+					return fmt.Errorf("--keep can't be used when unloading a model")
+				}
+				ctx, stop := commandContext(cmd)
+				defer stop()
+				return unloadModel(ctx, dockerCli, args[0])
+			}
+			if err := requireManagedRunner(cmd); err != nil {
+				return err
+			}
+			if hostRuntime() {
+				return stopHostRunner(dockerCli)
+			}
+
+			if !isOllamaRunning() {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner is not running")
+				return nil
+			}
+
+			// Removing the container is the default; models live in the
+			// volume, so nothing is lost either way
+			stop := docker.Remove
+			if keep {
+				stop = docker.Stop
+			}
+
+			if err := stop(containerName()); err != nil {
+				return fmt.Errorf("failed to stop Ollama container: %w", err)
+			}
+
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Mocker Model Runner stopped")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&keep, "keep", false, "Stop the container but keep it for a faster restart")
+
+	return cmd
+}
+
+// unloadModel evicts a model from memory by asking for a keep-alive of zero,
+// leaving the runner and any other loaded models alone
+func unloadModel(ctx context.Context, dockerCli command.Cli, model string) error {
+	if err := validateModelName(model); err != nil {
+		return err
+	}
+	if err := checkDockerAvailable(); err != nil {
+		return err
+	}
+	if !isOllamaRunning() {
+		return fmt.Errorf("%w, so no models are loaded", errRunnerNotRunning)
+	}
+
+	loaded := func() (bool, error) {
+		models, err := listRunningModels(ctx)
+		if err != nil {
+			return false, err
+		}
+		for _, m := range models {
+			if parseModelReference(m.Name) == parseModelReference(model) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	isLoaded, err := loaded()
+	if err != nil {
+		return err
+	}
+	if !isLoaded {
+		_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s is not loaded\n", model)
+		return nil
+	}
+
+	if err := loadModel(ctx, model, 0); err != nil {
+		return fmt.Errorf("failed to unload %s: %w", model, err)
+	}
+
+	// Ollama evicts asynchronously, so wait for it to leave the list
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		if isLoaded, err = loaded(); err != nil {
+			return err
+		}
+		if !isLoaded {
+			break
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%s is still loaded after asking Ollama to unload it", model)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(250 * time.Millisecond):
+		}
+	}
+
+	_, _ = fmt.Fprintf(dockerCli.Out(), "Unloaded %s from memory\n", model)
+	return nil
+}
+
+// containerExists checks if the Ollama container exists, running or not
+func containerExists() bool {
+	_, ok, err := docker.Inspect(containerName())
+	return err == nil && ok
+}
+
+// Logs command
+func newLogsCommand(dockerCli command.Cli) *cobra.Command {
+	var opts logsOptions
+
+	cmd := &cobra.Command{
+		Use:   "logs",
+		Short: "Show the model runner's logs",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if !containerExists() {
+				return fmt.Errorf("the Mocker Model Runner container %s does not exist; check `docker model status`", containerName())
+			}
+
+			// Ctrl+C is the normal way to stop following, so treat it as success
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			err := docker.Logs(ctx, containerName(), opts, dockerCli.Out(), dockerCli.Err())
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("failed to read runner logs: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.Follow, "follow", "f", false, "Follow log output")
+	cmd.Flags().StringVarP(&opts.Tail, "tail", "n", "all", "Number of lines to show from the end of the logs")
+	cmd.Flags().StringVar(&opts.Since, "since", "", "Show logs since a timestamp (e.g. 2025-01-02T13:23:37Z) or relative duration (e.g. 42m)")
+
+	return cmd
+}
+
+// Serve command
+func newServeCommand(dockerCli command.Cli) *cobra.Command {
+	var listen, balance string
+	var models, runners []string
+	var maxConcurrent int
+	var maxQueueWait time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the model runner's API on a fixed local address",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, model := range models {
+				if err := validateModelName(model); err != nil {
+					return err
+				}
+			}
+			if maxConcurrent < 0 {
+				return fmt.Errorf("--max-concurrent must not be negative")
+			}
+			if maxQueueWait != 0 && maxConcurrent == 0 {
+				return fmt.Errorf("--max-queue-wait requires --max-concurrent; without a limit requests never wait")
+			}
+			queue := newRequestQueue(maxConcurrent, maxQueueWait)
+
+			var handler http.Handler
+			var proxying string
+			if len(runners) > 0 {
+				if err := requireRunnerContainer(cmd); err != nil {
+					return err
+				}
+				if cmd.Flags().Changed("runner") {
+					return fmt.Errorf("--runner and --runners cannot be used together")
+				}
+				if err := checkDockerAvailable(); err != nil {
+					return err
+				}
+				upstreams, err := runnerUpstreams(runners)
+				if err != nil {
+					return err
+				}
+				if handler, err = newBalancedProxy(ctx, dockerCli.Out(), models, queue, upstreams, balance); err != nil {
+					return err
+				}
+				var names []string
+				for _, u := range upstreams {
+					names = append(names, u.name)
+				}
+				proxying = fmt.Sprintf("balancing %s across %s", balance, strings.Join(names, ", "))
+			} else {
+				if err := ensureOllamaRunning(ctx); err != nil {
+					return err
+				}
+				var err error
+				if handler, err = newAPIProxy(dockerCli.Out(), models, queue); err != nil {
+					return err
+				}
+				proxying = "proxying " + ollamaAPIURL()
+			}
+			mux := http.NewServeMux()
+			mux.Handle("/", handler)
+			mux.HandleFunc("GET "+QueuePath, queue.serveStats)
+			server := &http.Server{Addr: listen, Handler: mux}
+
+			errCh := make(chan error, 1)
+			go func() {
+				errCh <- server.ListenAndServe()
+			}()
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Serving the Ollama API on http://%s (%s), press Ctrl+C to stop\n", listen, proxying)
+
+			select {
+			case err := <-errCh:
+				return fmt.Errorf("failed to serve: %w", err)
+			case <-ctx.Done():
+			}
+
+			// Give in-flight requests a moment to finish
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := server.Shutdown(shutdownCtx); err != nil {
+				return fmt.Errorf("failed to shut down cleanly: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", DefaultListenAddress, "Address to listen on")
+	cmd.Flags().StringSliceVar(&models, "models", nil, "Only serve these models (comma-separated or repeated)")
+	cmd.Flags().StringSliceVar(&runners, "runners", nil, "Balance requests across these runners (comma-separated or repeated), or all of them with all")
+	cmd.Flags().StringVar(&balance, "balance", "round-robin", "How to pick a runner with --runners: round-robin or least-loaded")
+	cmd.Flags().IntVar(&maxConcurrent, "max-concurrent", 0, "Requests for models passed on at once, across all --runners; others wait for a slot (0 for no limit)")
+	cmd.Flags().DurationVar(&maxQueueWait, "max-queue-wait", 0, "Fail a request with \"runner busy\" if it waits longer than this for a slot (e.g. 30s)")
+
+	return cmd
+}
+
+// Config command
+func newConfigCommand(dockerCli command.Cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Get and set persistent settings",
+		Long:  "Get and set persistent settings, stored in " + configPath(),
+	}
+
+	get := &cobra.Command{
+		Use:   "get [setting]",
+		Short: "Print one setting, or all of them",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+
+			if len(args) == 1 {
+				key, err := findConfigKey(args[0])
+				if err != nil {
+					return err
+				}
+				_, _ = fmt.Fprintln(dockerCli.Out(), key.get(&cfg))
+				return nil
+			}
+
+			w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+			_, _ = fmt.Fprintln(w, "SETTING\tVALUE\tDESCRIPTION")
+			for _, key := range configKeys {
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", key.name, key.get(&cfg), key.usage)
+			}
+			return w.Flush()
+		},
+	}
+
+	set := &cobra.Command{
+		Use:   "set [setting] [value...]",
+		Short: "Change a setting; an empty value clears it",
+		Args:  cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := findConfigKey(args[0])
+			if err != nil {
+				return err
+			}
+			if len(args) > 2 && !key.list {
+				return fmt.Errorf("%s takes a single value", key.name)
+			}
+
+			// Start from what's on disk so a broken file is reported rather
+			// than silently overwritten
+			cfg, err := loadConfig()
+			if err != nil {
+				return err
+			}
+			if err := key.set(&cfg, strings.Join(args[1:], "\n")); err != nil {
+				return err
+			}
+			if err := saveConfig(cfg); err != nil {
+				return err
+			}
+
+			if key.name != "default_model" && key.name != "keep_alive_default" {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Runner settings apply when the runner is next created; run `docker model stop` to recreate it")
+			}
+			return nil
+		},
+	}
+
+	// Values such as a keep-alive of -1 aren't flags
+	set.Flags().SetInterspersed(false)
+
+	cmd.AddCommand(get, set)
+	return cmd
+}
+
+// Help command - displays custom help, different from the auto-generated cobra help
+func newHelpCommand(dockerCli command.Cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "help",
+		Short: "Show the custom help",
+		Run: func(cmd *cobra.Command, args []string) {
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Usage:  docker model COMMAND")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "Commands:")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  batch       Run a model over a JSONL file of prompts")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  build       Build a model from a directory with a Modelfile and local weights")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  chat        Start or resume an interactive chat")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  compose     Generate a Docker Compose service for the model runner, or add it to a compose file")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  config      Get and set persistent settings")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  conversations  Manage saved chat conversations")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  cp          Copy a model to a new name")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  create      Create a model from a Modelfile")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  df          Show disk usage of downloaded models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  embed       Generate embeddings for text")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  export      Save models to a tar archive for offline transfer")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  export-oci  Push a model to a container registry as an OCI artifact")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  import      Load models from a tar archive made by export")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  inspect     Display full metadata for one or more models as JSON")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  k8s         Generate Kubernetes manifests that run the model runner as configured here")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  list        List models available locally")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  logs        Show the model runner's logs")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  outdated    List installed models with newer versions in their registry")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  prune       Remove models that haven't been used recently")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  ps          List models loaded into memory")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  pull        Download one or more models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  push        Upload a model to a registry")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  rm          Remove one or more downloaded models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  run         Run a model interactively or with a prompt")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  runner      Start, stop, restart, remove or inspect the model runner")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  search      Search the Ollama library for models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  serve       Serve the model runner's API on a fixed local address")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  show        Show details about a model")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  status      Check if the model runner is running")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  stats       Show the runner's resource usage and loaded models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  stop        Stop the model runner, or unload a model from memory")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  tag         Give a model another name (same as cp)")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  tags        List the tags of a model available to pull")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  update      Pull newer versions of installed models")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  version     Show the current version")
+			_, _ = fmt.Fprintln(dockerCli.Out(), "  which       Print the fully-resolved reference for a model")
+		},
+	}
+}
+
+// versionRow is the versions reported by version
+type versionRow struct {
+	Mocker string `json:"mocker"`
+	Ollama string `json:"ollama"`
+}
+
+// Version command
+func newVersionCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the current version",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			version, err := ollamaVersion(ctx)
+			if err != nil {
+				return err
+			}
+
+			versions := versionRow{Mocker: AppVersion, Ollama: version}
+			return render(dockerCli.Out(), output, versions, []versionRow{versions}, func() error {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Mocker version: %s\nOllama version: %s\n", versions.Mocker, versions.Ollama)
+				return nil
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// modelDetails holds the model metadata that mocker reports
+type modelDetails struct {
+	Architecture  string `json:"architecture"`
+	Parameters    string `json:"parameters"`
+	ContextLength string `json:"context_length"`
+	Quantization  string `json:"quantization"`
+	License       string `json:"license"`
+}
+
+// getModelDetails fetches architecture, parameter count, context length,
+// quantization and license details for a model
+func getModelDetails(ctx context.Context, modelName string) (modelDetails, error) {
+	details := modelDetails{
+		Architecture:  "unknown",
+		Parameters:    "unknown",
+		ContextLength: "unknown",
+		Quantization:  "unknown",
+		License:       "unknown",
+	}
+
+	show, err := showModel(ctx, modelName)
+	if err != nil {
+		return details, err
+	}
+
+	arch, _ := show.ModelInfo["general.architecture"].(string)
+	if arch == "" {
+		arch = show.Details.Family
+	}
+	if arch != "" {
+		details.Architecture = arch
+	}
+	if show.Details.ParameterSize != "" {
+		details.Parameters = show.Details.ParameterSize
+	}
+	if n, ok := show.ModelInfo[arch+".context_length"].(float64); ok {
+		details.ContextLength = strconv.FormatInt(int64(n), 10)
+	}
+	if show.Details.QuantizationLevel != "" {
+		details.Quantization = show.Details.QuantizationLevel
+	}
+	// Licenses are long; the first line usually names it
+	for _, line := range strings.Split(show.License, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			details.License = line
+			break
+		}
+	}
+
+	return details, nil
+}
+
+// inspectRow is the full metadata of an installed model as reported by inspect
+type inspectRow struct {
+	Name              string    `json:"name"`
+	Digest            string    `json:"digest"`
+	Size              int64     `json:"size"`
+	ModifiedAt        time.Time `json:"modified_at"`
+	Architecture      string    `json:"architecture"`
+	Family            string    `json:"family"`
+	Families          []string  `json:"families"`
+	Format            string    `json:"format"`
+	ParentModel       string    `json:"parent_model,omitempty"`
+	ParameterSize     string    `json:"parameter_size"`
+	ParameterCount    int64     `json:"parameter_count,omitempty"`
+	QuantizationLevel string    `json:"quantization_level"`
+	ContextLength     int64     `json:"context_length,omitempty"`
+	Parameters        string    `json:"parameters,omitempty"`
+	Template          string    `json:"template"`
+	System            string    `json:"system,omitempty"`
+	License           string    `json:"license"`
+}
+
+// inspectModel gathers the metadata of an installed model
+func inspectModel(ctx context.Context, name string, installed []localModel) (inspectRow, error) {
+	show, err := showModel(ctx, name)
+	if err != nil {
+		return inspectRow{}, err
+	}
+
+	row := inspectRow{
+		Name:              name,
+		Family:            show.Details.Family,
+		Families:          show.Details.Families,
+		Format:            show.Details.Format,
+		ParentModel:       show.Details.ParentModel,
+		ParameterSize:     show.Details.ParameterSize,
+		QuantizationLevel: show.Details.QuantizationLevel,
+		Parameters:        show.Parameters,
+		Template:          show.Template,
+		System:            show.System,
+		License:           show.License,
+	}
+
+	row.Architecture, _ = show.ModelInfo["general.architecture"].(string)
+	if n, ok := show.ModelInfo["general.parameter_count"].(float64); ok {
+		row.ParameterCount = int64(n)
+	}
+	if n, ok := show.ModelInfo[row.Architecture+".context_length"].(float64); ok {
+		row.ContextLength = int64(n)
+	}
+
+	ref := parseModelReference(name)
+	for _, m := range installed {
+		if parseModelReference(m.Name) == ref {
+			row.Name = m.Name
+			row.Digest = m.Digest
+			row.Size = m.Size
+			row.ModifiedAt = m.ModifiedAt
+			break
+		}
+	}
+	return row, nil
+}
+
+// Inspect command
+func newInspectCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "inspect [model...]",
+		Short: "Display full metadata for one or more models as JSON",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+			for _, name := range args {
+				if err := validateModelName(name); err != nil {
+					return err
+				}
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			installed, err := listLocalModels(ctx)
+			if err != nil {
+				return err
+			}
+
+			rows := []inspectRow{}
+			for _, name := range args {
+				row, err := inspectModel(ctx, name, installed)
+				if err != nil {
+					return err
+				}
+				rows = append(rows, row)
+			}
+
+			// Like docker inspect, JSON is the default
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				return writeJSON(dockerCli.Out(), rows)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&output.format, "format", "f", "", "Format output using a Go template")
+
+	return cmd
+}
+
+// showRow is a model's details as reported by show
+type showRow struct {
+	Name string `json:"name"`
+	modelDetails
+}
+
+// Show command
+func newShowCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "show [model]",
+		Short: "Show details about a model",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			details, err := getModelDetails(ctx, modelName)
+			if err != nil {
+				return err
+			}
+
+			row := showRow{Name: modelName, modelDetails: details}
+			return render(dockerCli.Out(), output, row, []showRow{row}, func() error {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Model:           %s\n", row.Name)
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Architecture:    %s\n", row.Architecture)
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Parameters:      %s\n", row.Parameters)
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Context length:  %s\n", row.ContextLength)
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Quantization:    %s\n", row.Quantization)
+				_, _ = fmt.Fprintf(dockerCli.Out(), "License:         %s\n", row.License)
+				return nil
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// modelRow describes a single locally installed model as shown by list
+type modelRow struct {
+	Name         string `json:"name"`
+	Parameters   string `json:"parameters"`
+	Quantization string `json:"quantization"`
+	Architecture string `json:"architecture"`
+	ID           string `json:"id"`
+	Created      string `json:"created"`
+	Size         string `json:"size"`
+	Update       string `json:"update,omitempty"`
+}
+
+// listModels returns the installed models enriched with model details
+func listModels(ctx context.Context) ([]modelRow, error) {
+	models, err := activeBackend().List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []modelRow
+	for _, m := range models {
+		// Get architecture, parameter and quantization details
+		details, _ := getModelDetails(ctx, m.Name)
+
+		id := m.Digest
+		if len(id) > 12 {
+			id = id[:12]
+		}
+
+		rows = append(rows, modelRow{
+			Name:         m.Name,
+			Parameters:   details.Parameters,
+			Quantization: details.Quantization,
+			Architecture: details.Architecture,
+			ID:           id,
+			Created:      humanizeSince(m.ModifiedAt),
+			Size:         formatBytes(m.Size),
+		})
+	}
+
+	return rows, nil
+}
+
+// humanizeSince describes how long ago t was, like "2 hours ago"
+func humanizeSince(t time.Time) string {
+	d := time.Since(t)
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", unit)
+		}
+		return fmt.Sprintf("%d %ss ago", n, unit)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "seconds ago"
+	case d < time.Hour:
+		return plural(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return plural(int(d/time.Hour), "hour")
+	case d < 30*24*time.Hour:
+		return plural(int(d/(24*time.Hour)), "day")
+	case d < 365*24*time.Hour:
+		return plural(int(d/(30*24*time.Hour)), "month")
+	default:
+		return plural(int(d/(365*24*time.Hour)), "year")
+	}
+}
+
+// List command
+func newListCommand(dockerCli command.Cli) *cobra.Command {
+	var remoteLatest bool
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List models available locally",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			rows, err := listModels(ctx)
+			if err != nil {
+				return err
+			}
+
+			if remoteLatest {
+				// Each check is a registry round trip, so run a few side by side
+				sem := make(chan struct{}, UpdateCheckParallelism)
+				var wg sync.WaitGroup
+				for i := range rows {
+					wg.Add(1)
+					go func(row *modelRow) {
+						defer wg.Done()
+						sem <- struct{}{}
+						defer func() { <-sem }()
+						row.Update = checkForUpdate(ctx, row.Name, row.ID)
+					}(&rows[i])
+				}
+				wg.Wait()
+			}
+
+			if rows == nil {
+				rows = []modelRow{}
+			}
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				header := "+MODEL       PARAMETERS  QUANTIZATION    ARCHITECTURE  MODEL ID      CREATED     SIZE"
+				if remoteLatest {
+					header += "      UPDATE"
+				}
+				_, _ = fmt.Fprintln(dockerCli.Out(), header)
+
+				for _, row := range rows {
+					line := fmt.Sprintf("+%-11s %-11s %-15s %-13s %-12s %-11s %s",
+						row.Name, row.Parameters, row.Quantization, row.Architecture, row.ID, row.Created, row.Size)
+					if remoteLatest {
+						line = fmt.Sprintf("%-88s %s", line, row.Update)
+					}
+					_, _ = fmt.Fprintln(dockerCli.Out(), line)
+				}
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().BoolVar(&remoteLatest, "remote-latest", false, "Check the registry for newer versions of each model")
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// psRow is a loaded model as reported by ps
+type psRow struct {
+	Name      string `json:"name"`
+	Size      string `json:"size"`
+	VRAM      string `json:"vram"`
+	Processor string `json:"processor"`
+	Until     string `json:"until"`
+}
+
+// Ps command
+func newPsCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "ps",
+		Short: "List models loaded into memory",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if !isOllamaRunning() {
+				return fmt.Errorf("%w, so no models are loaded", errRunnerNotRunning)
+			}
+
+			models, err := listRunningModels(ctx)
+			if err != nil {
+				return err
+			}
+
+			rows := []psRow{}
+			for _, m := range models {
+				rows = append(rows, psRow{m.Name, formatBytes(m.Size), formatBytes(m.SizeVRAM), processorSplit(m.Size, m.SizeVRAM), loadedUntil(m.ExpiresAt)})
+			}
+
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "MODEL\tSIZE\tVRAM\tPROCESSOR\tUNTIL")
+				for _, row := range rows {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.Name, row.Size, row.VRAM, row.Processor, row.Until)
+				}
+				return w.Flush()
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// statsRow is the runner's resource usage as reported by stats
+type statsRow struct {
+	Container     string   `json:"container"`
+	CPU           string   `json:"cpu"`
+	Memory        string   `json:"memory"`
+	MemoryPercent string   `json:"memory_percent"`
+	Models        []string `json:"models"`
+	VRAM          string   `json:"vram"`
+}
+
+// Stats command
+func newStatsCommand(dockerCli command.Cli) *cobra.Command {
+	var noStream bool
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "stats",
+		Short: "Show the runner's resource usage and loaded models",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := checkDockerAvailable(); err != nil {
+				return err
+			}
+			if !isOllamaRunning() {
+				return errRunnerNotRunning
+			}
+
+			// Ctrl+C is the normal way to stop streaming, so treat it as success
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			// Redraw in place like docker stats, unless the output is for a program
+			redraw := !noStream && output.resolved() == "" && dockerCli.Out().IsTerminal()
+
+			err := docker.Stats(ctx, containerName(), !noStream, func(stats containerStats) error {
+				models, err := listRunningModels(ctx)
+				if err != nil {
+					return err
+				}
+
+				row := statsRow{
+					Container: containerName(),
+					CPU:       fmt.Sprintf("%.2f%%", stats.CPUPercent),
+					Memory:    formatBytes(int64(stats.MemUsage)) + " / " + formatBytes(int64(stats.MemLimit)),
+					Models:    []string{},
+				}
+				if stats.MemLimit > 0 {
+					row.MemoryPercent = fmt.Sprintf("%.2f%%", float64(stats.MemUsage)/float64(stats.MemLimit)*100)
+				}
+				var vram int64
+				for _, m := range models {
+					row.Models = append(row.Models, m.Name)
+					vram += m.SizeVRAM
+				}
+				row.VRAM = formatBytes(vram)
+
+				if redraw {
+					_, _ = fmt.Fprint(dockerCli.Out(), "\033[2J\033[H")
+				}
+				return render(dockerCli.Out(), output, row, []statsRow{row}, func() error {
+					w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+					_, _ = fmt.Fprintln(w, "CONTAINER\tCPU %\tMEM USAGE / LIMIT\tMEM %\tVRAM\tMODELS")
+					loaded := strings.Join(row.Models, ", ")
+					if loaded == "" {
+						loaded = "none"
+					}
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", row.Container, row.CPU, row.Memory, row.MemoryPercent, row.VRAM, loaded)
+					return w.Flush()
+				})
+			})
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("failed to read runner stats: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&noStream, "no-stream", false, "Print a single snapshot instead of live updates")
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// processorSplit describes how much of a loaded model sits in GPU memory,
+// the same way `ollama ps` does
+func processorSplit(size, vram int64) string {
+	switch {
+	case vram <= 0 || size <= 0:
+		return "100% CPU"
+	case vram >= size:
+		return "100% GPU"
+	default:
+		gpu := int(vram * 100 / size)
+		return fmt.Sprintf("%d%%/%d%% CPU/GPU", 100-gpu, gpu)
+	}
+}
+
+// loadedUntil describes when a loaded model will be unloaded
+func loadedUntil(expires time.Time) string {
+	remaining := time.Until(expires)
+	switch {
+	case expires.IsZero() || remaining > 100*365*24*time.Hour:
+		// A negative keep-alive pins the model, which Ollama reports as a far-off expiry
+		return "forever"
+	case remaining <= 0:
+		return "now"
+	default:
+		return "in " + remaining.Round(time.Second).String()
+	}
+}
+
+// modelNameRegex matches the characters Ollama allows in a model reference
+var modelNameRegex = regexp.MustCompile(`^[a-zA-Z0-9._:/-]+$`)
+
+// validateModelName rejects model names that are empty, could be mistaken
+// for a flag by ollama, or contain characters Ollama doesn't allow
+func validateModelName(name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return fmt.Errorf("invalid model name: must not be empty")
+	case strings.HasPrefix(name, "-"):
+		return fmt.Errorf("invalid model name %q: must not start with '-'", name)
+	case !modelNameRegex.MatchString(name):
+		return fmt.Errorf("invalid model name %q: only letters, digits and . _ : / - are allowed", name)
+	}
+	return nil
+}
+
+// localModelSize returns the on-disk size of an installed model
+func localModelSize(ctx context.Context, modelName string) (int64, error) {
+	models, err := listLocalModels(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	ref := parseModelReference(modelName)
+	for _, m := range models {
+		if parseModelReference(m.Name) == ref {
+			return m.Size, nil
+		}
+	}
+	return 0, fmt.Errorf("model %s not found", modelName)
+}
+
+// Pull command
+func newPullCommand(dockerCli command.Cli) *cobra.Command {
+	var file string
+	var failFast bool
+	var registry string
+	var parallel int
+
+	cmd := &cobra.Command{
+		Use:   "pull [model...]",
+		Short: "Download one or more models",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			var specs []modelSpec
+			for _, model := range args {
+				specs = append(specs, modelSpec{Name: model})
+			}
+			// A YAML model set is reconciled: only models that are missing or
+			// have drifted from their pinned digest are pulled
+			reconcile := file != "" && isModelSet(file)
+			switch {
+			case reconcile:
+				set, err := readModelSet(file)
+				if err != nil {
+					return err
+				}
+				specs = append(specs, set...)
+			case file != "":
+				listed, err := readModelList(file)
+				if err != nil {
+					return err
+				}
+				for _, model := range listed {
+					specs = append(specs, modelSpec{Name: model})
+				}
+			}
+			if len(specs) == 0 {
+				return fmt.Errorf("no models to pull: pass model names or --file")
+			}
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+			for i, spec := range specs {
+				if err := validateModelName(spec.Name); err != nil {
+					return err
+				}
+				if registry != "" {
+					if strings.HasPrefix(spec.Name, OCIScheme) {
+						return fmt.Errorf("--registry cannot be used with %s, which already names its registry", spec.Name)
+					}
+					qualified, err := withRegistry(spec.Name, registry)
+					if err != nil {
+						return err
+					}
+					specs[i].Name = qualified
+				}
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			toPull := specs
+			if reconcile {
+				var err error
+				if toPull, err = planModelSet(ctx, dockerCli.Out(), specs); err != nil {
+					return err
+				}
+				if len(toPull) == 0 {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "All %d models are up to date\n", len(specs))
+					return nil
+				}
+				_, _ = fmt.Fprintln(dockerCli.Out())
+			}
+
+			models := make([]string, len(toPull))
+			for i, spec := range toPull {
+				models[i] = spec.Name
+			}
+			failed := pullModels(ctx, dockerCli, models, parallel, failFast)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			if len(models) > 1 {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "\nPulled %d of %d models\n", len(models)-len(failed), len(models))
+				for _, model := range failed {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "  failed: %s\n", model)
+				}
+			}
+
+			if len(failed) > 0 {
+				return fmt.Errorf("%w %d model(s): %s", errPullFailed, len(failed), strings.Join(failed, ", "))
+			}
+
+			if reconcile {
+				drifted, err := verifyModelSet(ctx, toPull)
+				if err != nil {
+					return err
+				}
+				if len(drifted) > 0 {
+					return fmt.Errorf("%d model(s) don't match their pinned digest: %s", len(drifted), strings.Join(drifted, ", "))
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Read model names from a file, one per line, or reconcile a YAML model set")
+	cmd.Flags().BoolVar(&failFast, "fail-fast", false, "Stop at the first model that fails to pull")
+	cmd.Flags().StringVar(&registry, "registry", "", "Pull from this registry instead of the Ollama registry (e.g. registry.example.com)")
+	cmd.Flags().IntVarP(&parallel, "parallel", "j", DefaultPullParallelism, "Number of models to pull at once")
+
+	return cmd
+}
+
+// pullModels pulls models with up to parallel pulls at a time, returning
+// the ones that failed. With fail-fast, no new pull starts after a failure,
+// and none starts once ctx is cancelled
+func pullModels(ctx context.Context, dockerCli command.Cli, models []string, parallel int, failFast bool) []string {
+	// One at a time, each model gets the full in-place progress bar
+	if parallel == 1 || len(models) == 1 {
+		var failed []string
+		for _, model := range models {
+			if err := pullModel(ctx, dockerCli, dockerCli.Out(), dockerCli.Out().IsTerminal(), model); err != nil {
+				failed = append(failed, model)
+				if ctx.Err() != nil {
+					break
+				}
+				_, _ = fmt.Fprintf(dockerCli.Err(), "Error pulling %s: %v\n", model, err)
+				if failFast {
+					break
+				}
+			}
+		}
+		return failed
+	}
+
+	// Concurrent pulls interleave their output line by line, each prefixed
+	// with its model, so progress is printed as lines rather than redrawn
+	var mu sync.Mutex
+	errs := make([]error, len(models))
+	started := make([]bool, len(models))
+	var stop atomic.Bool
+
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(parallel, len(models)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				out := &prefixWriter{mu: &mu, out: dockerCli.Out(), prefix: models[i] + ": "}
+				errs[i] = pullModel(ctx, dockerCli, out, false, models[i])
+				out.flush()
+				if errs[i] != nil && failFast {
+					stop.Store(true)
+				}
+			}
+		}()
+	}
+	for i := range models {
+		if stop.Load() || ctx.Err() != nil {
+			break
+		}
+		started[i] = true
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var failed []string
+	for i, model := range models {
+		switch {
+		case errs[i] != nil:
+			if ctx.Err() == nil {
+				_, _ = fmt.Fprintf(dockerCli.Err(), "Error pulling %s: %v\n", model, errs[i])
+			}
+			failed = append(failed, model)
+		case !started[i]:
+			failed = append(failed, model)
+		}
+	}
+	return failed
+}
+
+// pullModel pulls a single model into the runner, rendering its progress to out
+func pullModel(ctx context.Context, dockerCli command.Cli, out io.Writer, tty bool, modelName string) error {
+	_, _ = fmt.Fprintf(out, "Pulling model %s%s...\n", modelName, quip(dockerCli, "this is just Ollama in disguise, but don't tell anyone"))
+
+	progress := newPullProgress(out, tty)
+	var remote string
+	var err error
+	oci := strings.HasPrefix(modelName, OCIScheme)
+	if oci && externalOllama() != "" {
+		return fmt.Errorf("pulling an OCI artifact writes it into the Mocker Model Runner container, so it can't be used with the external Ollama at %s", externalOllama())
+	}
+	if oci && hostRuntime() {
+		return fmt.Errorf("pulling an OCI artifact writes it into the Mocker Model Runner container, so it can't be used with the host runtime")
+	}
+	if backend := activeBackend(); oci && backend.Name() != "ollama" {
+		return fmt.Errorf("%w: OCI artifacts are pulled into Ollama, but the runner serves models with %s", errUnsupportedByBackend, backend.Name())
+	}
+	for attempt := 1; ; attempt++ {
+		if oci {
+			remote, err = pullOCIArtifact(ctx, dockerCli, modelName, progress)
+		} else {
+			err = activeBackend().Pull(ctx, modelName, progress.apply)
+		}
+		if err == nil || attempt > retries() || !isTransient(err) {
+			break
+		}
+
+		// Ollama keeps partially downloaded layers and verified blobs are
+		// skipped, so the next attempt picks up where this one stopped
+		delay := backoff(attempt)
+		progress.clear()
+		_, _ = fmt.Fprintf(out, "Pull interrupted (%v); resuming in %s (retry %d of %d)\n", err, delay.Round(100*time.Millisecond), attempt, retries())
+		select {
+		case <-ctx.Done():
+		case <-time.After(delay):
+		}
+		if ctx.Err() != nil {
+			err = ctx.Err()
+			break
+		}
+	}
+	progress.finish()
+	if err != nil {
+		return fmt.Errorf("error pulling model: %w", err)
+	}
+	recordPull(modelName, remote, oci)
+
+	// Report what was fetched separately from the model's size, since layers
+	// that were already present aren't downloaded again
+	if downloaded, cached := progress.transferred(); downloaded+cached > 0 {
+		_, _ = fmt.Fprintf(out, "Downloaded %s, %s already present\n", formatBytes(int64(downloaded)), formatBytes(int64(cached)))
+	}
+	modelName = strings.TrimPrefix(modelName, OCIScheme)
+	if size, err := localModelSize(ctx, modelName); err == nil {
+		_, _ = fmt.Fprintf(out, "Model size: %s\n", formatBytes(size))
+	}
+
+	_, _ = fmt.Fprintf(out, "Model %s pulled successfully%s\n", modelName, quip(dockerCli, "just like some other tools do, but we're honest about it"))
+	return nil
+}
+
+// Export command
+func newExportCommand(dockerCli command.Cli) *cobra.Command {
+	var output string
+
+	cmd := &cobra.Command{
+		Use:   "export <model...>",
+		Short: "Save models to a tar archive for offline transfer",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, name := range args {
+				if err := validateModelName(name); err != nil {
+					return err
+				}
+			}
+			if output == "" && dockerCli.Out().IsTerminal() {
+				return fmt.Errorf("refusing to write the archive to a terminal; use -o or redirect the output")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			var w io.Writer = dockerCli.Out()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create archive: %w", err)
+				}
+				defer f.Close()
+				w = f
+			}
+
+			// The archive may be going to stdout, so progress goes to stderr
+			progress := newPullProgress(dockerCli.Err(), dockerCli.Err().IsTerminal())
+			err := exportModels(ctx, w, args, progress)
+			progress.finish()
+			if err != nil {
+				if output != "" {
+					_ = os.Remove(output)
+				}
+				return fmt.Errorf("error exporting models: %w", err)
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Err(), "Exported %s\n", strings.Join(args, ", "))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write to a file instead of stdout")
+
+	return cmd
+}
+
+// Import command
+func newImportCommand(dockerCli command.Cli) *cobra.Command {
+	var input string
+
+	cmd := &cobra.Command{
+		Use:   "import",
+		Short: "Load models from a tar archive made by export",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if input == "" && dockerCli.In().IsTerminal() {
+				return fmt.Errorf("no archive given; use -i or redirect one to stdin")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			var r io.Reader = dockerCli.In()
+			if input != "" {
+				f, err := os.Open(input)
+				if err != nil {
+					return fmt.Errorf("failed to open archive: %w", err)
+				}
+				defer f.Close()
+				r = f
+			}
+
+			progress := newPullProgress(dockerCli.Out(), dockerCli.Out().IsTerminal())
+			imported, err := importModels(ctx, r, progress)
+			progress.finish()
+			for _, name := range imported {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Loaded model: %s\n", name)
+			}
+			if err != nil {
+				return fmt.Errorf("error importing models: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "i", "", "Read from a file instead of stdin")
+
+	return cmd
+}
+
+// Export OCI command
+func newExportOCICommand(dockerCli command.Cli) *cobra.Command {
+	var insecure bool
+
+	cmd := &cobra.Command{
+		Use:   "export-oci <model> <reference>",
+		Short: "Push a model to a container registry as an OCI artifact",
+		Args:  cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, name := range args {
+				if err := validateModelName(name); err != nil {
+					return err
+				}
+			}
+			src := parseModelReference(args[0])
+			dst := parseModelReference(args[1])
+			if dst.Registry == DefaultRegistry {
+				return fmt.Errorf("%s must name a container registry, such as ghcr.io/team/model", args[1])
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Exporting model %s to %s...\n", args[0], dst)
+			progress := newPullProgress(dockerCli.Out(), dockerCli.Out().IsTerminal())
+			err := pushToRegistry(ctx, dockerCli, src, dst, insecure, true, progress)
+			progress.finish()
+			if err != nil {
+				return fmt.Errorf("error exporting model: %w", err)
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s exported; pull it with `docker model pull %s%s`\n", args[0], OCIScheme, args[1])
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Use plain HTTP, for a self-hosted registry without TLS")
+
+	return cmd
+}
+
+// Push command
+func newPushCommand(dockerCli command.Cli) *cobra.Command {
+	var insecure bool
+
+	cmd := &cobra.Command{
+		Use:   "push <model>",
+		Short: "Upload a model to a registry",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Pushing model %s...\n", modelName)
+			progress := newPullProgress(dockerCli.Out(), dockerCli.Out().IsTerminal())
+
+			var err error
+			ref := parseModelReference(modelName)
+			if ref.Registry == DefaultRegistry {
+				// ollama.com authenticates with the runner's own key, which
+				// only Ollama can sign with, so it does the push
+				err = pushModelAPI(ctx, modelName, insecure, progress.apply)
+				if err != nil && strings.Contains(err.Error(), "unauthorized") && externalOllama() == "" {
+					if key, keyErr := runnerPublicKey(); keyErr == nil {
+						err = fmt.Errorf("%w\nAdd the runner's public key to your ollama.com account, then try again:\n%s", err, strings.TrimSpace(key))
+					}
+				}
+			} else if url := externalOllama(); url != "" {
+				err = fmt.Errorf("pushing to %s reads the model from the Mocker Model Runner container, so it can't be done with the external Ollama at %s", ref.Registry, url)
+			} else if hostRuntime() {
+				err = fmt.Errorf("pushing to %s reads the model from the Mocker Model Runner container, so it can't be done with the host runtime", ref.Registry)
+			} else {
+				err = pushToRegistry(ctx, dockerCli, ref, ref, insecure, false, progress)
+			}
+			progress.finish()
+			if err != nil {
+				return fmt.Errorf("error pushing model: %w", err)
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s pushed successfully\n", modelName)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&insecure, "insecure", false, "Use plain HTTP, for a self-hosted registry without TLS")
+
+	return cmd
+}
+
+// readModelList reads model names from a file, one per line, skipping blank
+// lines and # comments
+func readModelList(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model list: %w", err)
+	}
+
+	var models []string
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		models = append(models, line)
+	}
+	return models, nil
+}
+
+// modelfileBase returns the model named by a Modelfile's FROM instruction,
+// or an empty string if there is none or it refers to a weights file
+func modelfileBase(modelfile string) string {
+	for _, line := range strings.Split(modelfile, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "FROM") {
+			continue
+		}
+		base := fields[1]
+		if strings.HasPrefix(base, ".") || strings.HasPrefix(base, "/") || strings.HasSuffix(strings.ToLower(base), ".gguf") {
+			return ""
+		}
+		return base
+	}
+	return ""
+}
+
+// Create command
+func newCreateCommand(dockerCli command.Cli) *cobra.Command {
+	var file string
+
+	cmd := &cobra.Command{
+		Use:   "create [model]",
+		Short: "Create a model from a Modelfile",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+
+			modelfile, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read Modelfile: %w", err)
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			// Ollama would otherwise fail with an opaque manifest error
+			if base := modelfileBase(string(modelfile)); base != "" {
+				if _, err := localModelSize(ctx, base); err != nil {
+					return fmt.Errorf("base model %s is not available locally; pull it first with `docker model pull %s`", base, base)
+				}
+			}
+
+			// The Modelfile lives on the host but Ollama runs in the
+			// container, so copy it in for the duration of the build
+			containerPath := fmt.Sprintf("/tmp/mocker-Modelfile-%d", os.Getpid())
+			if err := docker.CopyFile(ctx, containerName(), containerPath, bytes.NewReader(modelfile), int64(len(modelfile))); err != nil {
+				return fmt.Errorf("failed to copy Modelfile into the runner: %w", err)
+			}
+			defer func() { _, _ = runInOllama("rm", "-f", containerPath) }()
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Creating model %s...\n", modelName)
+			if err := ollamaCreate(ctx, dockerCli, modelName, containerPath); err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s created successfully\n", modelName)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "Modelfile", "Path to the Modelfile")
+
+	return cmd
+}
+
+// ollamaCreate runs `ollama create` in the runner on a Modelfile already
+// copied into it, rendering its progress
+func ollamaCreate(ctx context.Context, dockerCli command.Cli, modelName, containerModelfile string) error {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(docker.Exec(ctx, containerName(), []string{"ollama", "create", modelName, "-f", containerModelfile}, pw, pw))
+	}()
+
+	progress := newPullProgress(dockerCli.Out(), dockerCli.Out().IsTerminal())
+	if err := progress.Consume(pr); err != nil {
+		return fmt.Errorf("error creating model: %w", err)
+	}
+	return nil
+}
+
+// installedModels returns the installed models with the given names, or
+// all of them when no names are given
+func installedModels(ctx context.Context, names []string) ([]localModel, error) {
+	models, err := listLocalModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return models, nil
+	}
+
+	var selected []localModel
+	for _, name := range names {
+		ref := parseModelReference(name)
+		found := false
+		for _, m := range models {
+			if parseModelReference(m.Name) == ref {
+				selected = append(selected, m)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("model %s is not installed", name)
+		}
+	}
+	return selected, nil
+}
+
+// Outdated command
+func newOutdatedCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "outdated [model...]",
+		Short: "List installed models with newer versions in their registry",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			models, err := installedModels(ctx, args)
+			if err != nil {
+				return err
+			}
+			rows, unchecked, err := findOutdated(ctx, dockerCli, models)
+			if err != nil {
+				return err
+			}
+			// Named models should be checkable; others may simply have been
+			// created locally
+			if len(args) > 0 {
+				for name, err := range unchecked {
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Could not check %s: %v\n", name, err)
+				}
+			}
+
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				if len(rows) == 0 {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "All %d checked models are up to date\n", len(models)-len(unchecked))
+					return nil
+				}
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "MODEL\tCURRENT\tLATEST\tSTATUS")
+				for _, row := range rows {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", row.Name, row.Current, row.Latest, row.Status)
+				}
+				return w.Flush()
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// Update command
+func newUpdateCommand(dockerCli command.Cli) *cobra.Command {
+	var all bool
+	var parallel int
+
+	cmd := &cobra.Command{
+		Use:   "update [model...]",
+		Short: "Pull newer versions of installed models",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if all == (len(args) > 0) {
+				return fmt.Errorf("specify models to update or --all, but not both")
+			}
+			if parallel < 1 {
+				return fmt.Errorf("--parallel must be at least 1")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			models, err := installedModels(ctx, args)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Checking %d models for updates...\n", len(models))
+			rows, unchecked, err := findOutdated(ctx, dockerCli, models)
+			if err != nil {
+				return err
+			}
+			if len(args) > 0 {
+				for name, err := range unchecked {
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Could not check %s: %v\n", name, err)
+				}
+			}
+
+			var toPull []string
+			current := map[string]string{}
+			for _, row := range rows {
+				if row.Status == StatusChangedLocally {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Skipping %s: it changed locally since it was pulled; pull it to replace it\n", row.Name)
+					continue
+				}
+				name := row.Name
+				if row.oci {
+					name = OCIScheme + name
+				}
+				toPull = append(toPull, name)
+				current[name] = row.Current
+			}
+			if len(toPull) == 0 {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Nothing to update")
+				return nil
+			}
+			_, _ = fmt.Fprintln(dockerCli.Out())
+
+			failed := pullModels(ctx, dockerCli, toPull, parallel, false)
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			// Summarize what changed using the IDs the models have now
+			installed, err := listLocalModels(ctx)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(dockerCli.Out(), "\nUpdated %d of %d models\n", len(toPull)-len(failed), len(toPull))
+			w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+			for _, name := range toPull {
+				if slices.Contains(failed, name) {
+					_, _ = fmt.Fprintf(w, "  %s\tfailed\n", name)
+					continue
+				}
+				ref := parseModelReference(name)
+				for _, m := range installed {
+					if parseModelReference(m.Name) == ref {
+						_, _ = fmt.Fprintf(w, "  %s\t%s -> %s\n", m.Name, current[name], shortDigest(m.Digest))
+					}
+				}
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+
+			if len(failed) > 0 {
+				return classify(fmt.Errorf("failed to update %d model(s): %s", len(failed), strings.Join(failed, ", ")), errPullFailed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Update every installed model with a newer version")
+	cmd.Flags().IntVarP(&parallel, "parallel", "j", DefaultPullParallelism, "Number of models to pull at once")
+
+	return cmd
+}
+
+// Chat command
+func newChatCommand(dockerCli command.Cli) *cobra.Command {
+	var resume string
+	var system string
+	var keepAlive string
+	var sampling samplingFlags
+	var render bool
+	var statsFormat string
+
+	cmd := &cobra.Command{
+		Use:   "chat [model]",
+		Short: "Start or resume an interactive chat",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := sampling.validate(); err != nil {
+				return err
+			}
+			if statsFormat != "" && !slices.Contains(statsFormats, statsFormat) {
+				return fmt.Errorf("invalid --stats format %q: must be one of %s", statsFormat, strings.Join(statsFormats, ", "))
+			}
+
+			session := &chatSession{}
+			if resume != "" {
+				var err error
+				if session, err = findConversation(resume); err != nil {
+					return err
+				}
+			}
+			// A model given on the command line continues a resumed
+			// conversation with a different model
+			switch {
+			case len(args) > 0:
+				session.Model = args[0]
+			case session.Model == "":
+				session.Model = settings().DefaultModel
+			}
+			if session.Model == "" {
+				return fmt.Errorf("no model given; pass one or set a default with `docker model config set default_model <model>`")
+			}
+			if err := validateModelName(session.Model); err != nil {
+				return err
+			}
+			if cmd.Flags().Changed("system") {
+				session.System = system
+			}
+			if !cmd.Flags().Changed("keep-alive") {
+				keepAlive = keepAliveDefault()
+			}
+			session.keepAlive = keepAliveValue(keepAlive)
+			session.options = sampling.options(cmd.Flags())
+			session.render = renderMarkdown(dockerCli, cmd.Flags(), render)
+			session.stats = statsFormat
+
+			// The chat handles Ctrl+C itself, stopping just the reply
+			ctx := cmd.Context()
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+			if err := loadModel(ctx, session.Model, session.keepAlive); err != nil {
+				return err
+			}
+			recordUsage(session.Model)
+
+			if resume != "" {
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Resuming conversation %s with %s (%d messages). Type /? for help, /bye or Ctrl+D to exit.\n", session.ID, session.Model, len(session.Messages))
+			} else {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Interactive chat mode started. Type /? for help, /bye or Ctrl+D to exit.")
+			}
+			return runChat(ctx, dockerCli, session)
+		},
+	}
+
+	cmd.Flags().StringVar(&resume, "resume", "", "ID of a saved conversation to continue")
+	cmd.Flags().StringVar(&system, "system", "", "System prompt to use instead of the model's own")
+	cmd.Flags().StringVar(&keepAlive, "keep-alive", "", "How long the model stays loaded after the chat; overrides MOCKER_KEEP_ALIVE and the keep_alive_default setting")
+	cmd.Flags().BoolVar(&render, "render", false, "Render replies as Markdown (default from the render setting)")
+	cmd.Flags().StringVar(&statsFormat, "stats", "", "After each reply, report token counts, throughput and latency (text or json)")
+	cmd.Flags().Lookup("stats").NoOptDefVal = "text"
+	sampling.add(cmd.Flags())
+
+	return cmd
+}
+
+// conversationRow is a saved conversation as reported by conversations list
+type conversationRow struct {
+	ID       string `json:"id"`
+	Model    string `json:"model"`
+	Messages int    `json:"messages"`
+	Updated  string `json:"updated"`
+	Title    string `json:"title"`
+}
+
+// Conversations command
+func newConversationsCommand(dockerCli command.Cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "conversations",
+		Short: "Manage saved chat conversations",
+		Args:  cobra.NoArgs,
+	}
+
+	var output outputOptions
+	list := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List saved conversations",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := output.validate(); err != nil {
+				return err
+			}
+			sessions, err := listConversations()
+			if err != nil {
+				return err
+			}
+
+			rows := []conversationRow{}
+			for _, s := range sessions {
+				rows = append(rows, conversationRow{s.ID, s.Model, len(s.Messages), humanizeSince(s.Updated), s.title()})
+			}
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "ID\tMODEL\tMESSAGES\tUPDATED\tTITLE")
+				for _, row := range rows {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%s\n", row.ID, row.Model, row.Messages, row.Updated, row.Title)
+				}
+				return w.Flush()
+			})
+		},
+	}
+	addOutputFlags(list, &output)
+
+	rm := &cobra.Command{
+		Use:   "rm <id...>",
+		Short: "Delete saved conversations",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			for _, id := range args {
+				s, err := findConversation(id)
+				if err != nil {
+					return err
+				}
+				if err := removeConversation(s.ID); err != nil {
+					return fmt.Errorf("failed to delete conversation %s: %w", s.ID, err)
+				}
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Deleted: %s\n", s.ID)
+			}
+			return nil
+		},
+	}
+
+	var exportFormat string
+	var exportFile string
+	export := &cobra.Command{
+		Use:   "export <id>",
+		Short: "Write a saved conversation as Markdown or JSON",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if exportFormat != "markdown" && exportFormat != "json" {
+				return fmt.Errorf("invalid --format %q: expected markdown or json", exportFormat)
+			}
+			s, err := findConversation(args[0])
+			if err != nil {
+				return err
+			}
+
+			var w io.Writer = dockerCli.Out()
+			if exportFile != "" {
+				f, err := os.Create(exportFile)
+				if err != nil {
+					return fmt.Errorf("failed to create %s: %w", exportFile, err)
+				}
+				defer f.Close()
+				w = f
+			}
+			if exportFormat == "json" {
+				return writeJSON(w, s)
+			}
+			return s.writeMarkdown(w)
+		},
+	}
+	export.Flags().StringVar(&exportFormat, "format", "markdown", "Output format: markdown or json")
+	export.Flags().StringVarP(&exportFile, "output", "o", "", "Write to a file instead of stdout")
+
+	cmd.AddCommand(list, rm, export)
+	return cmd
+}
+
+// embeddingRow is an input's embedding as reported by embed
+type embeddingRow struct {
+	Input     string    `json:"input"`
+	Embedding []float64 `json:"embedding"`
+}
+
+// Embed command
+func newEmbedCommand(dockerCli command.Cli) *cobra.Command {
+	var input, keepAlive string
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "embed <model> [text...]",
+		Short: "Generate embeddings for text",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+
+			// Each argument is one text; files and piped input have one
+			// text per line
+			texts := args[1:]
+			var r io.Reader
+			switch {
+			case input != "":
+				f, err := os.Open(input)
+				if err != nil {
+					return fmt.Errorf("failed to open input: %w", err)
+				}
+				defer f.Close()
+				r = f
+			case len(texts) == 0 && !dockerCli.In().IsTerminal():
+				r = dockerCli.In()
+			}
+			if r != nil {
+				scanner := bufio.NewScanner(r)
+				scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+				for scanner.Scan() {
+					if line := strings.TrimSpace(scanner.Text()); line != "" {
+						texts = append(texts, line)
+					}
+				}
+				if err := scanner.Err(); err != nil {
+					return fmt.Errorf("failed to read input: %w", err)
+				}
+			}
+			if len(texts) == 0 {
+				return fmt.Errorf("no text to embed: pass it as arguments, with --input, or on stdin")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+			recordUsage(modelName)
+
+			if !cmd.Flags().Changed("keep-alive") {
+				keepAlive = keepAliveDefault()
+			}
+			embeddings, err := activeBackend().Embed(ctx, modelName, texts, keepAliveValue(keepAlive))
+			if err != nil {
+				return err
+			}
+			rows := make([]embeddingRow, len(texts))
+			for i, text := range texts {
+				rows[i] = embeddingRow{Input: text, Embedding: embeddings[i]}
+			}
+
+			// By default each embedding is a JSON line, for streaming into
+			// other tools; --json prints a single array instead
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				enc := json.NewEncoder(dockerCli.Out())
+				enc.SetEscapeHTML(false)
+				for _, row := range rows {
+					if err := enc.Encode(row); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "i", "", "File of texts to embed, one per line")
+	cmd.Flags().StringVar(&keepAlive, "keep-alive", "", "How long the model stays loaded after embedding; overrides MOCKER_KEEP_ALIVE and the keep_alive_default setting")
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// Batch command
+func newBatchCommand(dockerCli command.Cli) *cobra.Command {
+	var input string
+	var output string
+	var concurrency int
+	var system string
+	var keepAlive string
+	var sampling samplingFlags
+
+	cmd := &cobra.Command{
+		Use:   "batch <model>",
+		Short: "Run a model over a JSONL file of prompts",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+			if concurrency < 1 {
+				return fmt.Errorf("--concurrency must be at least 1")
+			}
+			if err := sampling.validate(); err != nil {
+				return err
+			}
+
+			var r io.Reader = dockerCli.In()
+			if input != "" {
+				f, err := os.Open(input)
+				if err != nil {
+					return fmt.Errorf("failed to open input: %w", err)
+				}
+				defer f.Close()
+				r = f
+			} else if dockerCli.In().IsTerminal() {
+				return fmt.Errorf("no prompts: pass --input or pipe JSONL to stdin")
+			}
+			prompts, err := readBatchPrompts(r)
+			if err != nil {
+				return err
+			}
+			if len(prompts) == 0 {
+				return fmt.Errorf("no prompts in input")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+			// An explicit flag wins over the MOCKER_KEEP_ALIVE or configured default
+			if !cmd.Flags().Changed("keep-alive") {
+				keepAlive = keepAliveDefault()
+			}
+			if err := loadModel(ctx, modelName, keepAliveValue(keepAlive)); err != nil {
+				return err
+			}
+			recordUsage(modelName)
+
+			var w io.Writer = dockerCli.Out()
+			if output != "" {
+				f, err := os.Create(output)
+				if err != nil {
+					return fmt.Errorf("failed to create output: %w", err)
+				}
+				defer f.Close()
+				w = f
+			}
+
+			// Progress goes to stderr, since results may be going to stdout
+			start := time.Now()
+			processed, failures := 0, 0
+			tty := dockerCli.Err().IsTerminal()
+			base := generateRequest{Model: modelName, System: system, Options: sampling.options(cmd.Flags()), KeepAlive: keepAliveValue(keepAlive)}
+			failed, err := runBatch(ctx, base, prompts, concurrency, w, func(result batchResult) {
+				processed++
+				if result.Error != "" {
+					failures++
+				}
+				if tty {
+					_, _ = fmt.Fprintf(dockerCli.Err(), "\r\x1b[KProcessed %d/%d prompts (%d failed)", processed, len(prompts), failures)
+				}
+			})
+			if tty {
+				_, _ = fmt.Fprintln(dockerCli.Err())
+			}
+			if err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Err(), "Processed %d prompts in %s\n", len(prompts), time.Since(start).Round(time.Second))
+			if failed > 0 {
+				return fmt.Errorf("%d of %d prompts failed; see the error field of their results", failed, len(prompts))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&input, "input", "i", "", "JSONL file of prompts (default stdin)")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "File to write JSONL results to (default stdout)")
+	cmd.Flags().IntVarP(&concurrency, "concurrency", "c", DefaultBatchConcurrency, "Number of prompts to run at once")
+	cmd.Flags().StringVar(&system, "system", "", "System prompt for prompts that don't set their own")
+	cmd.Flags().StringVar(&keepAlive, "keep-alive", "", "How long the model stays loaded after the batch; overrides MOCKER_KEEP_ALIVE and the keep_alive_default setting")
+	sampling.add(cmd.Flags())
+
+	return cmd
+}
+
+// Tags command
+func newTagsCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "tags <model>",
+		Short: "List the tags of a model available to pull",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			rows, err := listRemoteTags(ctx, dockerCli, args[0])
+			if err != nil {
+				return err
+			}
+
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				if len(rows) == 0 {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "No tags found for %s\n", args[0])
+					return nil
+				}
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "NAME\tMODEL ID\tSIZE")
+				for _, row := range rows {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", row.Name, row.ID, row.Size)
+				}
+				return w.Flush()
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// Search command
+func newSearchCommand(dockerCli command.Cli) *cobra.Command {
+	var limit int
+	var noTrunc bool
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "search <query>",
+		Short: "Search the Ollama library for models",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			rows, err := searchLibrary(ctx, args[0])
+			if err != nil {
+				return err
+			}
+			if limit > 0 && len(rows) > limit {
+				rows = rows[:limit]
+			}
+
+			return render(dockerCli.Out(), output, rows, rows, func() error {
+				if len(rows) == 0 {
+					_, _ = fmt.Fprintf(dockerCli.Out(), "No models match %q\n", args[0])
+					return nil
+				}
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "NAME\tDESCRIPTION\tSIZES\tCAPABILITIES\tPULLS")
+				for _, row := range rows {
+					description := row.Description
+					if !noTrunc && len(description) > 45 {
+						description = description[:44] + "…"
+					}
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.Name, description, strings.Join(row.Sizes, ", "), strings.Join(row.Capabilities, ", "), row.Pulls)
+				}
+				return w.Flush()
+			})
+		},
+	}
+
+	cmd.Flags().IntVar(&limit, "limit", 25, "Maximum number of results")
+	cmd.Flags().BoolVar(&noTrunc, "no-trunc", false, "Don't truncate descriptions")
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// Copy command
+func newCpCommand(dockerCli command.Cli) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "cp <source> <destination>",
+		Aliases: []string{"tag"},
+		Short:   "Copy a model to a new name",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			for _, name := range args {
+				if err := validateModelName(name); err != nil {
+					return err
+				}
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			err := copyModel(ctx, args[0], args[1])
+			if errors.Is(err, errModelNotFound) {
+				return fmt.Errorf("model %s not found; see `docker model list`", args[0])
+			}
+			if err != nil {
+				return fmt.Errorf("failed to copy %s: %w", args[0], err)
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Copied %s to %s\n", args[0], args[1])
+			return nil
+		},
+	}
+
+	return cmd
+}
+
+// Build command
+func newBuildCommand(dockerCli command.Cli) *cobra.Command {
+	var file string
+	var tags []string
+
+	cmd := &cobra.Command{
+		Use:   "build [context]",
+		Short: "Build a model from a directory with a Modelfile and local weights",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			contextDir := "."
+			if len(args) == 1 {
+				contextDir = args[0]
+			}
+			info, err := os.Stat(contextDir)
+			if err != nil {
+				return fmt.Errorf("failed to read build context: %w", err)
+			}
+			if !info.IsDir() {
+				return fmt.Errorf("build context %s is not a directory", contextDir)
+			}
+
+			if file == "" {
+				file = filepath.Join(contextDir, "Modelfile")
+			}
+			modelfile, err := os.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read Modelfile: %w", err)
+			}
+
+			// Like an untagged docker build, but Ollama needs a name
+			if len(tags) == 0 {
+				abs, err := filepath.Abs(contextDir)
+				if err != nil {
+					return err
+				}
+				tags = []string{strings.ToLower(filepath.Base(abs))}
+			}
+			for _, tag := range tags {
+				if err := validateModelName(tag); err != nil {
+					return err
+				}
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			if base := modelfileBase(string(modelfile)); base != "" {
+				if _, err := localModelSize(ctx, base); err != nil {
+					return fmt.Errorf("base model %s is not available locally; pull it first with `docker model pull %s`", base, base)
+				}
+			}
+
+			// Relative paths in the Modelfile are resolved from the context,
+			// so it goes at the context's root wherever it came from
+			buildDir := fmt.Sprintf("/tmp/mocker-build-%d", os.Getpid())
+			if _, err := runInOllama("mkdir", "-p", buildDir); err != nil {
+				return fmt.Errorf("failed to prepare the build in the runner: %w", err)
+			}
+			defer func() { _, _ = runInOllama("rm", "-rf", buildDir) }()
+
+			size, err := buildContextSize(contextDir)
+			if err != nil {
+				return err
+			}
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Sending build context to the runner (%s)...\n", formatBytes(size))
+			if err := docker.CopyArchive(ctx, containerName(), buildDir, buildContextArchive(contextDir)); err != nil {
+				return fmt.Errorf("failed to copy the build context into the runner: %w", err)
+			}
+			containerModelfile := buildDir + "/.mocker-Modelfile"
+			if err := docker.CopyFile(ctx, containerName(), containerModelfile, bytes.NewReader(modelfile), int64(len(modelfile))); err != nil {
+				return fmt.Errorf("failed to copy Modelfile into the runner: %w", err)
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Building model %s...\n", tags[0])
+			if err := ollamaCreate(ctx, dockerCli, tags[0], containerModelfile); err != nil {
+				return err
+			}
+			for _, tag := range tags[1:] {
+				if err := copyModel(ctx, tags[0], tag); err != nil {
+					return fmt.Errorf("failed to tag %s: %w", tag, err)
+				}
+			}
+
+			_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s built successfully\n", strings.Join(tags, ", "))
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&file, "file", "f", "", "Path to the Modelfile (default: <context>/Modelfile)")
+	cmd.Flags().StringArrayVarP(&tags, "tag", "t", nil, "Name for the model; repeat to give it several (default: the context directory's name)")
+
+	return cmd
+}
+
+// Remove command
+func newRmCommand(dockerCli command.Cli) *cobra.Command {
+	var ignoreNotFound bool
+	var all bool
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "rm [model...]",
+		Short: "Remove one or more downloaded models",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			switch {
+			case all && len(args) > 0:
+				return fmt.Errorf("--all cannot be combined with model names")
+			case !all && len(args) == 0:
+				return fmt.Errorf("no model given; pass one or more models, or --all")
+			}
+			for _, modelName := range args {
+				if err := validateModelName(modelName); err != nil {
+					return err
+				}
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			if all {
+				installed, err := listLocalModels(ctx)
+				if err != nil {
+					return err
+				}
+				for _, m := range installed {
+					args = append(args, m.Name)
+				}
+				if len(args) == 0 {
+					_, _ = fmt.Fprintln(dockerCli.Out(), "No models to remove")
+					return nil
+				}
+			}
+
+			// Ask before deleting, unless told not to. Scripts naming models
+			// explicitly keep working without -f, but removing everything
+			// always needs a yes
+			if !force && (all || dockerCli.In().IsTerminal()) {
+				if !dockerCli.In().IsTerminal() {
+					return fmt.Errorf("refusing to remove all models without confirmation; pass -f to skip it")
+				}
+				msg := "WARNING! This will remove:\n"
+				for _, modelName := range args {
+					msg += "  - " + modelName + "\n"
+				}
+				ok, err := command.PromptForConfirmation(cmd.Context(), dockerCli.In(), dockerCli.Out(), msg+"Are you sure you want to continue?")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return nil
+				}
+			}
+
+			var removed, failed []string
+			for _, modelName := range args {
+				err := deleteModel(ctx, modelName)
+				switch {
+				case err == nil:
+					removed = append(removed, modelName)
+					_, _ = fmt.Fprintf(dockerCli.Out(), "Model %s removed successfully%s\n", modelName, quip(dockerCli, "and we didn't charge you a subscription for it"))
+				case errors.Is(err, errModelNotFound) && ignoreNotFound:
+				case errors.Is(err, errModelNotFound):
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Error: model %s not found; see `docker model list`\n", modelName)
+					failed = append(failed, modelName)
+				default:
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Error removing %s: %v\n", modelName, err)
+					failed = append(failed, modelName)
+				}
+			}
+			forgetUsage(removed)
+			forgetPulls(removed)
+
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to remove %d model(s): %s", len(failed), strings.Join(failed, ", "))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&ignoreNotFound, "ignore-not-found", false, "Don't treat a missing model as an error")
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Remove all downloaded models")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Do not prompt for confirmation")
+
+	return cmd
+}
+
+// Df command
+func newDfCommand(dockerCli command.Cli) *cobra.Command {
+	var output outputOptions
+
+	cmd := &cobra.Command{
+		Use:   "df",
+		Short: "Show disk usage of downloaded models",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := requireRunnerContainer(cmd); err != nil {
+				return err
+			}
+
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := output.validate(); err != nil {
+				return err
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			du, err := measureDiskUsage(ctx)
+			if err != nil {
+				return err
+			}
+
+			return render(dockerCli.Out(), output, du, du.Models, func() error {
+				w := tabwriter.NewWriter(dockerCli.Out(), 0, 0, 2, ' ', 0)
+				_, _ = fmt.Fprintln(w, "MODEL\tSIZE\tSHARED\tUNIQUE\tLAST USED")
+				for _, row := range du.Models {
+					_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", row.Name, row.Size, row.Shared, row.Unique, row.LastUsed)
+				}
+				_, _ = fmt.Fprintln(w)
+				_, _ = fmt.Fprintf(w, "Total:\t%s\n", du.Total)
+				_, _ = fmt.Fprintf(w, "Shared between models:\t%s\n", du.Shared)
+				_, _ = fmt.Fprintf(w, "Reclaimable:\t%s\n", du.Reclaimable)
+				return w.Flush()
+			})
+		},
+	}
+
+	addOutputFlags(cmd, &output)
+
+	return cmd
+}
+
+// Prune command
+func newPruneCommand(dockerCli command.Cli) *cobra.Command {
+	var all bool
+	var force bool
+	var unusedFor time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Remove models that haven't been used recently",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if unusedFor <= 0 {
+				return fmt.Errorf("--unused-for must be positive")
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			installed, err := listLocalModels(ctx)
+			if err != nil {
+				return err
+			}
+			usage, err := loadUsage()
+			if err != nil {
+				return err
+			}
+
+			var stale []localModel
+			for _, m := range installed {
+				if all || time.Since(lastUsed(usage, m)) > unusedFor {
+					stale = append(stale, m)
+				}
+			}
+			if len(stale) == 0 {
+				_, _ = fmt.Fprintln(dockerCli.Out(), "No models to prune")
+				return nil
+			}
+
+			if !force {
+				msg := fmt.Sprintf("WARNING! This will remove all models not used in the last %s:\n", unusedFor)
+				if all {
+					msg = "WARNING! This will remove all downloaded models:\n"
+				}
+				for _, m := range stale {
+					msg += "  - " + m.Name + "\n"
+				}
+				ok, err := command.PromptForConfirmation(cmd.Context(), dockerCli.In(), dockerCli.Out(), msg+"Are you sure you want to continue?")
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return nil
+				}
+			}
+
+			var reclaimed int64
+			var removed, failed []string
+			for _, m := range stale {
+				if err := deleteModel(ctx, m.Name); err != nil {
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Error removing %s: %v\n", m.Name, err)
+					failed = append(failed, m.Name)
+					continue
+				}
+				_, _ = fmt.Fprintf(dockerCli.Out(), "Deleted: %s\n", m.Name)
+				removed = append(removed, m.Name)
+				reclaimed += m.Size
+			}
+			forgetUsage(removed)
+			forgetPulls(removed)
+
+			// Models can share layers, so this is an upper bound
+			_, _ = fmt.Fprintf(dockerCli.Out(), "\nTotal reclaimed space: %s\n", formatBytes(reclaimed))
+
+			if len(failed) > 0 {
+				return fmt.Errorf("failed to remove %d model(s): %s", len(failed), strings.Join(failed, ", "))
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Remove all models, not just unused ones")
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Do not prompt for confirmation")
+	cmd.Flags().DurationVar(&unusedFor, "unused-for", DefaultPruneAge, "Remove models not run or pulled within this long")
+
+	return cmd
+}
+
+// keepAliveDefault returns the workstation-wide keep-alive duration, if
+// any, from MOCKER_KEEP_ALIVE, then the config file
+func keepAliveDefault() string {
+	return envOrDefault("MOCKER_KEEP_ALIVE", settings().KeepAlive)
+}
+
+// getModelSystem returns the built-in system prompt of a model, which may be empty
+func getModelSystem(ctx context.Context, modelName string) (string, error) {
+	show, err := showModel(ctx, modelName)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(show.System), nil
+}
+
+// appendSystem joins extra instructions onto a base system prompt
+func appendSystem(base, extra string) string {
+	switch {
+	case extra == "":
+		return base
+	case base == "":
+		return extra
+	default:
+		return base + "\n\n" + extra
+	}
+}
+
+// encodeImages reads image files and base64-encodes them for the API
+func encodeImages(paths []string) ([]string, error) {
+	var images []string
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read image: %w", err)
+		}
+		if kind := http.DetectContentType(data); !strings.HasPrefix(kind, "image/") {
+			return nil, fmt.Errorf("%s is not an image (detected %s)", path, kind)
+		}
+		images = append(images, base64.StdEncoding.EncodeToString(data))
+	}
+	return images, nil
+}
+
+// renderMarkdown reports whether responses should be rendered as Markdown:
+// when asked to, by flag or config, and only on a terminal
+func renderMarkdown(dockerCli command.Cli, flags *pflag.FlagSet, render bool) bool {
+	if !flags.Changed("render") {
+		render = settings().Render
+	}
+	return render && dockerCli.Out().IsTerminal()
+}
+
+// samplingFlags are the generation parameters run accepts
+type samplingFlags struct {
+	temperature float64
+	topP        float64
+	topK        int
+	seed        int
+	numCtx      int
+}
+
+// add registers the sampling flags
+func (o *samplingFlags) add(flags *pflag.FlagSet) {
+	flags.Float64Var(&o.temperature, "temperature", 0, "Sampling temperature; higher is more creative, 0 is deterministic")
+	flags.Float64Var(&o.topP, "top-p", 0, "Sample only from the most likely tokens making up this probability mass")
+	flags.IntVar(&o.topK, "top-k", 0, "Sample only from this many of the most likely tokens")
+	flags.IntVar(&o.seed, "seed", 0, "Random seed, for reproducible output")
+	flags.IntVar(&o.numCtx, "num-ctx", 0, "Context window size in tokens")
+}
+
+// validate rejects sampling values Ollama would misinterpret
+func (o *samplingFlags) validate() error {
+	switch {
+	case o.temperature < 0:
+		return fmt.Errorf("--temperature cannot be negative")
+	case o.topP < 0 || o.topP > 1:
+		return fmt.Errorf("--top-p must be between 0 and 1")
+	case o.topK < 0:
+		return fmt.Errorf("--top-k cannot be negative")
+	case o.numCtx < 0:
+		return fmt.Errorf("--num-ctx cannot be negative")
+	}
+	return nil
+}
+
+// options returns the sampling flags that were set as the options Ollama
+// accepts. Unset flags are left out so the model's own defaults apply
+func (o *samplingFlags) options(flags *pflag.FlagSet) map[string]any {
+	options := map[string]any{}
+	for flag, option := range map[string]struct {
+		name  string
+		value any
+	}{
+		"temperature": {"temperature", o.temperature},
+		"top-p":       {"top_p", o.topP},
+		"top-k":       {"top_k", o.topK},
+		"seed":        {"seed", o.seed},
+		"num-ctx":     {"num_ctx", o.numCtx},
+	} {
+		if flags.Changed(flag) {
+			options[option.name] = option.value
+		}
+	}
+	if len(options) == 0 {
+		return nil
+	}
+	return options
+}
+
+// promptArgs adds a prompt file and piped input to the prompt, in that
+// order: appended to the prompt given, or the whole prompt when there isn't
+// one. Stdin is only read when it's a pipe or a redirected file, or when the
+// prompt is "-"; other stdin, such as /dev/null or a socket under CI, may
+// never end
+func promptArgs(args []string, promptFile string, stdin *os.File) ([]string, error) {
+	readStdin := false
+	if len(args) > 0 && args[len(args)-1] == "-" {
+		args, readStdin = args[:len(args)-1], true
+	} else if info, err := stdin.Stat(); err == nil {
+		readStdin = info.Mode()&os.ModeNamedPipe != 0 || info.Mode().IsRegular()
+	}
+
+	var extra []string
+	if promptFile != "" {
+		data, err := os.ReadFile(promptFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt file: %w", err)
+		}
+		extra = append(extra, string(data))
+	}
+	if readStdin {
+		piped, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read prompt from stdin: %w", err)
+		}
+		extra = append(extra, string(piped))
+	}
+	for _, text := range extra {
+		if text = strings.TrimSpace(text); text == "" {
+			continue
+		}
+		if len(args) > 0 {
+			args[len(args)-1] += "\n\n" + text
+		} else {
+			args = []string{text}
+		}
+	}
+	return args, nil
+}
+
+// Run command
+func newRunCommand(dockerCli command.Cli) *cobra.Command {
+	var keepAlive string
+	var echoStatsJSON bool
+	var fallback string
+	var systemFromModel bool
+	var systemAppend string
+	var system string
+	var systemFile string
+	var stallTimeout time.Duration
+	var maxQueueWait time.Duration
+	var outputFile string
+	var quiet bool
+	var sampling samplingFlags
+	var jsonOutput bool
+	var schemaArg string
+	var imagePaths []string
+	var promptFile string
+	var appendOutput bool
+	var render bool
+	var statsFormat string
+
+	cmd := &cobra.Command{
+		Use:   "run [model] [prompt]",
+		Short: "Run a model interactively or with a prompt",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				if settings().DefaultModel == "" {
+					return fmt.Errorf("no model given; pass one or set a default with `docker model config set default_model <model>`")
+				}
+				args = []string{settings().DefaultModel}
+			}
+
+			modelName := args[0]
+			if err := validateModelName(modelName); err != nil {
+				return err
+			}
+			args = args[1:] // Remove model name from args
+
+			args, err := promptArgs(args, promptFile, os.Stdin)
+			if err != nil {
+				return err
+			}
+			if promptFile != "" && len(args) == 0 {
+				return fmt.Errorf("prompt file %s is empty", promptFile)
+			}
+			// Without a terminal there's nobody to chat with, as in CI
+			if len(args) == 0 && !dockerCli.In().IsTerminal() {
+				return fmt.Errorf("no prompt given and stdin is not a terminal; pass a prompt or pipe one in")
+			}
+
+			if fallback != "" {
+				if err := validateModelName(fallback); err != nil {
+					return err
+				}
+			}
+
+			if outputFile != "" && len(args) == 0 {
+				return fmt.Errorf("--output requires a prompt")
+			}
+			if maxQueueWait != 0 && len(args) == 0 {
+				return fmt.Errorf("--max-queue-wait requires a prompt")
+			}
+			if appendOutput && outputFile == "" {
+				return fmt.Errorf("--append requires --output")
+			}
+
+			if systemAppend != "" && !systemFromModel {
+				return fmt.Errorf("--system-append requires --system-from-model")
+			}
+			if systemFile != "" {
+				if system != "" {
+					return fmt.Errorf("--system and --system-file cannot be combined")
+				}
+				data, err := os.ReadFile(systemFile)
+				if err != nil {
+					return fmt.Errorf("failed to read system prompt: %w", err)
+				}
+				system = strings.TrimSpace(string(data))
+				if system == "" {
+					return fmt.Errorf("system prompt file %s is empty", systemFile)
+				}
+			}
+			if system != "" && systemFromModel {
+				return fmt.Errorf("--system replaces the model's system prompt and cannot be combined with --system-from-model")
+			}
+
+			if err := sampling.validate(); err != nil {
+				return err
+			}
+			if statsFormat != "" && !slices.Contains(statsFormats, statsFormat) {
+				return fmt.Errorf("invalid --stats format %q: must be one of %s", statsFormat, strings.Join(statsFormats, ", "))
+			}
+
+			var schema json.RawMessage
+			if schemaArg != "" {
+				var err error
+				if schema, err = loadSchema(schemaArg); err != nil {
+					return err
+				}
+			}
+			structured := jsonOutput || schema != nil
+			if structured && len(args) == 0 {
+				return fmt.Errorf("--json and --schema require a prompt")
+			}
+
+			// Images are sent with the prompt, so they need one too; Ollama's
+			// REPL can only open files inside the runner
+			if len(imagePaths) > 0 && len(args) == 0 {
+				return fmt.Errorf("--image requires a prompt")
+			}
+			images, err := encodeImages(imagePaths)
+			if err != nil {
+				return err
+			}
+			options := sampling.options(cmd.Flags())
+
+			// A chat handles Ctrl+C itself, stopping just the reply; a single
+			// prompt is cancelled outright
+			ctx := cmd.Context()
+			if len(args) > 0 {
+				var stop context.CancelFunc
+				ctx, stop = commandContext(cmd)
+				defer stop()
+			}
+
+			if err := ensureOllamaRunning(ctx); err != nil {
+				return err
+			}
+
+			// An explicit flag wins over the MOCKER_KEEP_ALIVE or configured default
+			if !cmd.Flags().Changed("keep-alive") {
+				keepAlive = keepAliveDefault()
+			}
+
+			// Load the primary model up front so a failure can still be
+			// recovered from before any output is produced
+			if fallback != "" {
+				if err := loadModel(ctx, modelName, keepAliveValue(keepAlive)); err != nil {
+					if !isRecoverableLoadError(err) {
+						return err
+					}
+					_, _ = fmt.Fprintf(dockerCli.Err(), "Model %s could not be loaded (%v), falling back to %s\n", modelName, err, fallback)
+					modelName = fallback
+				}
+			}
+
+			recordUsage(modelName)
+
+			if systemFromModel {
+				base, err := getModelSystem(ctx, modelName)
+				if err != nil {
+					return err
+				}
+				system = appendSystem(base, systemAppend)
+			}
+
+			if len(args) > 0 {
+				// Single prompt mode goes through the API, which streams
+				// without needing a TTY, so it also works in pipelines and CI
+				prompt := strings.Join(args, " ")
+				if showBanners(dockerCli) && !quiet && !structured {
+					_, _ = fmt.Fprintln(dockerCli.Out(), "Running with prompt (Ollama is doing all the work, but we'll take credit)...")
+				}
+
+				req := generateRequest{
+					Model:     modelName,
+					Prompt:    prompt,
+					System:    system,
+					KeepAlive: keepAliveValue(keepAlive),
+					Options:   options,
+					Images:    images,
+				}
+				switch {
+				case schema != nil:
+					req.Format = schema
+				case jsonOutput:
+					req.Format = "json"
+				}
+				return runPromptWithAPI(ctx, dockerCli, req, promptOptions{
+					stallTimeout:  stallTimeout,
+					maxQueueWait:  maxQueueWait,
+					echoStatsJSON: echoStatsJSON,
+					statsFormat:   statsFormat,
+					outputFile:    outputFile,
+					appendOutput:  appendOutput,
+					structured:    structured,
+					schema:        schema,
+					render:        !structured && renderMarkdown(dockerCli, cmd.Flags(), render),
+				})
+			} else {
+				// Interactive chat mode
+				_, _ = fmt.Fprintln(dockerCli.Out(), "Interactive chat mode started. Type /? for help, /bye or Ctrl+D to exit.")
+				if showBanners(dockerCli) {
+					_, _ = fmt.Fprintln(dockerCli.Out(), "(Ollama still does the thinking; we just keep the conversation going)")
+				}
+
+				return runChat(ctx, dockerCli, &chatSession{
+					Model:     modelName,
+					System:    system,
+					keepAlive: keepAliveValue(keepAlive),
+					options:   options,
+					render:    renderMarkdown(dockerCli, cmd.Flags(), render),
+					stats:     statsFormat,
+				})
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&keepAlive, "keep-alive", "", "How long the model stays loaded after the run (e.g. 30m, -1 to keep it forever, 0 to unload immediately); overrides MOCKER_KEEP_ALIVE and the keep_alive_default setting")
+	// Accept --keepalive too, matching `ollama run`
+	cmd.Flags().SetNormalizeFunc(func(f *pflag.FlagSet, name string) pflag.NormalizedName {
+		if name == "keepalive" {
+			name = "keep-alive"
+		}
+		return pflag.NormalizedName(name)
+	})
+	cmd.Flags().StringVar(&fallback, "fallback", "", "Model to use instead if the primary model is missing or cannot be loaded")
+	cmd.Flags().StringVar(&system, "system", "", "System prompt to use instead of the model's own")
+	cmd.Flags().StringVar(&systemFile, "system-file", "", "Read the system prompt from a file")
+	cmd.Flags().BoolVar(&systemFromModel, "system-from-model", false, "Start from the model's built-in system prompt")
+	cmd.Flags().StringVar(&systemAppend, "system-append", "", "Text to add to the model's built-in system prompt (requires --system-from-model)")
+	cmd.Flags().DurationVar(&stallTimeout, "stall-timeout", 0, "Cancel generation if no new token arrives within this long after the first (e.g. 30s)")
+	cmd.Flags().DurationVar(&maxQueueWait, "max-queue-wait", 0, "Fail with \"runner busy\" if the runner hasn't started answering within this long (e.g. 30s)")
+	cmd.Flags().StringVarP(&outputFile, "output", "o", "", "Also write the model's response to this file")
+	cmd.Flags().BoolVar(&appendOutput, "append", false, "Append to the --output file instead of replacing it")
+	cmd.Flags().StringVar(&promptFile, "prompt-file", "", "Read the prompt, or the text after it, from a file")
+	cmd.Flags().BoolVarP(&quiet, "quiet", "q", false, "Don't print the banner before the response")
+	cmd.Flags().BoolVar(&echoStatsJSON, "echo-stats-json", false, "After streaming, print generation stats as a JSON line to stderr")
+	cmd.Flags().StringVar(&statsFormat, "stats", "", "After the response, report token counts, throughput and latency on stderr (text or json)")
+	cmd.Flags().Lookup("stats").NoOptDefVal = "text"
+	sampling.add(cmd.Flags())
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Have the model answer in JSON, and print only that JSON")
+	cmd.Flags().BoolVar(&render, "render", false, "Render the response as Markdown on a terminal (default from the render setting)")
+	cmd.Flags().StringArrayVar(&imagePaths, "image", nil, "Image to send with the prompt, for vision models (repeatable)")
+	cmd.Flags().StringVar(&schemaArg, "schema", "", "Constrain the answer to a JSON schema, given inline or as a file path (implies --json)")
+
+	return cmd
+}
+
+// promptOptions controls how a single prompt's response is delivered
+type promptOptions struct {
+	stallTimeout  time.Duration
+	maxQueueWait  time.Duration // how long the runner may take to start answering
+	echoStatsJSON bool
+	statsFormat   string // report a summary of the stats, as text or json
+	outputFile    string
+	appendOutput  bool
+	structured    bool            // the response is JSON, validated before it's printed
+	schema        json.RawMessage // schema the JSON must match, if any
+	render        bool            // render the response as Markdown on stdout
+}
+
+// runPromptWithAPI streams a single prompt's response to stdout through the
+// Ollama API, optionally copying it to a file and echoing the final stats
+// as JSON to stderr
+func runPromptWithAPI(ctx context.Context, dockerCli command.Cli, req generateRequest, opts promptOptions) error {
+	var w io.Writer = dockerCli.Out()
+	if opts.render {
+		// Only the terminal gets formatting; an output file keeps the Markdown
+		md := newMarkdownWriter(dockerCli.Out())
+		defer func() { _ = md.Flush() }()
+		w = md
+	}
+	if opts.outputFile != "" {
+		flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if opts.appendOutput {
+			flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		f, err := os.OpenFile(opts.outputFile, flags, 0o644)
+		if err != nil {
+			return fmt.Errorf("failed to create output file: %w", err)
+		}
+		defer f.Close()
+		w = io.MultiWriter(w, f)
+	}
+
+	if opts.structured {
+		// Hold the response back until it's known to be valid, so only
+		// well-formed JSON ever reaches a pipe
+		var buf bytes.Buffer
+		stats, err := generateWithQueueWait(ctx, req, &buf, opts.stallTimeout, opts.maxQueueWait)
+		if err != nil {
+			return err
+		}
+		if err := validateJSON(buf.Bytes(), opts.schema); err != nil {
+			return err
+		}
+		_, _ = fmt.Fprintln(w, strings.TrimSpace(buf.String()))
+		if err := printStats(dockerCli.Err(), stats, opts.statsFormat); err != nil {
+			return err
+		}
+		return echoStats(dockerCli, stats, opts.echoStatsJSON)
+	}
+
+	stats, err := generateWithQueueWait(ctx, req, w, opts.stallTimeout, opts.maxQueueWait)
+	if err != nil {
+		return err
+	}
+	// End the file with a newline too, so appended responses stay apart
+	_, _ = fmt.Fprintln(w)
+
+	if err := printStats(dockerCli.Err(), stats, opts.statsFormat); err != nil {
+		return err
+	}
+	return echoStats(dockerCli, stats, opts.echoStatsJSON)
+}
+
+// echoStats prints generation stats as a JSON line to stderr, if asked to
+func echoStats(dockerCli command.Cli, stats *generateStats, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+	data, err := json.Marshal(stats)
+	if err != nil {
+		return err
+	}
+	_, _ = fmt.Fprintln(dockerCli.Err(), string(data))
+	return nil
+}
+
+// statsFormats are the accepted values of run --stats
+var statsFormats = []string{"text", "json"}
+
+// runStats summarizes a generation's metrics for run --stats
+type runStats struct {
+	PromptTokens          int     `json:"prompt_tokens"`
+	CompletionTokens      int     `json:"completion_tokens"`
+	PromptTokensPerSecond float64 `json:"prompt_tokens_per_second"`
+	TokensPerSecond       float64 `json:"tokens_per_second"`
+	LoadMs                int64   `json:"load_ms"`
+	TotalMs               int64   `json:"total_ms"`
+}
+
+// newRunStats derives token counts, throughput and latency from the
+// metrics Ollama reports
+func newRunStats(stats *generateStats) runStats {
+	rate := func(tokens int, ns int64) float64 {
+		if ns <= 0 {
+			return 0
+		}
+		return math.Round(float64(tokens)/time.Duration(ns).Seconds()*100) / 100
+	}
+	return runStats{
+		PromptTokens:          stats.PromptEvalCount,
+		CompletionTokens:      stats.EvalCount,
+		PromptTokensPerSecond: rate(stats.PromptEvalCount, stats.PromptEvalDuration),
+		TokensPerSecond:       rate(stats.EvalCount, stats.EvalDuration),
+		LoadMs:                time.Duration(stats.LoadDuration).Milliseconds(),
+		TotalMs:               time.Duration(stats.TotalDuration).Milliseconds(),
+	}
+}
+
+// printStats writes a summary of a generation's stats to w in the given
+// format, or nothing if no format is given
+func printStats(w io.Writer, stats *generateStats, format string) error {
+	if format == "" || stats == nil {
+		return nil
+	}
+	s := newRunStats(stats)
+	if format == "json" {
+		return json.NewEncoder(w).Encode(s)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(tw, "Prompt tokens:\t%d (%.1f tokens/s)\n", s.PromptTokens, s.PromptTokensPerSecond)
+	_, _ = fmt.Fprintf(tw, "Completion tokens:\t%d (%.1f tokens/s)\n", s.CompletionTokens, s.TokensPerSecond)
+	_, _ = fmt.Fprintf(tw, "Load time:\t%s\n", time.Duration(stats.LoadDuration).Round(time.Millisecond))
+	_, _ = fmt.Fprintf(tw, "Total time:\t%s\n", time.Duration(stats.TotalDuration).Round(time.Millisecond))
+	return tw.Flush()
+}
+
+// resolveModel expands a model name to its fully-qualified reference and
+// pins it to a digest, preferring the locally installed copy
+func resolveModel(ctx context.Context, name string) (string, error) {
+	ref := parseModelReference(name)
+
+	// Only look locally if the runner is already up; resolving a name
+	// shouldn't start anything
+	if isOllamaRunning() {
+		models, err := listLocalModels(ctx)
+		if err == nil {
+			for _, m := range models {
+				if parseModelReference(m.Name) == ref {
+					return fmt.Sprintf("%s@sha256:%s", ref, m.Digest), nil
+				}
+			}
+		}
+	}
+
+	digest, err := remoteDigest(ctx, name)
+	if err != nil {
+		return "", fmt.Errorf("cannot resolve %s: %w", name, err)
+	}
+	return fmt.Sprintf("%s@sha256:%s", ref, digest), nil
+}
+
+// Which command
+func newWhichCommand(dockerCli command.Cli) *cobra.Command {
+	return &cobra.Command{
+		Use:   "which [model]",
+		Short: "Print the fully-resolved reference for a model",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := commandContext(cmd)
+			defer stop()
+
+			if err := validateModelName(args[0]); err != nil {
+				return err
+			}
+			resolved, err := resolveModel(ctx, args[0])
+			if err != nil {
+				return err
+			}
+
+			_, _ = fmt.Fprintln(dockerCli.Out(), resolved)
+			return nil
 		},
 	}
 }
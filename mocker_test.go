@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/cli/flags"
+	"github.com/docker/docker/errdefs"
+)
+
+// fakeOllama serves recorded Ollama API responses from testdata: the model
+// list from tags, /api/show from show-<model>.json, the model's name
+// without its tag, /api/pull from pull.jsonl and /api/generate from
+// generate-json.jsonl
+func fakeOllama(t *testing.T, tags string) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/tags", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", tags))
+	})
+	mux.HandleFunc("POST /api/show", func(w http.ResponseWriter, r *http.Request) {
+		var req struct {
+			Model string `json:"model"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		name, _, _ := strings.Cut(req.Model, ":")
+		data, err := os.ReadFile(filepath.Join("testdata", "show-"+name+".json"))
+		if err != nil {
+			http.Error(w, `{"error":"model not found"}`, http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write(data)
+	})
+	mux.HandleFunc("POST /api/pull", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", "pull.jsonl"))
+	})
+	mux.HandleFunc("POST /api/generate", func(w http.ResponseWriter, r *http.Request) {
+		http.ServeFile(w, r, filepath.Join("testdata", "generate-json.jsonl"))
+	})
+	serveOllama(t, mux)
+}
+
+// serveOllama has handler answer for the runner's API for the rest of the
+// test
+func serveOllama(t *testing.T, handler http.Handler) {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+
+	// The runner's API is reached on localhost at its published port
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatal(err)
+	}
+	portFlag = port
+	t.Cleanup(func() { portFlag = 0 })
+}
+
+// runningRunner is the canned state of a default runner that is up
+func runningRunner(labels map[string]string) map[string]containerState {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	labels[RunnerLabel] = DefaultRunnerName
+	return map[string]containerState{
+		containerName(): {Running: true, Status: "running", Health: "healthy", Image: OllamaImage, Labels: labels},
+	}
+}
+
+func TestListModels(t *testing.T) {
+	tests := []struct {
+		name string
+		tags string
+		want []modelRow
+	}{
+		{
+			name: "models",
+			tags: "tags.json",
+			want: []modelRow{
+				{Name: "llama3.2:latest", Parameters: "3.2B", Quantization: "Q4_K_M", Architecture: "llama", ID: "a80c4f17acd5", Size: "2.0 GB"},
+				{Name: "nomic-embed-text:latest", Parameters: "137M", Quantization: "F16", Architecture: "nomic-bert", ID: "0a109f422b47", Size: "274.3 MB"},
+			},
+		},
+		{
+			name: "empty",
+			tags: "tags-empty.json",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDocker(t, runningRunner(nil))
+			fakeOllama(t, tt.tags)
+
+			ctx := context.Background()
+			if err := ensureOllamaRunning(ctx); err != nil {
+				t.Fatalf("ensureOllamaRunning: %v", err)
+			}
+			// A runner that's up as requested is used as it is
+			for _, method := range []string{"Create", "Remove", "Start"} {
+				if fake.called(method) {
+					t.Errorf("%s was called on a running runner; calls: %q", method, fake.calls)
+				}
+			}
+
+			rows, err := listModels(ctx)
+			if err != nil {
+				t.Fatalf("listModels: %v", err)
+			}
+			if len(rows) != len(tt.want) {
+				t.Fatalf("got %d rows, want %d: %+v", len(rows), len(tt.want), rows)
+			}
+			for i, row := range rows {
+				// How long ago a model was created depends on when the test runs
+				row.Created = ""
+				if row != tt.want[i] {
+					t.Errorf("row %d = %+v, want %+v", i, row, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRunJSONStartingRunner(t *testing.T) {
+	fake := useFakeDocker(t, nil)
+	fakeOllama(t, "tags.json")
+
+	// Progress about starting the runner goes to stderr, keeping stdout,
+	// where the CLI writes too, to just the JSON
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() { os.Stdout = stdout }()
+	output := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(r)
+		output <- data
+	}()
+
+	cli, err := command.NewDockerCli(command.WithOutputStream(w), command.WithErrorStream(io.Discard), command.WithInputStream(io.NopCloser(strings.NewReader(""))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Telling podman from docker asks the daemon, which there's none of
+	if err := cli.Initialize(flags.NewClientOptions()); err != nil {
+		t.Fatal(err)
+	}
+	// Waiting for the runner reports to the invoking CLI
+	real := dockerCLI
+	dockerCLI = cli
+	defer func() { dockerCLI = real }()
+
+	cmd := newRunCommand(cli)
+	cmd.SetArgs([]string{"--json", "llama3.2", "What is 2+2? Answer in JSON."})
+	cmd.SilenceUsage = true
+	err = cmd.Execute()
+	os.Stdout = stdout
+	w.Close()
+	data := <-output
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	if !fake.called("Create") {
+		t.Fatalf("the runner was not started; calls: %q", fake.calls)
+	}
+	var answer map[string]any
+	if err := json.Unmarshal(data, &answer); err != nil {
+		t.Errorf("stdout is not JSON: %v\n%s", err, data)
+	}
+}
+
+func TestEnsureOllamaRunningKeepsEnv(t *testing.T) {
+	tests := []struct {
+		name string
+		env  []string
+	}{
+		{name: "no env requested"},
+		{name: "same env requested", env: []string{"OLLAMA_NUM_PARALLEL=4"}},
+		// Another environment is only a warning without --force-recreate
+		{name: "other env requested", env: []string{"OLLAMA_NUM_PARALLEL=8"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDocker(t, runningRunner(map[string]string{
+				EnvLabel:    runnerEnvLabel([]string{"OLLAMA_NUM_PARALLEL=4"}),
+				MemoryLabel: "8g",
+			}))
+			envFlag = tt.env
+			t.Cleanup(func() { envFlag = nil })
+
+			if err := ensureOllamaRunning(context.Background()); err != nil {
+				t.Fatalf("ensureOllamaRunning: %v", err)
+			}
+			for _, method := range []string{"Create", "Remove"} {
+				if fake.called(method) {
+					t.Errorf("%s was called, recreating the runner; calls: %q", method, fake.calls)
+				}
+			}
+		})
+	}
+}
+
+func TestPromptArgs(t *testing.T) {
+	tests := []struct {
+		name  string
+		args  []string
+		piped string // piped to stdin when set, /dev/null otherwise
+		want  []string
+	}{
+		{name: "prompt and pipe", args: []string{"Explain this error"}, piped: "panic: oops\n", want: []string{"Explain this error\n\npanic: oops"}},
+		{name: "prompt without pipe", args: []string{"Explain this error"}, want: []string{"Explain this error"}},
+		{name: "pipe only", piped: "What is 2+2?\n", want: []string{"What is 2+2?"}},
+		{name: "dash reads stdin", args: []string{"-"}, piped: "What is 2+2?", want: []string{"What is 2+2?"}},
+		{name: "nothing", want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdin *os.File
+			if tt.piped != "" {
+				r, w, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				go func() {
+					_, _ = io.WriteString(w, tt.piped)
+					w.Close()
+				}()
+				stdin = r
+			} else {
+				f, err := os.Open(os.DevNull)
+				if err != nil {
+					t.Fatal(err)
+				}
+				stdin = f
+			}
+			defer stdin.Close()
+
+			got, err := promptArgs(tt.args, "", stdin)
+			if err != nil {
+				t.Fatalf("promptArgs: %v", err)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCreateRunnerRemovesOnlyItsOwn(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string // of the container already named like the runner
+		err        error
+		wantRemove bool
+	}{
+		{name: "runner failed to start", labels: map[string]string{RunnerLabel: DefaultRunnerName}, err: errors.New("failed to set up container networking"), wantRemove: true},
+		{name: "name taken", labels: map[string]string{RunnerLabel: DefaultRunnerName}, err: errdefs.Conflict(errors.New("container name already in use"))},
+		{name: "not a runner", labels: map[string]string{}, err: errors.New("failed to pull ollama/ollama")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := useFakeDocker(t, map[string]containerState{
+				containerName(): {Labels: tt.labels},
+			})
+			fake.createErr = tt.err
+
+			spec := containerSpec{Name: containerName(), Image: OllamaImage, Labels: map[string]string{}, Ports: map[int]int{}}
+			if err := createRunner(context.Background(), spec); !errors.Is(err, tt.err) {
+				t.Fatalf("createRunner = %v, want %v", err, tt.err)
+			}
+			if removed := fake.called("Remove"); removed != tt.wantRemove {
+				t.Errorf("removed = %t, want %t; calls: %q", removed, tt.wantRemove, fake.calls)
+			}
+		})
+	}
+}
+
+func TestWhichRejectsInvalidNames(t *testing.T) {
+	for _, name := range []string{"", "llama 3.2", "llama3.2;rm -rf"} {
+		t.Run(name, func(t *testing.T) {
+			fake := useFakeDocker(t, runningRunner(nil))
+			cli, err := command.NewDockerCli(command.WithOutputStream(io.Discard), command.WithErrorStream(io.Discard))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			cmd := newWhichCommand(cli)
+			cmd.SetArgs([]string{name})
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+			if err := cmd.Execute(); err == nil || !strings.Contains(err.Error(), "invalid model name") {
+				t.Errorf("which %q = %v, want an invalid model name error", name, err)
+			}
+			if len(fake.calls) > 0 {
+				t.Errorf("the runner was asked about an invalid name; calls: %q", fake.calls)
+			}
+		})
+	}
+}
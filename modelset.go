@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// modelSpec is a model in a model set, optionally pinned to a digest
+type modelSpec struct {
+	Name   string `yaml:"name"`
+	Digest string `yaml:"digest,omitempty"`
+}
+
+// UnmarshalYAML accepts a bare model name as well as a mapping
+func (s *modelSpec) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		s.Name = node.Value
+		return nil
+	}
+	type plain modelSpec
+	return node.Decode((*plain)(s))
+}
+
+// modelSet is a declarative list of the models an environment needs:
+//
+//	models:
+//	  - gemma3:1b
+//	  - name: llama3.2
+//	    digest: a80c4f17acd5
+type modelSet struct {
+	Models []modelSpec `yaml:"models"`
+}
+
+// isModelSet reports whether a --file is a YAML model set rather than a
+// plain list of names
+func isModelSet(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// readModelSet reads and validates a model set file
+func readModelSet(path string) ([]modelSpec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model set: %w", err)
+	}
+
+	var set modelSet
+	if err := yaml.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, spec := range set.Models {
+		if spec.Name == "" {
+			return nil, fmt.Errorf("invalid model set %s: every model needs a name", path)
+		}
+		if strings.Contains(spec.Name, "@") {
+			return nil, fmt.Errorf("invalid model set %s: pin %s with a digest field rather than @", path, spec.Name)
+		}
+	}
+	return set.Models, nil
+}
+
+// digestMatches reports whether an installed model's digest matches a
+// pinned one. Pins may use the sha256: prefix and may be shortened to the
+// ID `list` shows
+func digestMatches(installed, pinned string) bool {
+	pinned = strings.TrimPrefix(pinned, "sha256:")
+	return len(pinned) >= 12 && strings.HasPrefix(installed, pinned)
+}
+
+// installedDigests returns the digest of every installed model
+func installedDigests(ctx context.Context) (map[modelReference]string, error) {
+	installed, err := listLocalModels(ctx)
+	if err != nil {
+		return nil, err
+	}
+	digests := make(map[modelReference]string, len(installed))
+	for _, m := range installed {
+		digests[parseModelReference(m.Name)] = m.Digest
+	}
+	return digests, nil
+}
+
+// planModelSet compares a model set with what's installed, reporting each
+// model's state to out, and returns the models that need pulling: missing
+// ones, and pinned ones whose installed digest has drifted
+func planModelSet(ctx context.Context, out io.Writer, specs []modelSpec) ([]modelSpec, error) {
+	digests, err := installedDigests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var pull []modelSpec
+	for _, spec := range specs {
+		digest, ok := digests[parseModelReference(spec.Name)]
+		switch {
+		case !ok:
+			_, _ = fmt.Fprintf(out, "%s: missing\n", spec.Name)
+			pull = append(pull, spec)
+		case spec.Digest != "" && !digestMatches(digest, spec.Digest):
+			_, _ = fmt.Fprintf(out, "%s: drifted (installed %.12s, want %s)\n", spec.Name, digest, strings.TrimPrefix(spec.Digest, "sha256:"))
+			pull = append(pull, spec)
+		default:
+			_, _ = fmt.Fprintf(out, "%s: up to date\n", spec.Name)
+		}
+	}
+	return pull, nil
+}
+
+// verifyModelSet returns the pinned models whose installed digest still
+// doesn't match after pulling, which means the registry now serves a
+// different version under that tag
+func verifyModelSet(ctx context.Context, specs []modelSpec) ([]string, error) {
+	digests, err := installedDigests(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var drifted []string
+	for _, spec := range specs {
+		digest, ok := digests[parseModelReference(spec.Name)]
+		if ok && spec.Digest != "" && !digestMatches(digest, spec.Digest) {
+			drifted = append(drifted, fmt.Sprintf("%s (registry has %.12s, want %s)", spec.Name, digest, strings.TrimPrefix(spec.Digest, "sha256:")))
+		}
+	}
+	return drifted, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/docker/cli/cli/command"
+)
+
+const (
+	// OCIScheme marks a model reference as an OCI artifact in a container
+	// registry, which mocker pulls itself rather than through Ollama
+	OCIScheme = "oci://"
+	// OCIManifestMediaType is the media type of OCI image manifests
+	OCIManifestMediaType = "application/vnd.oci.image.manifest.v1+json"
+	// OllamaArtifactType identifies an OCI artifact holding an Ollama model
+	OllamaArtifactType = "application/vnd.ollama.model"
+	// OllamaLayerMediaTypePrefix starts the media type of every Ollama layer
+	OllamaLayerMediaTypePrefix = "application/vnd.ollama.image."
+)
+
+// pushToRegistry uploads the installed model src to dst in a registry other
+// than ollama.com, as an Ollama manifest or, with oci, as an OCI artifact
+// that any OCI registry accepts
+func pushToRegistry(ctx context.Context, dockerCli command.Cli, src, dst modelReference, insecure, oci bool, progress *pullProgress) error {
+	raw, err := localManifest(src)
+	if err != nil {
+		return err
+	}
+
+	mediaType := ManifestMediaType
+	if oci {
+		if raw, err = toOCIManifest(raw); err != nil {
+			return err
+		}
+		mediaType = OCIManifestMediaType
+	}
+
+	auth, err := dockerCli.ConfigFile().GetAuthConfig(dst.Registry)
+	if err != nil {
+		return err
+	}
+	return newRegistrySession(auth, dst, insecure).pushManifest(ctx, raw, mediaType, progress)
+}
+
+// toOCIManifest rewrites an Ollama manifest as an OCI artifact manifest.
+// The blobs are unchanged, so a model exported this way shares its layers
+// with the original
+func toOCIManifest(raw []byte) ([]byte, error) {
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	m.SchemaVersion = 2
+	m.MediaType = OCIManifestMediaType
+	m.ArtifactType = OllamaArtifactType
+	return json.Marshal(m)
+}
+
+// fromOCIManifest rewrites an OCI artifact manifest as the manifest Ollama
+// expects, rejecting artifacts that don't hold an Ollama model
+func fromOCIManifest(raw []byte) (manifest, []byte, error) {
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return m, nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	if len(m.Layers) == 0 {
+		return m, nil, fmt.Errorf("artifact has no layers")
+	}
+	for _, layer := range m.Layers {
+		if !strings.HasPrefix(layer.MediaType, OllamaLayerMediaTypePrefix) {
+			return m, nil, fmt.Errorf("artifact is not an Ollama model: layer %s has media type %q", layer.Digest, layer.MediaType)
+		}
+	}
+
+	m.MediaType = ManifestMediaType
+	m.ArtifactType = ""
+	converted, err := json.Marshal(m)
+	return m, converted, err
+}
+
+// pullOCIArtifact downloads a model stored as an OCI artifact straight into
+// the runner's volume, authenticating with the registry's stored credentials.
+// Ollama then finds it like any other installed model. It returns the digest
+// of the artifact's manifest
+func pullOCIArtifact(ctx context.Context, dockerCli command.Cli, name string, progress *pullProgress) (string, error) {
+	ref := parseModelReference(name)
+	if strings.Count(ref.Repository, "/") != 1 {
+		return "", fmt.Errorf("invalid OCI model %s: Ollama needs a namespace/model repository, such as %s/team/model", name, ref.Registry)
+	}
+
+	auth, err := dockerCli.ConfigFile().GetAuthConfig(ref.Registry)
+	if err != nil {
+		return "", err
+	}
+	s := newRegistrySession(auth, ref, false)
+	if err := s.authenticate(ctx, "pull"); err != nil {
+		return "", err
+	}
+
+	progress.status("pulling manifest")
+	raw, err := s.fetchManifest(ctx, ref.Tag)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(raw)
+
+	m, converted, err := fromOCIManifest(raw)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", ref, err)
+	}
+
+	present, err := blobSizes()
+	if err != nil {
+		return "", err
+	}
+	for _, layer := range append(m.Layers, m.Config) {
+		if present[layer.Digest] == layer.Size {
+			progress.update(layer.Digest, float64(layer.Size), float64(layer.Size))
+			continue
+		}
+		if err := s.pullBlob(ctx, layer, progress); err != nil {
+			return "", err
+		}
+	}
+
+	progress.status("writing manifest")
+	if err := writeManifest(ref, converted); err != nil {
+		return "", err
+	}
+	progress.status("success")
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// pullBlob streams a blob from the registry into the runner's blob store,
+// verifying its digest before putting it in place
+func (p *registrySession) pullBlob(ctx context.Context, layer manifestLayer, progress *pullProgress) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url("blobs/"+layer.Digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.do(uploadClient, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry returned %s for blob %s", resp.Status, layer.Digest)
+	}
+
+	return writeBlob(ctx, layer, resp.Body, progress)
+}
+
+// writeBlob streams a blob into the runner's blob store, verifying its
+// digest before putting it in place so a bad or partial copy is never used
+func writeBlob(ctx context.Context, layer manifestLayer, r io.Reader, progress *pullProgress) error {
+	final := ModelsDir + "/blobs/" + strings.Replace(layer.Digest, ":", "-", 1)
+	partial := final + "-partial"
+	if _, err := runInOllama("mkdir", "-p", path.Dir(final)); err != nil {
+		return err
+	}
+
+	hash := sha256.New()
+	body := &countingReader{r: io.TeeReader(r, hash), fn: func(n int64) {
+		progress.update(layer.Digest, float64(n), float64(layer.Size))
+	}}
+	if err := docker.CopyFile(ctx, containerName(), partial, body, layer.Size); err != nil {
+		_, _ = runInOllama("rm", "-f", partial)
+		return fmt.Errorf("failed to write blob %s: %w", layer.Digest, err)
+	}
+
+	if digest := "sha256:" + hex.EncodeToString(hash.Sum(nil)); digest != layer.Digest {
+		_, _ = runInOllama("rm", "-f", partial)
+		return fmt.Errorf("blob %s failed verification: got %s", layer.Digest, digest)
+	}
+	_, err := runInOllama("mv", partial, final)
+	return err
+}
+
+// writeManifest installs a model's manifest in the runner, which makes it
+// visible to Ollama. It isn't cancellable, since a model whose blobs are
+// all in place is better installed than half-written
+func writeManifest(ref modelReference, raw []byte) error {
+	dst := fmt.Sprintf("%s/manifests/%s/%s/%s", ModelsDir, ref.Registry, ref.Repository, ref.Tag)
+	if _, err := runInOllama("mkdir", "-p", path.Dir(dst)); err != nil {
+		return err
+	}
+	if err := docker.CopyFile(context.Background(), containerName(), dst, bytes.NewReader(raw), int64(len(raw))); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
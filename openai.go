@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// openAIModels returns the models a backend serves, from its
+// OpenAI-compatible API
+func openAIModels(ctx context.Context) ([]localModel, error) {
+	resp, err := apiGet(ctx, "/v1/models")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, openAIError(resp)
+	}
+
+	var list struct {
+		Data []struct {
+			ID      string `json:"id"`
+			Created int64  `json:"created"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode model list: %w", err)
+	}
+	models := make([]localModel, len(list.Data))
+	for i, m := range list.Data {
+		models[i] = localModel{Name: m.ID, ModifiedAt: time.Unix(m.Created, 0)}
+	}
+	return models, nil
+}
+
+// openAIEmbed returns an embedding vector for each input, in order, from
+// the OpenAI-compatible embeddings API
+func openAIEmbed(ctx context.Context, model string, inputs []string) ([][]float64, error) {
+	body, err := json.Marshal(map[string]any{"model": model, "input": inputs})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := apiPost(ctx, "/v1/embeddings", body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, openAIError(resp)
+	}
+
+	var result struct {
+		Data []struct {
+			Index     int       `json:"index"`
+			Embedding []float64 `json:"embedding"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode embeddings: %w", err)
+	}
+	if len(result.Data) != len(inputs) {
+		return nil, fmt.Errorf("%s returned %d embeddings for %d inputs", activeBackend().Name(), len(result.Data), len(inputs))
+	}
+	embeddings := make([][]float64, len(inputs))
+	for _, d := range result.Data {
+		if d.Index < 0 || d.Index >= len(inputs) {
+			return nil, fmt.Errorf("%s returned an embedding for input %d of %d", activeBackend().Name(), d.Index, len(inputs))
+		}
+		embeddings[d.Index] = d.Embedding
+	}
+	return embeddings, nil
+}
+
+// openAIGenerate streams a completion for a single prompt to w, as a chat
+// of one message so the model's chat template is applied
+func openAIGenerate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	var messages []chatMessage
+	if req.System != "" {
+		messages = append(messages, chatMessage{Role: "system", Content: req.System})
+	}
+	messages = append(messages, chatMessage{Role: "user", Content: req.Prompt, Images: req.Images})
+	_, stats, err := openAIChat(ctx, req.Model, messages, req.Options, req.Format, w, stallTimeout)
+	return stats, err
+}
+
+// openAIMessage is a message in an OpenAI chat completion request. Content
+// is a string, or a list of parts when there are images
+type openAIMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+// openAIMessages converts a conversation to OpenAI's form, sending images
+// as data URLs
+func openAIMessages(messages []chatMessage) []openAIMessage {
+	converted := make([]openAIMessage, len(messages))
+	for i, m := range messages {
+		converted[i] = openAIMessage{Role: m.Role, Content: m.Content}
+		if len(m.Images) == 0 {
+			continue
+		}
+		parts := []map[string]any{{"type": "text", "text": m.Content}}
+		for _, image := range m.Images {
+			head, _ := base64.StdEncoding.DecodeString(image[:min(len(image), 64)])
+			url := "data:" + http.DetectContentType(head) + ";base64," + image
+			parts = append(parts, map[string]any{"type": "image_url", "image_url": map[string]string{"url": url}})
+		}
+		converted[i].Content = parts
+	}
+	return converted
+}
+
+// openAIChunk is a single streamed chunk of a chat completion
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// openAIChat streams a chat completion to w from the OpenAI-compatible API,
+// returning the reply with stats measured along the way. Sampling options
+// are given in Ollama's names, and format is "json" or a JSON schema. If
+// stallTimeout is set, generation is cancelled when no new token arrives
+// within that window after the first one
+func openAIChat(ctx context.Context, model string, messages []chatMessage, options map[string]any, format any, w io.Writer, stallTimeout time.Duration) (chatMessage, *generateStats, error) {
+	reply := chatMessage{Role: "assistant"}
+	req := map[string]any{
+		"model":          model,
+		"messages":       openAIMessages(messages),
+		"stream":         true,
+		"stream_options": map[string]bool{"include_usage": true},
+	}
+	for name, value := range options {
+		switch name {
+		case "temperature", "top_p", "top_k", "seed", "stop":
+			req[name] = value
+		case "num_predict":
+			req["max_tokens"] = value
+		}
+	}
+	switch format := format.(type) {
+	case nil:
+	case string:
+		req["response_format"] = map[string]string{"type": "json_object"}
+	default:
+		req["response_format"] = map[string]any{"type": "json_schema", "json_schema": map[string]any{"name": "response", "schema": format}}
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return reply, nil, err
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := apiPost(ctx, "/v1/chat/completions", body)
+	if err != nil {
+		if parent.Err() != nil {
+			return reply, nil, parent.Err()
+		}
+		return reply, nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return reply, nil, openAIError(resp)
+	}
+
+	var stalled atomic.Bool
+	var stallTimer *time.Timer
+	defer func() {
+		if stallTimer != nil {
+			stallTimer.Stop()
+		}
+	}()
+
+	var stats generateStats
+	var firstToken time.Time
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			stats.TotalDuration = int64(time.Since(start))
+			if !firstToken.IsZero() {
+				stats.PromptEvalDuration = int64(firstToken.Sub(start))
+				stats.EvalDuration = int64(time.Since(firstToken))
+			}
+			return reply, &stats, nil
+		}
+
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return reply, nil, fmt.Errorf("failed to decode response: %w", err)
+		}
+		if chunk.Usage != nil {
+			stats.PromptEvalCount = chunk.Usage.PromptTokens
+			stats.EvalCount = chunk.Usage.CompletionTokens
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content == "" {
+				continue
+			}
+			if firstToken.IsZero() {
+				firstToken = time.Now()
+			}
+			reply.Content += choice.Delta.Content
+			if _, err := io.WriteString(w, choice.Delta.Content); err != nil {
+				return reply, nil, err
+			}
+			if stallTimeout > 0 {
+				if stallTimer == nil {
+					stallTimer = time.AfterFunc(stallTimeout, func() {
+						stalled.Store(true)
+						cancel()
+					})
+				} else {
+					stallTimer.Reset(stallTimeout)
+				}
+			}
+		}
+	}
+
+	if stalled.Load() {
+		return reply, nil, fmt.Errorf("generation stalled: no new token for %s", stallTimeout)
+	}
+	if parent.Err() != nil {
+		return reply, nil, parent.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return reply, nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return reply, nil, fmt.Errorf("response ended before generation finished")
+}
+
+// openAIError turns an unsuccessful OpenAI-compatible API response into an
+// error. Backends answer 404 when a request names a model they aren't serving
+func openAIError(resp *http.Response) error {
+	var body struct {
+		Message string `json:"message"`
+		Error   struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	data, _ := io.ReadAll(resp.Body)
+	err := fmt.Errorf("%s API returned %s", activeBackend().Name(), resp.Status)
+	if json.Unmarshal(data, &body) == nil {
+		if message := configOr(body.Error.Message, body.Message); message != "" {
+			err = fmt.Errorf("%s API error: %s", activeBackend().Name(), message)
+		}
+	}
+	if resp.StatusCode == http.StatusNotFound {
+		return classify(err, errModelNotFound)
+	}
+	return err
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/docker/cli/templates"
+	"github.com/spf13/cobra"
+)
+
+// outputOptions holds the flags shared by commands that can print
+// machine-readable output
+type outputOptions struct {
+	format string
+	json   bool
+}
+
+// addOutputFlags registers --format, and --json as a shorthand for
+// --format json
+func addOutputFlags(cmd *cobra.Command, opts *outputOptions) {
+	cmd.Flags().StringVar(&opts.format, "format", "", "Format output using json or a Go template, like the rest of the docker CLI")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "Shorthand for --format json")
+}
+
+// resolved returns the effective format, with --json folded in
+func (o outputOptions) resolved() string {
+	if o.format == "" && o.json {
+		return "json"
+	}
+	return o.format
+}
+
+// validate rejects a malformed template before any work is done
+func (o outputOptions) validate() error {
+	switch format := o.resolved(); format {
+	case "", "json", "table":
+		return nil
+	default:
+		_, _, err := parseFormat(format)
+		return err
+	}
+}
+
+// parseFormat parses a Go template, reporting whether it's a "table" format
+// to align under a header. Escaped tabs and newlines are unescaped, as docker does
+func parseFormat(format string) (*template.Template, bool, error) {
+	table := strings.HasPrefix(format, "table ")
+	if table {
+		format = strings.TrimPrefix(format, "table ")
+	}
+	format = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(format)
+
+	tmpl, err := templates.Parse(format)
+	if err != nil {
+		return nil, false, fmt.Errorf("invalid --format template: %w", err)
+	}
+	return tmpl, table, nil
+}
+
+// templateFieldRegex matches a plain field reference in a template
+var templateFieldRegex = regexp.MustCompile(`\{\{\s*\.(\w+)\s*\}\}`)
+
+// render prints value in the requested format. JSON prints value itself, a
+// Go template is executed once per row, and "table" templates are aligned
+// under a header made from the field names. Without a format, or with a bare
+// "table", text prints the human-readable output
+func render[T any](w io.Writer, o outputOptions, value any, rows []T, text func() error) error {
+	switch format := o.resolved(); format {
+	case "", "table":
+		return text()
+	case "json":
+		return writeJSON(w, value)
+	default:
+		tmpl, table, err := parseFormat(format)
+		if err != nil {
+			return err
+		}
+
+		out := w
+		var tw *tabwriter.Writer
+		if table {
+			tw = tabwriter.NewWriter(w, 0, 0, 3, ' ', 0)
+			out = tw
+			header := strings.TrimPrefix(format, "table ")
+			header = strings.NewReplacer(`\t`, "\t", `\n`, "\n").Replace(header)
+			header = templateFieldRegex.ReplaceAllStringFunc(header, func(field string) string {
+				return strings.ToUpper(templateFieldRegex.FindStringSubmatch(field)[1])
+			})
+			_, _ = fmt.Fprintln(out, header)
+		}
+
+		for _, row := range rows {
+			if err := tmpl.Execute(out, row); err != nil {
+				return fmt.Errorf("failed to format output: %w", err)
+			}
+			_, _ = fmt.Fprintln(out)
+		}
+
+		if tw != nil {
+			return tw.Flush()
+		}
+		return nil
+	}
+}
+
+// writeJSON prints v as indented JSON
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
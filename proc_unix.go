@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// detachedProcAttr starts a process in its own session, so it outlives the
+// command that started it and doesn't get its Ctrl+C
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{Setsid: true}
+}
+
+// terminateProcess asks a process to shut down
+func terminateProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
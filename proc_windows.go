@@ -0,0 +1,20 @@
+package main
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows"
+)
+
+// detachedProcAttr starts a process without a console of its own, so it
+// outlives the command that started it and doesn't get its Ctrl+C
+func detachedProcAttr() *syscall.SysProcAttr {
+	return &syscall.SysProcAttr{CreationFlags: windows.DETACHED_PROCESS | windows.CREATE_NEW_PROCESS_GROUP}
+}
+
+// terminateProcess stops a process. Windows has no gentler equivalent of
+// SIGTERM for a process without a console
+func terminateProcess(p *os.Process) error {
+	return p.Kill()
+}
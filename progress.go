@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	// ansiRegex matches the terminal escape sequences Ollama uses to redraw its progress bars
+	ansiRegex = regexp.MustCompile(`\x1b\[[0-9;?]*[A-Za-z]`)
+
+	// layerProgressRegex matches a layer line in ollama CLI progress output, either
+	// in progress ("180 MB/397 MB") or finished ("397 MB")
+	layerProgressRegex = regexp.MustCompile(`pulling ([a-f0-9]+)\.\.\.\s*(\d+)%.*▏\s*([\d.]+ [KMGT]?B)(?:/([\d.]+ [KMGT]?B))?`)
+)
+
+// layerProgress is the download state of a single model layer
+type layerProgress struct {
+	completed float64
+	total     float64
+	initial   float64 // completed bytes when first seen, i.e. already cached
+}
+
+// pullProgress turns per-layer download progress, from the pull API or the
+// lines `ollama create` prints, into a single consolidated progress bar
+type pullProgress struct {
+	out    io.Writer
+	tty    bool
+	layers map[string]*layerProgress
+	order  []string // layer IDs in the order they were first seen
+	seen   map[string]bool
+
+	start    time.Time
+	lastDraw time.Time
+	lastPct  int
+	onScreen bool
+	drawn    int // layer lines above the total line on screen
+}
+
+// newPullProgress creates a progress renderer. On a TTY the bar is redrawn
+// in place; otherwise a line is printed periodically
+func newPullProgress(out io.Writer, tty bool) *pullProgress {
+	return &pullProgress{
+		out:     out,
+		tty:     tty,
+		layers:  make(map[string]*layerProgress),
+		seen:    make(map[string]bool),
+		start:   time.Now(),
+		lastPct: -1,
+	}
+}
+
+// Consume reads ollama CLI progress output until EOF, updating the progress bar
+func (p *pullProgress) Consume(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Split(scanLinesOrCR)
+
+	for scanner.Scan() {
+		p.handle(ansiRegex.ReplaceAllString(scanner.Text(), ""))
+	}
+	p.finish()
+
+	return scanner.Err()
+}
+
+// handle processes a single line of output
+func (p *pullProgress) handle(line string) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	match := layerProgressRegex.FindStringSubmatch(line)
+	if match == nil {
+		p.status(line)
+		return
+	}
+
+	pct, _ := strconv.Atoi(match[2])
+	var completed, total float64
+	if match[4] != "" {
+		completed = parseHumanBytes(match[3])
+		total = parseHumanBytes(match[4])
+	} else {
+		total = parseHumanBytes(match[3])
+		completed = total * float64(pct) / 100
+	}
+
+	p.update(match[1], completed, total)
+}
+
+// status prints a status message. Ollama repeats them on every redraw, so
+// each is only printed once
+func (p *pullProgress) status(msg string) {
+	if msg == "" || p.seen[msg] {
+		return
+	}
+	p.seen[msg] = true
+	p.clear()
+	_, _ = fmt.Fprintln(p.out, msg)
+}
+
+// update records the progress of a layer and redraws the bar
+func (p *pullProgress) update(id string, completed, total float64) {
+	layer, ok := p.layers[id]
+	if !ok {
+		layer = &layerProgress{initial: completed}
+		p.layers[id] = layer
+		p.order = append(p.order, id)
+	}
+	layer.completed = completed
+	layer.total = total
+
+	p.draw(false)
+}
+
+// apply handles a progress update from the pull or push API
+func (p *pullProgress) apply(update pullResponse) {
+	if update.Digest != "" && update.Total > 0 {
+		p.update(update.Digest, float64(update.Completed), float64(update.Total))
+	} else {
+		p.status(update.Status)
+	}
+}
+
+// totals returns completed, total and freshly downloaded bytes across all layers
+func (p *pullProgress) totals() (completed, total, downloaded float64) {
+	for _, layer := range p.layers {
+		completed += layer.completed
+		total += layer.total
+		downloaded += layer.completed - layer.initial
+	}
+	return completed, total, downloaded
+}
+
+// transferred returns the bytes actually downloaded and the bytes that were
+// already present, across every attempt
+func (p *pullProgress) transferred() (downloaded, cached float64) {
+	for _, layer := range p.layers {
+		downloaded += layer.completed - layer.initial
+		cached += layer.initial
+	}
+	return downloaded, cached
+}
+
+// draw renders the progress. On a TTY that's a bar per layer, like docker
+// pull, above a total with speed and ETA, all redrawn in place. Otherwise
+// it's just the total, printed as a new line now and then
+func (p *pullProgress) draw(final bool) {
+	completed, total, downloaded := p.totals()
+	if total == 0 {
+		return
+	}
+
+	pct := int(completed * 100 / total)
+	now := time.Now()
+
+	// Without a TTY every draw is a new line, so keep them infrequent
+	if !p.tty && !final && pct/10 == p.lastPct/10 && now.Sub(p.lastDraw) < 5*time.Second {
+		return
+	}
+	if p.tty && !final && pct == p.lastPct && now.Sub(p.lastDraw) < 200*time.Millisecond {
+		return
+	}
+	p.lastPct = pct
+	p.lastDraw = now
+
+	// Speed only counts bytes actually downloaded, not cached layers
+	var rate float64
+	if elapsed := now.Sub(p.start).Seconds(); elapsed > 0 {
+		rate = downloaded / elapsed
+	}
+	eta := "--"
+	if rate > 0 {
+		eta = (time.Duration((total-completed)/rate) * time.Second).String()
+	}
+	// The total's bar lines up with the layers' bars
+	line := fmt.Sprintf("%-26s%s %3d%%  %s/%s  %s/s  ETA %s",
+		"Total", progressBar(completed, total), pct, formatBytes(int64(completed)), formatBytes(int64(total)), formatBytes(int64(rate)), eta)
+
+	if !p.tty {
+		_, _ = fmt.Fprintln(p.out, line)
+		return
+	}
+
+	var b strings.Builder
+	if p.drawn > 0 {
+		// Move back up to the first layer line
+		fmt.Fprintf(&b, "\x1b[%dA", p.drawn)
+	}
+	for _, id := range p.order {
+		fmt.Fprintf(&b, "\r\x1b[K%s\n", p.layerLine(id))
+	}
+	fmt.Fprintf(&b, "\r\x1b[K%s", line)
+	_, _ = io.WriteString(p.out, b.String())
+	p.drawn = len(p.order)
+	p.onScreen = true
+}
+
+// layerLine describes one layer the way docker pull does
+func (p *pullProgress) layerLine(id string) string {
+	layer := p.layers[id]
+	id = strings.TrimPrefix(id, "sha256:")
+	if len(id) > 12 {
+		id = id[:12]
+	}
+
+	switch {
+	case layer.total > 0 && layer.initial >= layer.total:
+		return id + ": Already exists"
+	case layer.total > 0 && layer.completed >= layer.total:
+		return id + ": Download complete"
+	default:
+		return fmt.Sprintf("%s: Downloading %s  %s/%s", id, progressBar(layer.completed, layer.total), formatBytes(int64(layer.completed)), formatBytes(int64(layer.total)))
+	}
+}
+
+// progressBar renders completed/total as a bar in the style of docker pull
+func progressBar(completed, total float64) string {
+	const width = 30
+	filled := 0
+	if total > 0 {
+		filled = min(int(completed*width/total), width)
+	}
+	if filled == 0 || filled == width {
+		return "[" + strings.Repeat("=", filled) + strings.Repeat(" ", width-filled) + "]"
+	}
+	return "[" + strings.Repeat("=", filled-1) + ">" + strings.Repeat(" ", width-filled) + "]"
+}
+
+// clear moves past an in-place progress bar so other output starts on a fresh line
+func (p *pullProgress) clear() {
+	if p.onScreen {
+		_, _ = fmt.Fprintln(p.out)
+		p.onScreen = false
+		p.drawn = 0
+	}
+}
+
+// finish draws the final state of the progress. A TTY display that later
+// output has moved past is left as it was rather than drawn again
+func (p *pullProgress) finish() {
+	if len(p.layers) > 0 && (p.onScreen || !p.tty) {
+		p.draw(true)
+	}
+	p.clear()
+}
+
+// prefixWriter writes whole lines to out, each prefixed, so several
+// writers sharing a lock can interleave their output without mixing lines
+type prefixWriter struct {
+	mu     *sync.Mutex
+	out    io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.mu.Lock()
+		_, _ = fmt.Fprintf(w.out, "%s%s\n", w.prefix, w.buf[:i])
+		w.mu.Unlock()
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush writes out a final line that wasn't terminated
+func (w *prefixWriter) flush() {
+	if len(w.buf) > 0 {
+		_, _ = w.Write([]byte("\n"))
+	}
+}
+
+// scanLinesOrCR is a bufio.SplitFunc that splits on \n or \r, since
+// progress output rewrites lines with carriage returns
+func scanLinesOrCR(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	if atEOF && len(data) == 0 {
+		return 0, nil, nil
+	}
+	if i := bytes.IndexAny(data, "\r\n"); i >= 0 {
+		return i + 1, data[:i], nil
+	}
+	if atEOF {
+		return len(data), data, nil
+	}
+	return 0, nil, nil
+}
+
+// parseHumanBytes parses sizes like "397 MB" as printed by Ollama, which
+// uses decimal units
+func parseHumanBytes(s string) float64 {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0
+	}
+
+	value, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0
+	}
+
+	switch fields[1] {
+	case "KB":
+		value *= 1e3
+	case "MB":
+		value *= 1e6
+	case "GB":
+		value *= 1e9
+	case "TB":
+		value *= 1e12
+	}
+	return value
+}
+
+// formatBytes formats a byte count with decimal units, matching Ollama
+func formatBytes(n int64) string {
+	const unit = 1000
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// spinnerFrames are drawn in turn to show that something is happening
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// startSpinner draws a spinner after message on a terminal, with the time
+// taken so far, until the returned function is called to clear it
+func startSpinner(out io.Writer, message string) func() {
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		start := time.Now()
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for frame := 0; ; frame++ {
+			_, _ = fmt.Fprintf(out, "\r\x1b[K%s %s (%s)", spinnerFrames[frame%len(spinnerFrames)], message, time.Since(start).Truncate(time.Second))
+			select {
+			case <-stop:
+				_, _ = fmt.Fprint(out, "\r\x1b[K")
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func() {
+		close(stop)
+		<-done
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestPullProgressAPI(t *testing.T) {
+	fakeOllama(t, "tags.json")
+
+	var out bytes.Buffer
+	progress := newPullProgress(&out, false)
+	err := pullModelAPI(context.Background(), "llama3.2", progress.apply)
+	progress.finish()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The model layer, the small one already present, and the params layer
+	const total = 2019377376 + 1429 + 7711
+	completed, gotTotal, _ := progress.totals()
+	if completed != total || gotTotal != total {
+		t.Errorf("totals = %.0f/%.0f, want %d/%d", completed, gotTotal, total, total)
+	}
+	downloaded, cached := progress.transferred()
+	if downloaded != 2019377376+7711 || cached != 1429 {
+		t.Errorf("transferred = %.0f downloaded, %.0f cached; want %d, %d", downloaded, cached, 2019377376+7711, 1429)
+	}
+	for _, status := range []string{"pulling manifest", "verifying sha256 digest", "success"} {
+		if bytes.Count(out.Bytes(), []byte(status+"\n")) != 1 {
+			t.Errorf("status %q not printed exactly once in:\n%s", status, out.String())
+		}
+	}
+}
+
+func TestPullProgressCLI(t *testing.T) {
+	f, err := os.Open(filepath.Join("testdata", "create.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var out bytes.Buffer
+	progress := newPullProgress(&out, false)
+	if err := progress.Consume(f); err != nil {
+		t.Fatal(err)
+	}
+
+	// Redraws of a layer update it rather than counting it again
+	if len(progress.layers) != 2 {
+		t.Fatalf("got %d layers, want 2", len(progress.layers))
+	}
+	const total = 4.7e9 + 1.4e3
+	completed, gotTotal, _ := progress.totals()
+	if completed != total || gotTotal != total {
+		t.Errorf("totals = %.0f/%.0f, want %.0f/%.0f", completed, gotTotal, total, total)
+	}
+	if bytes.Contains(out.Bytes(), []byte("\x1b[")) {
+		t.Errorf("escape sequences passed through to:\n%q", out.String())
+	}
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultListenAddress is where `serve` listens unless --listen is given
+const DefaultListenAddress = "127.0.0.1:12434"
+
+// newAPIProxy returns a handler that forwards every request to the runner's
+// Ollama API, logging each one to out. Ollama serves OpenAI-compatible
+// endpoints under /v1 itself, so those pass straight through. If allowed is
+// non-empty, only those models can be used or listed. Requests for a model
+// wait for a slot in queue
+func newAPIProxy(out io.Writer, allowed []string, queue *requestQueue) (http.Handler, error) {
+	target, err := url.Parse(ollamaAPIURL())
+	if err != nil {
+		return nil, err
+	}
+
+	allow := modelAllowlist(allowed)
+	proxy := newReverseProxy(target, allow)
+	return proxyHandler(out, allow, func(w http.ResponseWriter, r *http.Request, model string) string {
+		release, ok := queue.admit(w, r, model)
+		if !ok {
+			return ""
+		}
+		defer release()
+		proxy.ServeHTTP(w, r)
+		return ""
+	}), nil
+}
+
+// newReverseProxy returns a proxy to target that hides models outside the
+// allowlist from its model listings
+func newReverseProxy(target *url.URL, allow func(string) bool) *httputil.ReverseProxy {
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	// Generation responses are streamed, so pass chunks on as they arrive
+	proxy.FlushInterval = -1
+
+	if allow != nil {
+		proxy.ModifyResponse = func(resp *http.Response) error {
+			return filterModelList(resp, allow)
+		}
+	}
+	return proxy
+}
+
+// proxyHandler logs each request to out and turns away those for models
+// outside the allowlist, handing the rest to serve with the model they name,
+// if any. serve returns where it sent the request, for the log
+func proxyHandler(out io.Writer, allow func(string) bool, serve func(w http.ResponseWriter, r *http.Request, model string) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		var upstream string
+		defer func() {
+			line := fmt.Sprintf("%s %s %s %d %s", start.Format(time.RFC3339), r.Method, r.URL.Path, rec.status, time.Since(start).Round(time.Millisecond))
+			if upstream != "" {
+				line += " " + upstream
+			}
+			_, _ = fmt.Fprintln(out, line)
+		}()
+
+		model, ok := requestedModel(r)
+		if ok {
+			if allow != nil && !allow(model) {
+				rejectModel(rec, r, model)
+				return
+			}
+			// Requests through serve count as use, so prune keeps these models
+			recordUsage(model)
+		}
+		upstream = serve(rec, r, model)
+	})
+}
+
+// statusRecorder captures the status code written through a ResponseWriter
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Flush passes flushes through so streamed responses aren't buffered
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// modelAllowlist returns a check for whether a model may be used, or nil if
+// every model is allowed. Names are compared fully qualified, so "gemma3"
+// and "gemma3:latest" are the same model
+func modelAllowlist(models []string) func(string) bool {
+	if len(models) == 0 {
+		return nil
+	}
+	refs := make(map[modelReference]bool, len(models))
+	for _, m := range models {
+		refs[parseModelReference(m)] = true
+	}
+	return func(model string) bool {
+		return refs[parseModelReference(model)]
+	}
+}
+
+// requestedModel returns the model named in a JSON request body, leaving the
+// body intact for the proxy
+func requestedModel(r *http.Request) (string, bool) {
+	if r.Method != http.MethodPost || r.Body == nil {
+		return "", false
+	}
+
+	body, err := io.ReadAll(r.Body)
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return "", false
+	}
+
+	var req struct {
+		Model string `json:"model"`
+		Name  string `json:"name"` // older Ollama endpoints
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return "", false
+	}
+	if req.Model != "" {
+		return req.Model, true
+	}
+	return req.Name, req.Name != ""
+}
+
+// rejectModel answers a request for a model outside the allowlist, in the
+// error format of the API that was called
+func rejectModel(w http.ResponseWriter, r *http.Request, model string) {
+	writeProxyError(w, r, http.StatusNotFound, "invalid_request_error", "model_not_found", fmt.Sprintf("model %q is not served here", model))
+}
+
+// writeProxyError answers a request the proxy can't pass on, in the error
+// format of the API that was called
+func writeProxyError(w http.ResponseWriter, r *http.Request, status int, kind, code, msg string) {
+	var body any = map[string]string{"error": msg}
+	if strings.HasPrefix(r.URL.Path, "/v1/") {
+		body = map[string]any{"error": map[string]string{
+			"message": msg,
+			"type":    kind,
+			"code":    code,
+		}}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+// modelListing reports whether path lists models and, if so, the key of the
+// list in the response and the field that names each model
+func modelListing(path string) (key, field string, ok bool) {
+	switch path {
+	case "/v1/models":
+		return "data", "id", true
+	case "/api/tags", "/api/ps":
+		return "models", "name", true
+	}
+	return "", "", false
+}
+
+// filterModelList removes models outside the allowlist from the model
+// listings of both APIs
+func filterModelList(resp *http.Response, allow func(string) bool) error {
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	key, field, ok := modelListing(resp.Request.URL.Path)
+	if !ok {
+		return nil
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return err
+	}
+
+	var list map[string]json.RawMessage
+	var entries []map[string]any
+	if json.Unmarshal(data, &list) != nil || json.Unmarshal(list[key], &entries) != nil {
+		// Not the shape we expected, so pass it on untouched
+		resp.Body = io.NopCloser(bytes.NewReader(data))
+		return nil
+	}
+
+	kept := []map[string]any{}
+	for _, entry := range entries {
+		if name, _ := entry[field].(string); allow(name) {
+			kept = append(kept, entry)
+		}
+	}
+	if list[key], err = json.Marshal(kept); err != nil {
+		return err
+	}
+	if data, err = json.Marshal(list); err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(data))
+	resp.ContentLength = int64(len(data))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(data)))
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/docker/cli/cli/config/types"
+)
+
+// ManifestMediaType is the media type of Ollama model manifests
+const ManifestMediaType = "application/vnd.docker.distribution.manifest.v2+json"
+
+// uploadClient is used for blob uploads, which can take far longer than
+// registryClient's timeout allows
+var uploadClient = &http.Client{}
+
+// registrySession talks to a repository on a registry speaking the OCI
+// distribution API, authenticating with the credentials `docker login`
+// stored for it
+type registrySession struct {
+	base  string // scheme and host, e.g. https://registry.example.com
+	ref   modelReference
+	auth  types.AuthConfig
+	token string // bearer token, once the registry has issued one
+}
+
+// newRegistrySession prepares to talk to ref's repository with the
+// registry's stored credentials, which may be empty for a registry that
+// doesn't need them
+func newRegistrySession(auth types.AuthConfig, ref modelReference, insecure bool) *registrySession {
+	scheme := "https"
+	if insecure {
+		scheme = "http"
+	}
+	return &registrySession{base: scheme + "://" + ref.Registry, ref: ref, auth: auth}
+}
+
+// pushManifest uploads every blob a manifest references that the registry
+// doesn't already have, then the manifest itself under the session's tag
+func (p *registrySession) pushManifest(ctx context.Context, raw []byte, mediaType string, progress *pullProgress) error {
+	var m manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	if err := p.authenticate(ctx, "pull,push"); err != nil {
+		return err
+	}
+
+	for _, layer := range append(m.Layers, m.Config) {
+		if err := p.pushBlob(ctx, layer, progress); err != nil {
+			return err
+		}
+	}
+
+	progress.status("pushing manifest")
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, p.url("manifests/"+p.ref.Tag), bytes.NewReader(raw))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", mediaType)
+	resp, err := p.do(registryClient, req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry rejected the manifest: %s", resp.Status)
+	}
+	progress.status("success")
+	return nil
+}
+
+// pushBlob uploads a single blob, streamed out of the runner, unless the
+// registry already has it
+func (p *registrySession) pushBlob(ctx context.Context, layer manifestLayer, progress *pullProgress) error {
+	head, err := http.NewRequestWithContext(ctx, http.MethodHead, p.url("blobs/"+layer.Digest), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.do(registryClient, head)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode == http.StatusOK {
+		progress.update(layer.Digest, float64(layer.Size), float64(layer.Size))
+		return nil
+	}
+
+	start, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url("blobs/uploads/"), nil)
+	if err != nil {
+		return err
+	}
+	resp, err = p.do(registryClient, start)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("registry refused an upload of %s: %s", layer.Digest, resp.Status)
+	}
+	location, err := resp.Request.URL.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		return fmt.Errorf("registry returned an invalid upload location: %w", err)
+	}
+	query := location.Query()
+	query.Set("digest", layer.Digest)
+	location.RawQuery = query.Encode()
+
+	blob, size, err := docker.OpenFile(ctx, containerName(), ModelsDir+"/blobs/"+strings.Replace(layer.Digest, ":", "-", 1))
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", layer.Digest, err)
+	}
+	defer blob.Close()
+
+	body := &countingReader{r: blob, fn: func(n int64) {
+		progress.update(layer.Digest, float64(n), float64(size))
+	}}
+	upload, err := http.NewRequestWithContext(ctx, http.MethodPut, location.String(), body)
+	if err != nil {
+		return err
+	}
+	upload.ContentLength = size
+	upload.Header.Set("Content-Type", "application/octet-stream")
+	resp, err = p.do(uploadClient, upload)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("registry rejected blob %s: %s", layer.Digest, resp.Status)
+	}
+	return nil
+}
+
+// url returns the address of a path under the model's repository
+func (p *registrySession) url(path string) string {
+	return fmt.Sprintf("%s/v2/%s/%s", p.base, p.ref.Repository, path)
+}
+
+// do sends a request with the push's credentials
+func (p *registrySession) do(client *http.Client, req *http.Request) (*http.Response, error) {
+	switch {
+	case p.token != "":
+		req.Header.Set("Authorization", "Bearer "+p.token)
+	case p.auth.Username != "":
+		req.SetBasicAuth(p.auth.Username, p.auth.Password)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach registry: %w", err)
+	}
+	if resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry %s denied access (%s); run `docker login %s`", p.ref.Registry, resp.Status, p.ref.Registry)
+	}
+	return resp, nil
+}
+
+// challengeParamRegex matches a key="value" pair in a WWW-Authenticate header
+var challengeParamRegex = regexp.MustCompile(`(\w+)="([^"]*)"`)
+
+// authenticate asks the registry how to authenticate and, for token auth,
+// exchanges the stored credentials for a token allowing actions (such as
+// "pull,push") on the repository
+func (p *registrySession) authenticate(ctx context.Context, actions string) error {
+	ping, err := http.NewRequestWithContext(ctx, http.MethodGet, p.base+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := registryClient.Do(ping)
+	if err != nil {
+		return fmt.Errorf("failed to reach registry: %w", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+
+	challenge := resp.Header.Get("WWW-Authenticate")
+	if !strings.HasPrefix(strings.ToLower(challenge), "bearer ") {
+		// Basic auth sends the credentials with every request
+		return nil
+	}
+
+	params := map[string]string{}
+	for _, match := range challengeParamRegex.FindAllStringSubmatch(challenge, -1) {
+		params[match[1]] = match[2]
+	}
+	realm, err := url.Parse(params["realm"])
+	if err != nil || params["realm"] == "" {
+		return fmt.Errorf("registry sent an invalid auth challenge: %q", challenge)
+	}
+	scope := fmt.Sprintf("repository:%s:%s", p.ref.Repository, actions)
+
+	var req *http.Request
+	if p.auth.IdentityToken != "" {
+		form := url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {p.auth.IdentityToken},
+			"service":       {params["service"]},
+			"scope":         {scope},
+			"client_id":     {"mocker"},
+		}
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, realm.String(), strings.NewReader(form.Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		query := realm.Query()
+		query.Set("service", params["service"])
+		query.Set("scope", scope)
+		realm.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, realm.String(), nil)
+		if err != nil {
+			return err
+		}
+		if p.auth.Username != "" {
+			req.SetBasicAuth(p.auth.Username, p.auth.Password)
+		}
+	}
+
+	resp, err = registryClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach registry auth server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry %s denied access (%s); run `docker login %s`", p.ref.Registry, resp.Status, p.ref.Registry)
+	}
+
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return fmt.Errorf("failed to decode registry token: %w", err)
+	}
+	p.token = token.Token
+	if p.token == "" {
+		p.token = token.AccessToken
+	}
+	return nil
+}
+
+// localManifest reads the manifest of an installed model from the runner
+func localManifest(ref modelReference) ([]byte, error) {
+	raw, err := readRunnerFile(fmt.Sprintf("%s/manifests/%s/%s/%s", ModelsDir, ref.Registry, ref.Repository, ref.Tag))
+	if err != nil {
+		return nil, fmt.Errorf("model %s not found; pull or create it under that name first", ref)
+	}
+	return raw, nil
+}
+
+// readRunnerFile reads a small file from the runner container
+func readRunnerFile(path string) ([]byte, error) {
+	f, _, err := docker.OpenFile(context.Background(), containerName(), path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
+}
+
+// countingReader reports how many bytes have been read through it
+type countingReader struct {
+	r  io.Reader
+	n  int64
+	fn func(n int64)
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.fn(c.n)
+	return n, err
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// QueuePath is where serve reports its queue, for status
+const QueuePath = "/mocker/queue"
+
+// errRunnerBusy is returned when a request can't get a slot on the runner
+// within --max-queue-wait
+var errRunnerBusy = errors.New("runner busy")
+
+// queueStats is the state of serve's queue as reported by status
+type queueStats struct {
+	Limit   int   `json:"limit,omitempty"` // 0 means no limit
+	Active  int64 `json:"active"`
+	Waiting int64 `json:"waiting"`
+}
+
+// requestQueue limits how many requests for a model serve passes on at
+// once. The rest wait for a slot in turn, for at most maxWait if it's set
+type requestQueue struct {
+	slots   chan struct{} // nil when there's no limit
+	maxWait time.Duration
+	active  atomic.Int64
+	waiting atomic.Int64
+}
+
+// newRequestQueue returns a queue with limit slots, or none if limit is 0
+func newRequestQueue(limit int, maxWait time.Duration) *requestQueue {
+	q := &requestQueue{maxWait: maxWait}
+	if limit > 0 {
+		q.slots = make(chan struct{}, limit)
+	}
+	return q
+}
+
+// acquire waits for a slot, returning the function that gives it back. It
+// fails with errRunnerBusy when maxWait passes first
+func (q *requestQueue) acquire(ctx context.Context) (func(), error) {
+	if q.slots != nil {
+		select {
+		case q.slots <- struct{}{}:
+		default:
+			if err := q.wait(ctx); err != nil {
+				return nil, err
+			}
+		}
+	}
+	q.active.Add(1)
+	return func() {
+		q.active.Add(-1)
+		if q.slots != nil {
+			<-q.slots
+		}
+	}, nil
+}
+
+// wait queues for a slot once they're all taken
+func (q *requestQueue) wait(ctx context.Context) error {
+	q.waiting.Add(1)
+	defer q.waiting.Add(-1)
+
+	var timeout <-chan time.Time
+	if q.maxWait > 0 {
+		timer := time.NewTimer(q.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case q.slots <- struct{}{}:
+		return nil
+	case <-timeout:
+		return fmt.Errorf("%w: no slot free within %s", errRunnerBusy, q.maxWait)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// stats reports the slots in use and the requests waiting for one
+func (q *requestQueue) stats() queueStats {
+	return queueStats{Limit: cap(q.slots), Active: q.active.Load(), Waiting: q.waiting.Load()}
+}
+
+// serveStats answers status with the queue's state
+func (q *requestQueue) serveStats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(q.stats())
+}
+
+// serveQueueStats asks the serve listening on addr for its queue. It fails
+// if nothing is serving there
+func serveQueueStats(ctx context.Context, addr string) (*queueStats, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+QueuePath, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", QueuePath, resp.Status)
+	}
+	var stats queueStats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// admit takes a slot for a request that names a model, answering it with
+// "runner busy" if none is free in time. Other requests, such as model
+// listings, go straight through
+func (q *requestQueue) admit(w http.ResponseWriter, r *http.Request, model string) (func(), bool) {
+	if model == "" {
+		return func() {}, true
+	}
+	release, err := q.acquire(r.Context())
+	if err != nil {
+		// Clients retry this as they do Ollama's own "server busy"
+		w.Header().Set("Retry-After", "1")
+		writeProxyError(w, r, http.StatusServiceUnavailable, "server_error", "runner_busy", err.Error())
+		return nil, false
+	}
+	return release, true
+}
+
+// generateWithQueueWait streams a completion like the backend's Generate,
+// but fails with errRunnerBusy if the runner hasn't started answering
+// within maxQueueWait. A runner with no free slot holds a request back
+// without saying so, so waiting for one looks like waiting for a response
+func generateWithQueueWait(ctx context.Context, req generateRequest, w io.Writer, stallTimeout, maxQueueWait time.Duration) (*generateStats, error) {
+	if maxQueueWait <= 0 {
+		return activeBackend().Generate(ctx, req, w, stallTimeout)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var busy atomic.Bool
+	timer := time.AfterFunc(maxQueueWait, func() {
+		busy.Store(true)
+		cancel()
+	})
+	defer timer.Stop()
+
+	stats, err := activeBackend().Generate(ctx, req, &startedWriter{Writer: w, started: func() { timer.Stop() }}, stallTimeout)
+	if err != nil && busy.Load() {
+		return nil, fmt.Errorf("%w: no answer within %s, the runner may be serving other requests", errRunnerBusy, maxQueueWait)
+	}
+	return stats, err
+}
+
+// startedWriter calls started before its first write
+type startedWriter struct {
+	io.Writer
+	started func()
+	once    bool
+}
+
+func (w *startedWriter) Write(p []byte) (int, error) {
+	if !w.once {
+		w.once = true
+		w.started()
+	}
+	return w.Writer.Write(p)
+}
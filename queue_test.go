@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestQueue(t *testing.T) {
+	q := newRequestQueue(1, 50*time.Millisecond)
+	ctx := context.Background()
+
+	release, err := q.acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire with a free slot: %v", err)
+	}
+	if got := q.stats(); got != (queueStats{Limit: 1, Active: 1}) {
+		t.Errorf("stats with the slot taken = %+v", got)
+	}
+
+	// With the only slot taken, the next request waits and gives up
+	if _, err := q.acquire(ctx); !errors.Is(err, errRunnerBusy) {
+		t.Fatalf("acquire with no free slot = %v, want %v", err, errRunnerBusy)
+	}
+
+	// A request waiting when the slot is given back gets it
+	acquired := make(chan error)
+	go func() {
+		release, err := q.acquire(ctx)
+		if err == nil {
+			release()
+		}
+		acquired <- err
+	}()
+	for q.stats().Waiting == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	release()
+	if err := <-acquired; err != nil {
+		t.Errorf("acquire after the slot was given back: %v", err)
+	}
+	if got := q.stats(); got != (queueStats{Limit: 1}) {
+		t.Errorf("stats once idle = %+v", got)
+	}
+}
+
+func TestRequestQueueUnlimited(t *testing.T) {
+	q := newRequestQueue(0, 0)
+	var releases []func()
+	for range 10 {
+		release, err := q.acquire(context.Background())
+		if err != nil {
+			t.Fatalf("acquire without a limit: %v", err)
+		}
+		releases = append(releases, release)
+	}
+	if got := q.stats(); got != (queueStats{Active: 10}) {
+		t.Errorf("stats = %+v", got)
+	}
+	for _, release := range releases {
+		release()
+	}
+}
+
+func TestServeRunnerBusy(t *testing.T) {
+	useFakeDocker(t, runningRunner(nil))
+	generating := make(chan struct{})
+	done := make(chan struct{})
+	serveOllama(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/api/tags" {
+			http.ServeFile(w, r, filepath.Join("testdata", "tags.json"))
+			return
+		}
+		close(generating)
+		<-done
+	}))
+
+	handler, err := newAPIProxy(&bytes.Buffer{}, nil, newRequestQueue(1, 50*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	// The first request holds the only slot
+	first := make(chan struct{})
+	go func() {
+		defer close(first)
+		resp, err := http.Post(server.URL+"/api/generate", "application/json", strings.NewReader(`{"model":"llama3.2","prompt":"Hi"}`))
+		if err == nil {
+			resp.Body.Close()
+		}
+	}()
+	<-generating
+	defer func() {
+		close(done)
+		<-first
+	}()
+
+	resp, err := http.Post(server.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model":"llama3.2","messages":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("status = %d, want %d", resp.StatusCode, http.StatusServiceUnavailable)
+	}
+	var body struct {
+		Error struct {
+			Code string `json:"code"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil || body.Error.Code != "runner_busy" {
+		t.Errorf("error code = %q (%v), want runner_busy", body.Error.Code, err)
+	}
+
+	// Listings don't wait for a slot
+	resp, err = http.Get(server.URL + "/api/tags")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("listing status = %d, want %d", resp.StatusCode, http.StatusOK)
+	}
+}
+
+func TestGenerateWithQueueWait(t *testing.T) {
+	tests := []struct {
+		name         string
+		delay        time.Duration // before the runner starts answering
+		maxQueueWait time.Duration
+		wantErr      error
+	}{
+		{name: "answered in time", maxQueueWait: time.Second},
+		{name: "no limit", delay: 100 * time.Millisecond},
+		{name: "runner busy", delay: 500 * time.Millisecond, maxQueueWait: 50 * time.Millisecond, wantErr: errRunnerBusy},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useFakeDocker(t, runningRunner(nil))
+			serveOllama(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				select {
+				case <-time.After(tt.delay):
+				case <-r.Context().Done():
+					return
+				}
+				http.ServeFile(w, r, filepath.Join("testdata", "generate-json.jsonl"))
+			}))
+
+			var out bytes.Buffer
+			_, err := generateWithQueueWait(context.Background(), generateRequest{Model: "llama3.2", Prompt: "Hi"}, &out, 0, tt.maxQueueWait)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("generateWithQueueWait = %v, want %v", err, tt.wantErr)
+			}
+			if tt.wantErr == nil && out.Len() == 0 {
+				t.Error("nothing was generated")
+			}
+		})
+	}
+}
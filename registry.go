@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	DefaultRegistry  = "registry.ollama.ai"
+	DefaultNamespace = "library"
+	DefaultTag       = "latest"
+)
+
+// registryClient is used for all calls to the model registry
+var registryClient = &http.Client{Timeout: 30 * time.Second}
+
+// modelReference is a model name split into its registry components
+type modelReference struct {
+	Registry   string
+	Repository string
+	Tag        string
+}
+
+// String returns the fully-qualified form of the reference
+func (r modelReference) String() string {
+	return fmt.Sprintf("%s/%s:%s", r.Registry, r.Repository, r.Tag)
+}
+
+// Short returns the reference the way Ollama displays it, leaving out the
+// default registry and namespace
+func (r modelReference) Short() string {
+	return strings.TrimPrefix(r.String(), DefaultRegistry+"/"+DefaultNamespace+"/")
+}
+
+// parseModelReference expands a model name the same way Ollama does,
+// filling in the default registry, namespace and tag. An oci:// prefix is
+// ignored, since the model is installed under the plain name
+func parseModelReference(name string) modelReference {
+	ref := modelReference{Registry: DefaultRegistry, Tag: DefaultTag}
+	name = strings.TrimPrefix(name, OCIScheme)
+
+	// The tag follows the last colon, but only if it comes after the last slash
+	if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		ref.Tag = name[i+1:]
+		name = name[:i]
+	}
+
+	parts := strings.Split(name, "/")
+	switch len(parts) {
+	case 1:
+		ref.Repository = DefaultNamespace + "/" + parts[0]
+	case 2:
+		ref.Repository = name
+	default:
+		ref.Registry = parts[0]
+		ref.Repository = strings.Join(parts[1:], "/")
+	}
+
+	return ref
+}
+
+// registryHostRegex matches a registry host name with an optional port
+var registryHostRegex = regexp.MustCompile(`^[a-zA-Z0-9]([a-zA-Z0-9.-]*[a-zA-Z0-9])?(:[0-9]+)?$`)
+
+// withRegistry qualifies a model name with a registry host. Names that
+// already include a registry must name the same one
+func withRegistry(name, registry string) (string, error) {
+	if !registryHostRegex.MatchString(registry) {
+		return "", fmt.Errorf("invalid registry %q: expected a host name such as registry.example.com or localhost:5000", registry)
+	}
+
+	ref := parseModelReference(name)
+	if len(strings.Split(name, "/")) > 2 {
+		if ref.Registry != registry {
+			return "", fmt.Errorf("model %s already names registry %s, which conflicts with --registry %s", name, ref.Registry, registry)
+		}
+		return name, nil
+	}
+
+	ref.Registry = registry
+	return ref.String(), nil
+}
+
+// remoteDigest fetches the manifest for a model from its registry and returns
+// the sha256 digest Ollama uses as the model ID
+func remoteDigest(ctx context.Context, name string) (string, error) {
+	ref := parseModelReference(name)
+	url := fmt.Sprintf("https://%s/v2/%s/manifests/%s", ref.Registry, ref.Repository, ref.Tag)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/vnd.docker.distribution.manifest.v2+json")
+
+	resp, err := registryClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to reach registry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry returned %s for %s", resp.Status, ref)
+	}
+
+	// Ollama identifies a model by the digest of its raw manifest
+	hash := sha256.New()
+	if _, err := io.Copy(hash, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// UpdateCheckParallelism is how many models list --remote-latest checks
+// against the registry at once
+const UpdateCheckParallelism = 8
+
+// checkForUpdate compares a local model ID against the registry and returns
+// a short human readable status
+func checkForUpdate(ctx context.Context, name, localID string) string {
+	digest, err := remoteDigest(ctx, name)
+	if err != nil {
+		return "unknown"
+	}
+
+	if localID != "" && strings.HasPrefix(digest, localID) {
+		return "up to date"
+	}
+	return "update available"
+}
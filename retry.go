@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"math/rand/v2"
+	"os"
+	"strconv"
+	"time"
+)
+
+// optionalInt is an integer flag value that remembers whether it was set,
+// for flags where zero is a meaningful choice
+type optionalInt struct {
+	value int
+	set   bool
+}
+
+func (i *optionalInt) String() string { return strconv.Itoa(i.value) }
+func (i *optionalInt) Type() string   { return "int" }
+
+func (i *optionalInt) Set(s string) error {
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		return err
+	}
+	if v < 0 {
+		return strconv.ErrRange
+	}
+	i.value, i.set = v, true
+	return nil
+}
+
+// retries resolves how many times a transient failure is retried, from
+// --retries, then MOCKER_RETRIES, then the config file, then the default
+func retries() int {
+	if retriesFlag.set {
+		return retriesFlag.value
+	}
+	if n, err := strconv.Atoi(os.Getenv("MOCKER_RETRIES")); err == nil && n >= 0 {
+		return n
+	}
+	if n := settings().Retries; n != nil {
+		return *n
+	}
+	return DefaultRetries
+}
+
+// backoff returns how long to wait before the given retry, counting from
+// 1. The delay doubles each time up to MaxRetryDelay, and is jittered so
+// concurrent commands don't retry in lockstep
+func backoff(attempt int) time.Duration {
+	delay := MaxRetryDelay
+	if attempt < 16 {
+		delay = min(time.Second<<(attempt-1), MaxRetryDelay)
+	}
+	return delay/2 + rand.N(delay/2)
+}
+
+// withRetry runs fn until it succeeds, fails with an error transient
+// doesn't consider worth retrying, or runs out of retries. Waiting between
+// attempts stops as soon as ctx is cancelled
+func withRetry(ctx context.Context, transient func(error) bool, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt > retries() || !transient(err) || ctx.Err() != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// loadSchema reads a JSON schema given inline or as a path to a file
+func loadSchema(arg string) (json.RawMessage, error) {
+	data := []byte(arg)
+	if !strings.HasPrefix(strings.TrimSpace(arg), "{") {
+		var err error
+		if data, err = os.ReadFile(arg); err != nil {
+			return nil, fmt.Errorf("failed to read schema: %w", err)
+		}
+	}
+
+	var schema map[string]any
+	if err := json.Unmarshal(data, &schema); err != nil {
+		return nil, fmt.Errorf("invalid schema: %w", err)
+	}
+	return json.RawMessage(data), nil
+}
+
+// validateJSON checks a model's response against a JSON schema. Ollama
+// constrains decoding to the schema, so this is a safety net covering the
+// common keywords (type, properties, required, additionalProperties, items
+// and enum) rather than a complete validator
+func validateJSON(response []byte, schema json.RawMessage) error {
+	var value any
+	if err := json.Unmarshal(response, &value); err != nil {
+		return fmt.Errorf("model did not return valid JSON: %w", err)
+	}
+	if schema == nil {
+		return nil
+	}
+
+	var s map[string]any
+	if err := json.Unmarshal(schema, &s); err != nil {
+		return fmt.Errorf("invalid schema: %w", err)
+	}
+	if err := checkSchema(value, s, "$"); err != nil {
+		return fmt.Errorf("model response does not match the schema: %w", err)
+	}
+	return nil
+}
+
+// checkSchema validates value, found at path, against schema
+func checkSchema(value any, schema map[string]any, path string) error {
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, allowed := range enum {
+			if fmt.Sprint(allowed) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: %v is not one of %v", path, value, enum)
+		}
+	}
+
+	if t, ok := schema["type"]; ok {
+		var types []string
+		switch t := t.(type) {
+		case string:
+			types = []string{t}
+		case []any:
+			for _, v := range t {
+				types = append(types, fmt.Sprint(v))
+			}
+		}
+		if !slices.Contains(types, jsonType(value)) && !(jsonType(value) == "integer" && slices.Contains(types, "number")) {
+			return fmt.Errorf("%s: expected %s, got %s", path, strings.Join(types, " or "), jsonType(value))
+		}
+	}
+
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := value[fmt.Sprint(name)]; !ok {
+					return fmt.Errorf("%s: missing required property %q", path, name)
+				}
+			}
+		}
+		for name, v := range value {
+			sub, ok := properties[name].(map[string]any)
+			if !ok {
+				if schema["additionalProperties"] == false {
+					return fmt.Errorf("%s: unexpected property %q", path, name)
+				}
+				continue
+			}
+			if err := checkSchema(v, sub, path+"."+name); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, v := range value {
+				if err := checkSchema(v, items, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonType returns the JSON schema type name of a decoded JSON value
+func jsonType(value any) string {
+	switch v := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return "unknown"
+}
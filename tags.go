@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/docker/cli/cli/command"
+)
+
+// TagsParallelism is how many manifests tags fetches at once to size tags
+const TagsParallelism = 8
+
+// tagRow is a tag of a model in its registry as reported by tags
+type tagRow struct {
+	Name string `json:"name"`
+	ID   string `json:"id"`
+	Size string `json:"size"`
+}
+
+// listRemoteTags returns every tag of a model in its registry, with the
+// size and ID each would have once pulled
+func listRemoteTags(ctx context.Context, dockerCli command.Cli, name string) ([]tagRow, error) {
+	ref := parseModelReference(name)
+
+	auth, err := dockerCli.ConfigFile().GetAuthConfig(ref.Registry)
+	if err != nil {
+		return nil, err
+	}
+	s := newRegistrySession(auth, ref, false)
+	if err := s.authenticate(ctx, "pull"); err != nil {
+		return nil, err
+	}
+
+	var tags []string
+	if ref.Registry == DefaultRegistry {
+		// registry.ollama.ai can't list tags, but ollama.com shows them
+		tags, err = libraryTags(ctx, ref)
+	} else {
+		tags, err = s.tags(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rows := make([]tagRow, len(tags))
+	sem := make(chan struct{}, TagsParallelism)
+	var wg sync.WaitGroup
+	for i, tag := range tags {
+		ref.Tag = tag
+		rows[i] = tagRow{Name: ref.Short(), ID: "unknown", Size: "unknown"}
+
+		wg.Add(1)
+		go func(row *tagRow, tag string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			raw, err := s.fetchManifest(ctx, tag)
+			if err != nil {
+				return
+			}
+			var m manifest
+			if json.Unmarshal(raw, &m) != nil {
+				return
+			}
+			var size int64
+			for _, layer := range append(m.Layers, m.Config) {
+				size += layer.Size
+			}
+			// Ollama identifies a model by the digest of its raw manifest
+			hash := sha256.Sum256(raw)
+			row.ID = hex.EncodeToString(hash[:])[:12]
+			row.Size = formatBytes(size)
+		}(&rows[i], tag)
+	}
+	wg.Wait()
+
+	return rows, nil
+}
+
+// libraryTags reads a model's tags from its page on ollama.com, in the
+// order the page lists them
+func libraryTags(ctx context.Context, ref modelReference) ([]string, error) {
+	page, err := fetchLibraryPage(ctx, "/"+ref.Repository+"/tags")
+	if errors.Is(err, errModelNotFound) {
+		return nil, fmt.Errorf("model %s not found in the Ollama library", ref.Short())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Official models live under /library on ollama.com, everything else
+	// under its namespace, and each tag links to /<model>:<tag>
+	model := strings.TrimPrefix(ref.Repository, DefaultNamespace+"/")
+	tagRegex := regexp.MustCompile(`href="/(?:library/)?` + regexp.QuoteMeta(model) + `:([^"/]+)"`)
+
+	tags := []string{}
+	seen := map[string]bool{}
+	for _, m := range tagRegex.FindAllStringSubmatch(page, -1) {
+		if !seen[m[1]] {
+			seen[m[1]] = true
+			tags = append(tags, m[1])
+		}
+	}
+	return tags, nil
+}
+
+// tags lists the tags of the session's repository
+func (p *registrySession) tags(ctx context.Context) ([]string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url("tags/list"), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := p.do(registryClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("model %s not found in %s", p.ref.Repository, p.ref.Registry)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned %s listing tags of %s", resp.Status, p.ref.Repository)
+	}
+
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+		return nil, fmt.Errorf("failed to decode tag list: %w", err)
+	}
+	return list.Tags, nil
+}
+
+// fetchManifest returns the raw manifest of a tag of the session's
+// repository, accepting both Ollama and OCI manifests
+func (p *registrySession) fetchManifest(ctx context.Context, tag string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.url("manifests/"+tag), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", OCIManifestMediaType+", "+ManifestMediaType)
+	resp, err := p.do(registryClient, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("registry returned %s for %s:%s", resp.Status, p.ref.Repository, tag)
+	}
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+	return raw, nil
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// TGIImage is the image a text-generation-inference runner is created from
+	TGIImage = "ghcr.io/huggingface/text-generation-inference:latest"
+	// TGIPort is the port TGI's router listens on in the container
+	TGIPort = 80
+	// ShardsLabel records how many GPUs a TGI runner shards its model across
+	ShardsLabel = "mocker.shards"
+)
+
+// tgiBackend serves a Hugging Face model with text-generation-inference,
+// through its OpenAI-compatible Messages API
+type tgiBackend struct{}
+
+func (tgiBackend) Name() string      { return "tgi" }
+func (tgiBackend) ReadyPath() string { return "/health" }
+
+// List reports the model TGI serves, from /info, as TGI versions differ in
+// whether they answer /v1/models
+func (tgiBackend) List(ctx context.Context) ([]localModel, error) {
+	resp, err := apiGet(ctx, "/info")
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errBackendUnreachable, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, openAIError(resp)
+	}
+
+	var info struct {
+		ModelID  string `json:"model_id"`
+		ModelSHA string `json:"model_sha"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&info); err != nil {
+		return nil, fmt.Errorf("failed to decode model info: %w", err)
+	}
+	return []localModel{{Name: info.ModelID, Digest: info.ModelSHA}}, nil
+}
+
+// Pull downloads a model's weights from the Hugging Face hub into the
+// runner's cache with TGI's own downloader, which also converts them to
+// safetensors where needed
+func (tgiBackend) Pull(ctx context.Context, name string, fn func(pullResponse)) error {
+	fn(pullResponse{Status: "downloading from Hugging Face"})
+	var output bytes.Buffer
+	if err := docker.Exec(ctx, containerName(), []string{"text-generation-server", "download-weights", name}, io.Discard, &output); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+	}
+	fn(pullResponse{Status: "success"})
+	return nil
+}
+
+func (tgiBackend) Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	return openAIGenerate(ctx, req, w, stallTimeout)
+}
+
+func (tgiBackend) Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	return openAIChat(ctx, req.Model, req.Messages, req.Options, nil, w, 0)
+}
+
+// Embed isn't something TGI does; Hugging Face serves embeddings with a
+// separate server, text-embeddings-inference
+func (tgiBackend) Embed(ctx context.Context, model string, inputs []string, _ any) ([][]float64, error) {
+	return nil, fmt.Errorf("%w: TGI only generates text", errUnsupportedByBackend)
+}
+
+// tgiHealthcheck has Docker check that TGI answers, using the curl its
+// image ships
+var tgiHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "curl", "-fs", "http://localhost:80/health"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 10 * time.Minute,
+	Retries:     3,
+}
+
+// createTGIRunner creates a runner that serves model with TGI on the
+// runner's port, then waits for it to download and load the model. TGI
+// needs a GPU, so all of them are used unless --gpus picks some. With
+// shards above one the model is split across that many GPUs
+func createTGIRunner(ctx context.Context, model string, shards int) error {
+	gpus := configOr(gpusFlag, "all")
+	spec := containerSpec{
+		Name:        containerName(),
+		Image:       TGIImage,
+		Cmd:         []string{"--model-id", model},
+		Env:         huggingFaceEnv(),
+		Labels:      map[string]string{BackendLabel: "tgi", ModelLabel: model, GPUsLabel: gpus},
+		Mounts:      []string{huggingFaceVolume() + ":/data"},
+		Ports:       map[int]int{ollamaPort(): TGIPort},
+		Healthcheck: tgiHealthcheck,
+		HostConfig: container.HostConfig{
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+			// Shards talk to each other through NCCL, which needs more shared
+			// memory than Docker's default 64MB
+			ShmSize: 1 << 30,
+		},
+	}
+	if shards > 1 {
+		spec.Cmd = append(spec.Cmd, "--sharded", "true", "--num-shard", strconv.Itoa(shards))
+		spec.Labels[ShardsLabel] = strconv.Itoa(shards)
+	}
+	if err := applyGPUs(&spec.HostConfig, gpus); err != nil {
+		return fmt.Errorf("invalid --gpus value %q: %w", gpus, err)
+	}
+	if err := applyResourceLimits(&spec); err != nil {
+		return err
+	}
+	if err := applyRunnerEnv(&spec); err != nil {
+		return err
+	}
+
+	if err := docker.CreateVolume(huggingFaceVolume()); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", huggingFaceVolume(), err)
+	}
+	if err := createRunner(ctx, spec); err != nil {
+		_ = docker.Remove(containerName())
+		if isGPUUnavailable(err.Error()) {
+			return fmt.Errorf("TGI needs a GPU, but %s can't provide one: %w", engineName(), err)
+		}
+		return classify(fmt.Errorf("failed to start TGI container: %w", err), errRunnerNotRunning)
+	}
+
+	if dockerCLI.Err().IsTerminal() {
+		stop := startSpinner(dockerCLI.Err(), "Waiting for TGI to load "+model)
+		defer stop()
+	}
+	return waitForAPI(ctx, tgiBackend{}.ReadyPath(), max(readyTimeout(), HubReadyTimeout))
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docker/cli/cli/command"
+	"github.com/docker/cli/cli/config"
+)
+
+const (
+	// StatusUpdateAvailable marks a model whose registry has a newer version
+	StatusUpdateAvailable = "update available"
+	// StatusChangedLocally marks a model that was replaced locally, by create,
+	// cp or import, after it was pulled. Updating it would lose the change
+	StatusChangedLocally = "changed locally"
+)
+
+// pulledModel records the version of a model that was pulled
+type pulledModel struct {
+	Digest string    `json:"digest"`        // model ID once installed
+	Remote string    `json:"remote"`        // digest of the registry's manifest
+	OCI    bool      `json:"oci,omitempty"` // pulled as an OCI artifact
+	Pulled time.Time `json:"pulled"`
+}
+
+// pullsPath returns the location of the pull ledger, next to the usage ledger
+func pullsPath() string {
+	return filepath.Join(config.Dir(), "mocker", "pulls.json")
+}
+
+// loadPulls reads the pull ledger, keyed by fully-qualified model reference
+func loadPulls() (map[string]pulledModel, error) {
+	pulls := map[string]pulledModel{}
+	if err := readLedger(pullsPath(), &pulls); err != nil {
+		return nil, err
+	}
+	return pulls, nil
+}
+
+// recordPull notes the version of a model that was just pulled. remote is
+// the registry's manifest digest when it differs from the model ID, as it
+// does for OCI artifacts. Like recordUsage, it never fails the command
+func recordPull(model, remote string, oci bool) {
+	models, err := listLocalModels(context.Background())
+	if err != nil {
+		return
+	}
+	ref := parseModelReference(model)
+	for _, m := range models {
+		if parseModelReference(m.Name) != ref {
+			continue
+		}
+		if remote == "" {
+			remote = m.Digest
+		}
+
+		pulls, err := loadPulls()
+		if err != nil {
+			pulls = map[string]pulledModel{}
+		}
+		pulls[ref.String()] = pulledModel{Digest: m.Digest, Remote: remote, OCI: oci, Pulled: time.Now().UTC()}
+		_ = writeLedger(pullsPath(), pulls)
+		return
+	}
+}
+
+// forgetPulls drops removed models from the pull ledger
+func forgetPulls(models []string) {
+	pulls, err := loadPulls()
+	if err != nil {
+		return
+	}
+	for _, m := range models {
+		delete(pulls, parseModelReference(m).String())
+	}
+	_ = writeLedger(pullsPath(), pulls)
+}
+
+// latestDigest returns the digest of a model's manifest in its registry,
+// authenticating with the registry's stored credentials
+func latestDigest(ctx context.Context, dockerCli command.Cli, name string) (string, error) {
+	ref := parseModelReference(name)
+	auth, err := dockerCli.ConfigFile().GetAuthConfig(ref.Registry)
+	if err != nil {
+		return "", err
+	}
+	s := newRegistrySession(auth, ref, false)
+	if err := s.authenticate(ctx, "pull"); err != nil {
+		return "", err
+	}
+	raw, err := s.fetchManifest(ctx, ref.Tag)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(raw)
+	return hex.EncodeToString(hash[:]), nil
+}
+
+// outdatedRow is an installed model that differs from its registry as
+// reported by outdated
+type outdatedRow struct {
+	Name    string `json:"name"`
+	Current string `json:"current"`
+	Latest  string `json:"latest"`
+	Status  string `json:"status"`
+
+	oci bool
+}
+
+// findOutdated checks installed models against their registries. Models
+// whose registry can't be checked, such as ones created locally, are
+// returned in unchecked with the reason
+func findOutdated(ctx context.Context, dockerCli command.Cli, models []localModel) (rows []outdatedRow, unchecked map[string]error, err error) {
+	pulls, err := loadPulls()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	results := make([]*outdatedRow, len(models))
+	errs := make([]error, len(models))
+	// Each check is a registry round trip, so run them side by side
+	var wg sync.WaitGroup
+	for i, m := range models {
+		wg.Add(1)
+		go func(i int, m localModel) {
+			defer wg.Done()
+			pulled, tracked := pulls[parseModelReference(m.Name).String()]
+
+			latest, err := latestDigest(ctx, dockerCli, m.Name)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+
+			row := &outdatedRow{Name: m.Name, Current: shortDigest(m.Digest), Latest: shortDigest(latest), oci: pulled.OCI}
+			switch {
+			case tracked && pulled.Digest != m.Digest:
+				row.Status = StatusChangedLocally
+			case tracked && pulled.Remote != latest, !tracked && m.Digest != latest:
+				row.Status = StatusUpdateAvailable
+			default:
+				return
+			}
+			results[i] = row
+		}(i, m)
+	}
+	wg.Wait()
+
+	rows = []outdatedRow{}
+	unchecked = map[string]error{}
+	for i, m := range models {
+		switch {
+		case errs[i] != nil:
+			unchecked[m.Name] = errs[i]
+		case results[i] != nil:
+			rows = append(rows, *results[i])
+		}
+	}
+	return rows, unchecked, nil
+}
+
+// shortDigest truncates a digest to the 12 characters used as a model ID
+func shortDigest(digest string) string {
+	if len(digest) > 12 {
+		return digest[:12]
+	}
+	return digest
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/docker/cli/cli/config"
+)
+
+// DefaultPruneAge is how long a model can go unused before prune removes it
+const DefaultPruneAge = 30 * 24 * time.Hour
+
+// usagePath returns the location of the usage ledger, which records when
+// each model was last run. It lives next to the config file
+func usagePath() string {
+	return filepath.Join(config.Dir(), "mocker", "usage.json")
+}
+
+// loadUsage reads the usage ledger, keyed by fully-qualified model reference
+func loadUsage() (map[string]time.Time, error) {
+	usage := map[string]time.Time{}
+	if err := readLedger(usagePath(), &usage); err != nil {
+		return nil, err
+	}
+	return usage, nil
+}
+
+// saveUsage writes the usage ledger
+func saveUsage(usage map[string]time.Time) error {
+	return writeLedger(usagePath(), usage)
+}
+
+// readLedger decodes one of mocker's JSON ledgers into v. A missing ledger
+// leaves v untouched
+func readLedger(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// writeLedger writes one of mocker's JSON ledgers, replacing it atomically
+// so concurrent runs can't leave it half-written
+func writeLedger(path string, v any) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), "ledger-*.json")
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// recordUsage notes that a model was just used. The ledger only guides
+// prune, so failing to update it never fails the command
+func recordUsage(model string) {
+	usage, err := loadUsage()
+	if err != nil {
+		usage = map[string]time.Time{}
+	}
+	usage[parseModelReference(model).String()] = time.Now().UTC()
+	_ = saveUsage(usage)
+}
+
+// lastUsed returns when a model was last run, or when it was pulled if
+// that's more recent or it has never been run
+func lastUsed(usage map[string]time.Time, m localModel) time.Time {
+	if used := usage[parseModelReference(m.Name).String()]; used.After(m.ModifiedAt) {
+		return used
+	}
+	return m.ModifiedAt
+}
+
+// forgetUsage drops removed models from the ledger so it doesn't grow forever
+func forgetUsage(models []string) {
+	usage, err := loadUsage()
+	if err != nil {
+		return
+	}
+	for _, m := range models {
+		delete(usage, parseModelReference(m).String())
+	}
+	_ = saveUsage(usage)
+}
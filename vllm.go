@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+const (
+	// VLLMImage is the image a vLLM runner is created from
+	VLLMImage = "vllm/vllm-openai:latest"
+	// VLLMPort is the port vLLM's OpenAI-compatible server listens on
+	VLLMPort = 8000
+)
+
+// vllmBackend serves a single Hugging Face model with vLLM, through its
+// OpenAI-compatible API
+type vllmBackend struct{}
+
+func (vllmBackend) Name() string      { return "vllm" }
+func (vllmBackend) ReadyPath() string { return "/health" }
+
+func (vllmBackend) List(ctx context.Context) ([]localModel, error) {
+	return openAIModels(ctx)
+}
+
+// Pull downloads a model from Hugging Face into the runner's cache, so a
+// runner created for it later starts without the wait
+func (vllmBackend) Pull(ctx context.Context, name string, fn func(pullResponse)) error {
+	fn(pullResponse{Status: "downloading from Hugging Face"})
+	script := "import sys; from huggingface_hub import snapshot_download; snapshot_download(sys.argv[1])"
+	var output bytes.Buffer
+	if err := docker.Exec(ctx, containerName(), []string{"python3", "-c", script, name}, io.Discard, &output); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		lines := strings.Split(strings.TrimSpace(output.String()), "\n")
+		return fmt.Errorf("%w: %s", err, lines[len(lines)-1])
+	}
+	fn(pullResponse{Status: "success"})
+	return nil
+}
+
+func (vllmBackend) Generate(ctx context.Context, req generateRequest, w io.Writer, stallTimeout time.Duration) (*generateStats, error) {
+	return openAIGenerate(ctx, req, w, stallTimeout)
+}
+
+func (vllmBackend) Chat(ctx context.Context, req chatRequest, w io.Writer) (chatMessage, *generateStats, error) {
+	return openAIChat(ctx, req.Model, req.Messages, req.Options, nil, w, 0)
+}
+
+func (vllmBackend) Embed(ctx context.Context, model string, inputs []string, _ any) ([][]float64, error) {
+	return openAIEmbed(ctx, model, inputs)
+}
+
+// vllmHealthcheck has Docker check that vLLM answers. The image has no curl,
+// so Python does the request
+var vllmHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "python3", "-c", "import urllib.request; urllib.request.urlopen('http://localhost:8000/health')"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	StartPeriod: 10 * time.Minute,
+	Retries:     3,
+}
+
+// createVLLMRunner creates a runner that serves model with vLLM on the
+// runner's port, then waits for it to load the model. vLLM needs an NVIDIA
+// GPU, so all of them are used unless --gpus picks some
+func createVLLMRunner(ctx context.Context, model string) error {
+	gpus := configOr(gpusFlag, "all")
+	spec := containerSpec{
+		Name:        containerName(),
+		Image:       VLLMImage,
+		Cmd:         []string{"--model", model},
+		Labels:      map[string]string{BackendLabel: "vllm", ModelLabel: model, GPUsLabel: gpus},
+		Mounts:      []string{huggingFaceVolume() + ":/root/.cache/huggingface"},
+		Ports:       map[int]int{ollamaPort(): VLLMPort},
+		Healthcheck: vllmHealthcheck,
+		HostConfig: container.HostConfig{
+			RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyUnlessStopped},
+			// vLLM's workers share tensors through shared memory
+			IpcMode: container.IPCModeHost,
+		},
+	}
+	if err := applyGPUs(&spec.HostConfig, gpus); err != nil {
+		return fmt.Errorf("invalid --gpus value %q: %w", gpus, err)
+	}
+	spec.Env = huggingFaceEnv()
+	if err := applyResourceLimits(&spec); err != nil {
+		return err
+	}
+	if err := applyRunnerEnv(&spec); err != nil {
+		return err
+	}
+
+	if err := docker.CreateVolume(huggingFaceVolume()); err != nil {
+		return fmt.Errorf("failed to create volume %s: %w", huggingFaceVolume(), err)
+	}
+	if err := createRunner(ctx, spec); err != nil {
+		_ = docker.Remove(containerName())
+		if isGPUUnavailable(err.Error()) {
+			return fmt.Errorf("vLLM needs an NVIDIA GPU, but %s can't provide one: %w", engineName(), err)
+		}
+		return classify(fmt.Errorf("failed to start vLLM container: %w", err), errRunnerNotRunning)
+	}
+
+	if dockerCLI.Err().IsTerminal() {
+		stop := startSpinner(dockerCLI.Err(), "Waiting for vLLM to load "+model)
+		defer stop()
+	}
+	return waitForAPI(ctx, vllmBackend{}.ReadyPath(), max(readyTimeout(), HubReadyTimeout))
+}